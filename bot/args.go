@@ -0,0 +1,364 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	userMentionPattern    = regexp.MustCompile(`^<@!?(\d+)>$`)
+	roleMentionPattern    = regexp.MustCompile(`^<@&(\d+)>$`)
+	channelMentionPattern = regexp.MustCompile(`^<#(\d+)>$`)
+	snowflakePattern      = regexp.MustCompile(`^\d{15,21}$`)
+)
+
+// UsageError reports invalid arguments to a prefix command
+type UsageError struct {
+	Err   error
+	Usage string
+}
+
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("%v\nUsage: `%s`", e.Err, e.Usage)
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// tokenizeArgs splits command arguments on whitespace, keeping quoted
+// strings together. Both single and double quotes are supported and a
+// backslash escapes the next character. A quote only opens a quoted string
+// at the start of an argument, so apostrophes in words like "don't" are
+// kept as they are.
+func tokenizeArgs(input string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	var quote rune
+	inToken := false
+	escaped := false
+
+	for _, r := range input {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && !inToken:
+			quote = r
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens, nil
+}
+
+// argParser converts prefix command arguments into the same option values
+// Discord sends for slash commands, resolving mentions against state
+type argParser struct {
+	session Session
+	guildID string
+}
+
+// parse parses tokenized arguments against an option schema.
+// Options can be given as "--name value", "--name=value" or positionally in
+// declaration order. The last string option takes any remaining arguments.
+func (p *argParser) parse(options []*discordgo.ApplicationCommandOption, args []string) ([]*discordgo.ApplicationCommandInteractionDataOption, error) {
+	byName := make(map[string]*discordgo.ApplicationCommandOption, len(options))
+	for _, opt := range options {
+		byName[opt.Name] = opt
+	}
+
+	values := make(map[string]string)
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		name = strings.ToLower(name)
+		opt, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown option `--%s`", name)
+		}
+		if _, dup := values[name]; dup {
+			return nil, fmt.Errorf("option `--%s` was given more than once", name)
+		}
+
+		if !hasValue {
+			switch {
+			case opt.Type == discordgo.ApplicationCommandOptionBoolean && !nextIsBool(args, i):
+				// A bare boolean flag means true
+				value = "true"
+			case i+1 < len(args):
+				i++
+				value = args[i]
+			default:
+				return nil, fmt.Errorf("option `--%s` needs a value", name)
+			}
+		}
+
+		values[name] = value
+	}
+
+	// Fill the remaining options positionally
+	var remaining []*discordgo.ApplicationCommandOption
+	for _, opt := range options {
+		if _, ok := values[opt.Name]; !ok {
+			remaining = append(remaining, opt)
+		}
+	}
+
+	for i, opt := range remaining {
+		if len(positional) == 0 {
+			break
+		}
+		if i == len(remaining)-1 && opt.Type == discordgo.ApplicationCommandOptionString {
+			values[opt.Name] = strings.Join(positional, " ")
+			positional = nil
+			break
+		}
+		values[opt.Name] = positional[0]
+		positional = positional[1:]
+	}
+
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("too many arguments")
+	}
+
+	// Convert values in declaration order
+	var parsed []*discordgo.ApplicationCommandInteractionDataOption
+	for _, opt := range options {
+		raw, ok := values[opt.Name]
+		if !ok {
+			if opt.Required {
+				return nil, fmt.Errorf("missing required argument `%s`", opt.Name)
+			}
+			continue
+		}
+
+		value, err := p.convert(opt, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for `%s`: %w", opt.Name, err)
+		}
+
+		parsed = append(parsed, &discordgo.ApplicationCommandInteractionDataOption{
+			Name:  opt.Name,
+			Type:  opt.Type,
+			Value: value,
+		})
+	}
+
+	return parsed, nil
+}
+
+// convert turns a raw argument into the value Discord would send for the
+// option. Numbers are float64 and entities are IDs, as in interaction JSON.
+func (p *argParser) convert(opt *discordgo.ApplicationCommandOption, raw string) (interface{}, error) {
+	var value interface{}
+	var err error
+
+	switch opt.Type {
+	case discordgo.ApplicationCommandOptionString:
+		if opt.MinLength != nil && len(raw) < *opt.MinLength {
+			return nil, fmt.Errorf("must be at least %d characters", *opt.MinLength)
+		}
+		if opt.MaxLength > 0 && len(raw) > opt.MaxLength {
+			return nil, fmt.Errorf("must be at most %d characters", opt.MaxLength)
+		}
+		value = raw
+	case discordgo.ApplicationCommandOptionInteger:
+		n, parseErr := strconv.ParseInt(raw, 10, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("%q is not a whole number", raw)
+		}
+		value, err = float64(n), checkRange(opt, float64(n))
+	case discordgo.ApplicationCommandOptionNumber:
+		n, parseErr := strconv.ParseFloat(raw, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		value, err = n, checkRange(opt, n)
+	case discordgo.ApplicationCommandOptionBoolean:
+		b, ok := parseBoolArg(raw)
+		if !ok {
+			return nil, fmt.Errorf("%q is not yes or no", raw)
+		}
+		value = b
+	case discordgo.ApplicationCommandOptionUser:
+		value, err = p.resolveUser(raw)
+	case discordgo.ApplicationCommandOptionRole:
+		value, err = p.resolveRole(raw)
+	case discordgo.ApplicationCommandOptionChannel:
+		value, err = p.resolveChannel(opt, raw)
+	case discordgo.ApplicationCommandOptionMentionable:
+		if value, err = p.resolveUser(raw); err != nil {
+			value, err = p.resolveRole(raw)
+		}
+	default:
+		return nil, fmt.Errorf("option type %s is not supported for prefix commands", opt.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opt.Choices) > 0 {
+		return matchChoice(opt.Choices, value, raw)
+	}
+
+	return value, nil
+}
+
+// checkRange validates a number against the option's bounds
+func checkRange(opt *discordgo.ApplicationCommandOption, n float64) error {
+	if opt.MinValue != nil && n < *opt.MinValue {
+		return fmt.Errorf("must be at least %v", *opt.MinValue)
+	}
+	if opt.MaxValue != 0 && n > opt.MaxValue {
+		return fmt.Errorf("must be at most %v", opt.MaxValue)
+	}
+	return nil
+}
+
+// matchChoice maps a value or choice name onto one of the declared choices
+func matchChoice(choices []*discordgo.ApplicationCommandOptionChoice, value interface{}, raw string) (interface{}, error) {
+	names := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		if fmt.Sprint(choice.Value) == fmt.Sprint(value) || strings.EqualFold(choice.Name, raw) {
+			// Keep the converted value so numeric choices stay float64
+			if fmt.Sprint(choice.Value) == fmt.Sprint(value) {
+				return value, nil
+			}
+			return convertChoiceValue(choice.Value), nil
+		}
+		names = append(names, choice.Name)
+	}
+	return nil, fmt.Errorf("must be one of: %s", strings.Join(names, ", "))
+}
+
+// convertChoiceValue normalizes numeric choice values to float64
+func convertChoiceValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+// nextIsBool reports whether the argument after i is a boolean value
+func nextIsBool(args []string, i int) bool {
+	if i+1 >= len(args) {
+		return false
+	}
+	_, ok := parseBoolArg(args[i+1])
+	return ok
+}
+
+// parseBoolArg accepts the usual spellings of yes and no
+func parseBoolArg(raw string) (bool, bool) {
+	switch strings.ToLower(raw) {
+	case "true", "yes", "y", "on", "1":
+		return true, true
+	case "false", "no", "n", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// resolveUser accepts a user mention or ID. IDs given without a mention must
+// belong to a cached member of the guild.
+func (p *argParser) resolveUser(raw string) (string, error) {
+	if m := userMentionPattern.FindStringSubmatch(raw); m != nil {
+		return m[1], nil
+	}
+	if snowflakePattern.MatchString(raw) {
+		if p.guildID == "" {
+			return raw, nil
+		}
+		if _, err := p.session.StateMember(p.guildID, raw); err == nil {
+			return raw, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a member of this server", raw)
+}
+
+// resolveRole accepts a role mention or ID of a role in the guild
+func (p *argParser) resolveRole(raw string) (string, error) {
+	id := raw
+	if m := roleMentionPattern.FindStringSubmatch(raw); m != nil {
+		id = m[1]
+	}
+	if p.guildID != "" && snowflakePattern.MatchString(id) {
+		if _, err := p.session.StateRole(p.guildID, id); err == nil {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a role in this server", raw)
+}
+
+// resolveChannel accepts a channel mention or ID of a channel in the guild,
+// restricted to the option's channel types if any are declared
+func (p *argParser) resolveChannel(opt *discordgo.ApplicationCommandOption, raw string) (string, error) {
+	id := raw
+	if m := channelMentionPattern.FindStringSubmatch(raw); m != nil {
+		id = m[1]
+	}
+
+	channel, err := p.session.StateChannel(id)
+	if err != nil || (p.guildID != "" && channel.GuildID != p.guildID) {
+		return "", fmt.Errorf("%q is not a channel in this server", raw)
+	}
+
+	if len(opt.ChannelTypes) > 0 {
+		allowed := false
+		for _, t := range opt.ChannelTypes {
+			if channel.Type == t {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", fmt.Errorf("<#%s> is not the right kind of channel", id)
+		}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTokenizeArgs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`play some song`, []string{"play", "some", "song"}},
+		{`say "hello world" 'and more'`, []string{"say", "hello world", "and more"}},
+		{`say it\'s  fine`, []string{"say", "it's", "fine"}},
+		{`empty ""`, []string{"empty", ""}},
+		{`say don't stop`, []string{"say", "don't", "stop"}},
+		{`say it's "quoted arg"`, []string{"say", "it's", "quoted arg"}},
+		{`say rock'n'roll "ok"`, []string{"say", "rock'n'roll", "ok"}},
+	}
+
+	for _, tt := range tests {
+		got, err := tokenizeArgs(tt.input)
+		if err != nil {
+			t.Fatalf("tokenizeArgs(%q) failed: %v", tt.input, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeArgs(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := tokenizeArgs(`say "oops`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestArgParserTypedOptions(t *testing.T) {
+	_, session := newTestBot()
+	addTestGuild(t, session, 0)
+	session.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "123456789012345678"}})
+	session.State.RoleAdd(testGuildID, &discordgo.Role{ID: "223456789012345678", Name: "Mods"})
+
+	options := []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Required: true},
+		{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Required: true},
+		{Type: discordgo.ApplicationCommandOptionInteger, Name: "count"},
+		{Type: discordgo.ApplicationCommandOptionBoolean, Name: "silent"},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "reason"},
+	}
+
+	parser := &argParser{session: session, guildID: testGuildID}
+	args, _ := tokenizeArgs(`<@!123456789012345678> --silent 223456789012345678 --count=3 "being helpful" today`)
+
+	parsed, err := parser.parse(options, args)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	ctx := &CommandContext{Options: parsed}
+	if got := ctx.Option("user").UserValue(nil).ID; got != "123456789012345678" {
+		t.Errorf("user = %q", got)
+	}
+	if got := ctx.Option("role").RoleValue(nil, "").ID; got != "223456789012345678" {
+		t.Errorf("role = %q", got)
+	}
+	if got := ctx.IntOption("count", 0); got != 3 {
+		t.Errorf("count = %d", got)
+	}
+	if !ctx.BoolOption("silent", false) {
+		t.Error("expected silent to be true")
+	}
+	if got := ctx.StringOption("reason"); got != "being helpful today" {
+		t.Errorf("reason = %q", got)
+	}
+}
+
+func TestArgParserErrors(t *testing.T) {
+	_, session := newTestBot()
+	addTestGuild(t, session, 0)
+
+	parser := &argParser{session: session, guildID: testGuildID}
+	options := []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Required: true},
+		{Type: discordgo.ApplicationCommandOptionInteger, Name: "count"},
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "missing required argument `role`"},
+		{[]string{"999999999999999999"}, "not a role in this server"},
+		{[]string{testRoleID + "0000000000000", "abc"}, "not a role"},
+		{[]string{"--unknown", "x"}, "unknown option `--unknown`"},
+		{[]string{"<@&" + testRoleID + ">", "--count"}, "needs a value"},
+	}
+
+	for _, tt := range tests {
+		_, err := parser.parse(options, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parse(%q) error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestHandlePrefixCommandReportsUsage(t *testing.T) {
+	b, session := newTestBot()
+	b.Commands.RegisterCommand(Command{
+		Name:        "repeat",
+		Description: "Repeats a message",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "times", Required: true},
+		},
+		Handler: func(ctx *CommandContext) error {
+			return errors.New("handler should not run")
+		},
+	})
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!repeat lots"), "repeat", []string{"lots"})
+
+	sent := session.Calls("ChannelMessageSend")
+	if len(sent) != 1 {
+		t.Fatalf("expected a usage message, got %v", sent)
+	}
+	if msg := sent[0].Args[1].(string); !strings.Contains(msg, "\"lots\" is not a whole number") || !strings.Contains(msg, "Usage: `!repeat <times>`") {
+		t.Errorf("unexpected usage message: %q", msg)
+	}
+}
+
+func TestHandlePrefixMessagePassesArgsToLegacyHandlers(t *testing.T) {
+	b, session := newTestBot()
+
+	var got []string
+	b.Commands.PrefixCommands["echo"] = PrefixCommand{
+		Name:  "echo",
+		Usage: "echo <words>",
+		Handler: func(s Session, m *discordgo.MessageCreate, args []string) {
+			got = args
+		},
+	}
+
+	b.Commands.HandlePrefixMessage(session, newTestMessage("!echo https://example.com/a.ogg \"two words\""), "echo https://example.com/a.ogg \"two words\"")
+
+	if want := []string{"https://example.com/a.ogg", "two words"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the handler to get %q, got %q", want, got)
+	}
+	if sent := session.Calls("ChannelMessageSend"); len(sent) != 0 {
+		t.Errorf("expected no usage message, got %v", sent)
+	}
+}
@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AudioSource opens the audio of a track from the location a user asked
+// for, such as a URL or a file name. The stream is closed by the caller.
+type AudioSource interface {
+	Open(ctx context.Context, location string) (io.ReadCloser, error)
+}
+
+// AudioSourceFunc adapts a function to the AudioSource interface
+type AudioSourceFunc func(ctx context.Context, location string) (io.ReadCloser, error)
+
+// Open calls f
+func (f AudioSourceFunc) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	return f(ctx, location)
+}
+
+// ErrUnsupportedLocation is returned for locations an audio source can't open
+var ErrUnsupportedLocation = errors.New("unsupported audio location")
+
+// ErrBlockedAddress is returned for URLs that resolve to loopback, private
+// or link-local addresses, so /play can't reach the bot's own network
+var ErrBlockedAddress = errors.New("audio URLs can't point to local or private addresses")
+
+// audioHeaderTimeout is how long an HTTP audio server has to start responding
+const audioHeaderTimeout = 10 * time.Second
+
+// maxHTTPAudioSize is the largest audio stream fetched over HTTP, in bytes
+const maxHTTPAudioSize = 100 << 20
+
+// sharedAddressSpace is the carrier-grade NAT range, which some clouds use
+// for internal services
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// HTTPAudioSource streams audio from http and https URLs
+type HTTPAudioSource struct {
+	Client  *http.Client
+	MaxSize int64 // Largest stream accepted, in bytes
+}
+
+// NewHTTPAudioSource creates an HTTP audio source. Streams have no overall
+// timeout, since a track can take minutes to play, but the server has to
+// start responding within audioHeaderTimeout. Connections to non-public
+// addresses are refused after DNS resolution, including on redirects.
+func NewHTTPAudioSource() *HTTPAudioSource {
+	dialer := &net.Dialer{
+		Timeout: audioHeaderTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &HTTPAudioSource{
+		Client: &http.Client{
+			// No proxy, since the dialer could only check the proxy's address
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				ResponseHeaderTimeout: audioHeaderTimeout,
+			},
+		},
+		MaxSize: maxHTTPAudioSize,
+	}
+}
+
+// publicIP reports whether ip is a public unicast address
+func publicIP(ip net.IP) bool {
+	return ip != nil &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsUnspecified() &&
+		!ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// audioContentType reports whether a response's content type may be audio.
+// Servers often send files as application/octet-stream or without a type.
+func audioContentType(header string) bool {
+	if header == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "audio/") || mediaType == "application/ogg" || mediaType == "application/octet-stream"
+}
+
+// limitedBody closes the response body behind a size limit
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Open starts streaming a URL
+func (s *HTTPAudioSource) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrUnsupportedLocation
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, fmt.Errorf("error requesting audio: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("audio server responded with %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !audioContentType(contentType) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the URL isn't audio (%s)", contentType)
+	}
+
+	if s.MaxSize <= 0 {
+		return resp.Body, nil
+	}
+	if resp.ContentLength > s.MaxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the audio is larger than %d MB", s.MaxSize>>20)
+	}
+	return limitedBody{Reader: io.LimitReader(resp.Body, s.MaxSize), Closer: resp.Body}, nil
+}
+
+// FileAudioSource plays audio files from a local directory
+type FileAudioSource struct {
+	Dir string
+}
+
+// Open opens a file in the directory. Locations are always resolved inside
+// the directory, so users can't play arbitrary files from the host.
+func (s *FileAudioSource) Open(_ context.Context, location string) (io.ReadCloser, error) {
+	if s.Dir == "" || location == "" {
+		return nil, ErrUnsupportedLocation
+	}
+
+	// Cleaning a rooted path drops any leading "..", keeping it inside Dir
+	name := filepath.Join(s.Dir, filepath.Clean("/"+filepath.FromSlash(location)))
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no audio file named %q", location)
+		}
+		return nil, fmt.Errorf("error opening audio file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("no audio file named %q", location)
+	}
+
+	return f, nil
+}
+
+// DefaultAudioSource streams URLs over HTTP and opens anything else as a
+// file in the local audio directory, if one is configured
+type DefaultAudioSource struct {
+	HTTP  AudioSource
+	Files AudioSource
+}
+
+// NewDefaultAudioSource creates the audio source used by /play. An empty
+// dir disables local files.
+func NewDefaultAudioSource(dir string) *DefaultAudioSource {
+	return &DefaultAudioSource{
+		HTTP:  NewHTTPAudioSource(),
+		Files: &FileAudioSource{Dir: dir},
+	}
+}
+
+// Open opens a URL or a local file
+func (s *DefaultAudioSource) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return s.HTTP.Open(ctx, location)
+	}
+	return s.Files.Open(ctx, location)
+}
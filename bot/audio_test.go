@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAudioSourceStaysInDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.dca"), []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := &FileAudioSource{Dir: dir}
+
+	stream, err := source.Open(context.Background(), "song.dca")
+	if err != nil {
+		t.Fatalf("opening file: %v", err)
+	}
+	data, _ := io.ReadAll(stream)
+	stream.Close()
+	if string(data) != "audio" {
+		t.Errorf("unexpected contents %q", data)
+	}
+
+	for _, location := range []string{"../secret", "/../secret", ".", "missing.dca"} {
+		if stream, err := source.Open(context.Background(), location); err == nil {
+			stream.Close()
+			t.Errorf("expected %q not to open", location)
+		}
+	}
+
+	if _, err := (&FileAudioSource{}).Open(context.Background(), "song.dca"); !errors.Is(err, ErrUnsupportedLocation) {
+		t.Errorf("expected files to be disabled without a directory, got %v", err)
+	}
+}
+
+func TestHTTPAudioSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/song.dca":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("audio"))
+		case "/long.ogg":
+			w.Header().Set("Content-Type", "audio/ogg")
+			w.Write([]byte("audio that goes on and on"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The test server is on loopback, which the default client refuses
+	if _, err := NewDefaultAudioSource("").Open(context.Background(), server.URL+"/song.dca"); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected a loopback URL to be refused, got %v", err)
+	}
+
+	source := &DefaultAudioSource{HTTP: &HTTPAudioSource{Client: server.Client(), MaxSize: 10}, Files: &FileAudioSource{}}
+
+	stream, err := source.Open(context.Background(), server.URL+"/song.dca")
+	if err != nil {
+		t.Fatalf("opening URL: %v", err)
+	}
+	data, _ := io.ReadAll(stream)
+	stream.Close()
+	if string(data) != "audio" {
+		t.Errorf("unexpected contents %q", data)
+	}
+
+	if _, err := source.Open(context.Background(), server.URL+"/long.ogg"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected a stream over the limit to be refused, got %v", err)
+	}
+	if _, err := source.Open(context.Background(), server.URL+"/page.html"); err == nil {
+		t.Error("expected an HTML page to be refused")
+	}
+	if _, err := source.Open(context.Background(), server.URL+"/missing.dca"); err == nil {
+		t.Error("expected a missing URL to fail")
+	}
+	if _, err := source.Open(context.Background(), "song.dca"); !errors.Is(err, ErrUnsupportedLocation) {
+		t.Errorf("expected local files to be disabled, got %v", err)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for address, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.100.100.200": false,
+		"0.0.0.0":         false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := publicIP(net.ParseIP(address)); got != want {
+			t.Errorf("publicIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAutocompleteChoices is the most choices Discord accepts in an autocomplete response
+const maxAutocompleteChoices = 25
+
+// AutocompleteHandler returns suggestions for an option as the user types.
+// Results beyond Discord's limit of 25 are dropped.
+type AutocompleteHandler func(ctx *AutocompleteContext) []*discordgo.ApplicationCommandOptionChoice
+
+// AutocompleteContext describes the option being typed and the options
+// already filled in
+type AutocompleteContext struct {
+	Session     Session
+	Name        string
+	GuildID     string
+	ChannelID   string
+	UserID      string
+	Interaction *discordgo.InteractionCreate
+
+	// Focused is the option being typed
+	Focused *discordgo.ApplicationCommandInteractionDataOption
+
+	// Options holds every option sent with the request, including Focused
+	Options []*discordgo.ApplicationCommandInteractionDataOption
+}
+
+// Value returns the partially typed value of the focused option
+func (ctx *AutocompleteContext) Value() string {
+	if ctx.Focused.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(ctx.Focused.Value)
+}
+
+// Option returns another option by name, or nil if it was not provided
+func (ctx *AutocompleteContext) Option(name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range ctx.Options {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// HandleAutocomplete responds to an autocomplete request with the choices
+// from the focused option's callback
+func (h *CommandHandler) HandleAutocomplete(s Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+
+	// Options of a subcommand are nested one level down
+	options := data.Options
+	if len(options) > 0 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		options = options[0].Options
+	}
+
+	var focused *discordgo.ApplicationCommandInteractionDataOption
+	for _, opt := range options {
+		if opt.Focused {
+			focused = opt
+			break
+		}
+	}
+
+	// Discord needs a list of choices, even an empty one
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	if cmd, ok := h.SlashCommands[data.Name]; ok && focused != nil {
+		if callback := cmd.Autocomplete[focused.Name]; callback != nil {
+			suggested := callback(&AutocompleteContext{
+				Session:     s,
+				Name:        data.Name,
+				GuildID:     i.GuildID,
+				ChannelID:   i.ChannelID,
+				UserID:      interactionUserID(i.Interaction),
+				Interaction: i,
+				Focused:     focused,
+				Options:     options,
+			})
+			if suggested != nil {
+				choices = suggested
+			}
+		}
+	}
+
+	if len(choices) > maxAutocompleteChoices {
+		choices = choices[:maxAutocompleteChoices]
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Error responding to autocomplete for %s: %v", data.Name, err)
+	}
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newTestAutocomplete builds an autocomplete request with the given option focused
+func newTestAutocomplete(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := newTestInteraction(name, options...)
+	i.Type = discordgo.InteractionApplicationCommandAutocomplete
+	return i
+}
+
+func TestHelpAutocomplete(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleAutocomplete(session, newTestAutocomplete("help", &discordgo.ApplicationCommandInteractionDataOption{
+		Name:    "command",
+		Type:    discordgo.ApplicationCommandOptionString,
+		Value:   "P",
+		Focused: true,
+	}))
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Type != discordgo.InteractionApplicationCommandAutocompleteResult {
+		t.Fatalf("expected an autocomplete result, got %+v", responses)
+	}
+
+	var names []string
+	for _, choice := range responses[0].Data.Choices {
+		names = append(names, choice.Name)
+	}
+	if fmt.Sprint(names) != "[pause ping play]" {
+		t.Errorf("unexpected choices %v", names)
+	}
+}
+
+func TestAutocompleteCapsChoices(t *testing.T) {
+	b, session := newTestBot()
+
+	var level string
+	b.Commands.SlashCommands["many"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{Name: "many", Description: "Many choices"},
+		Autocomplete: map[string]AutocompleteHandler{
+			"item": func(ctx *AutocompleteContext) []*discordgo.ApplicationCommandOptionChoice {
+				level = ctx.Option("level").StringValue()
+
+				var choices []*discordgo.ApplicationCommandOptionChoice
+				for n := 0; n < 40; n++ {
+					choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: fmt.Sprint(n), Value: n})
+				}
+				return choices
+			},
+		},
+	}
+
+	b.Commands.HandleAutocomplete(session, newTestAutocomplete("many", &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "sub",
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "level", Type: discordgo.ApplicationCommandOptionString, Value: "high"},
+			{Name: "item", Type: discordgo.ApplicationCommandOptionString, Value: "", Focused: true},
+		},
+	}))
+
+	if level != "high" {
+		t.Errorf("expected the other options to be passed, got level %q", level)
+	}
+	if choices := session.Responses()[0].Data.Choices; len(choices) != maxAutocompleteChoices {
+		t.Errorf("expected %d choices, got %d", maxAutocompleteChoices, len(choices))
+	}
+}
+
+func TestAutocompleteWithoutCallback(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleAutocomplete(session, newTestAutocomplete("ping"))
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Data.Choices == nil || len(responses[0].Data.Choices) != 0 {
+		t.Errorf("expected an empty autocomplete result, got %+v", responses)
+	}
+}
+
+func TestDiscordSessionSendsEmptyChoices(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	endpoint := discordgo.EndpointInteractionResponse
+	discordgo.EndpointInteractionResponse = func(iID, iToken string) string { return server.URL + "/" + iID }
+	t.Cleanup(func() { discordgo.EndpointInteractionResponse = endpoint })
+
+	dg, _ := discordgo.New("Bot token")
+	dg.Client = server.Client()
+	err := NewSession(dg).InteractionRespond(&discordgo.Interaction{ID: "1", Token: "t"}, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: []*discordgo.ApplicationCommandOptionChoice{}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body != `{"data":{"choices":[]},"type":8}` {
+		t.Errorf("expected an explicit empty list of choices, got %s", body)
+	}
+}
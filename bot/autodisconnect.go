@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+	"github.com/sirupsen/logrus"
+)
+
+// voiceCheckInterval is how often voice connections are checked for
+// timeouts, so the bot leaves within this long of one running out
+const voiceCheckInterval = 15 * time.Second
+
+// Limits of the voice timeouts set with /settings voice, in minutes
+const (
+	maxVoiceEmptyMinutes = 60
+	maxVoiceIdleMinutes  = 24 * 60
+)
+
+// minVoiceTimeout is the lowest timeout /settings voice accepts; 0 turns
+// the timeout off
+var minVoiceTimeout = 0.0
+
+// VoiceSettingsStore persists the voice auto-disconnect settings of guilds
+type VoiceSettingsStore interface {
+	GetVoiceSettings(guildID string) (*database.VoiceSettings, error)
+	SaveVoiceSettings(settings *database.VoiceSettings) error
+}
+
+// voiceListeners counts the members in a voice channel, not counting bots
+func voiceListeners(s Session, guildID, channelID string) (int, error) {
+	guild, err := s.StateGuild(guildID)
+	if err != nil {
+		return 0, err
+	}
+
+	listeners := 0
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID != channelID || vs.UserID == s.BotUserID() {
+			continue
+		}
+		if vs.Member != nil && vs.Member.User != nil && vs.Member.User.Bot {
+			continue
+		}
+		listeners++
+	}
+	return listeners, nil
+}
+
+// markAlone records whether the bot is alone in its channel. It returns
+// when everyone else left, or the zero time if it isn't alone.
+func (vc *VoiceConnection) markAlone(alone bool, now time.Time) time.Time {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	if !alone {
+		vc.aloneSince = time.Time{}
+	} else if vc.aloneSince.IsZero() {
+		vc.aloneSince = now
+	}
+	return vc.aloneSince
+}
+
+// markIdle records whether audio is playing. It returns when playback
+// stopped or was paused, or the zero time if audio is playing.
+func (vc *VoiceConnection) markIdle(now time.Time) time.Time {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	if vc.Playing && !vc.Paused {
+		vc.idleSince = time.Time{}
+	} else if vc.idleSince.IsZero() {
+		vc.idleSince = now
+	}
+	return vc.idleSince
+}
+
+// disconnect leaves the voice channel of vc, unless the bot has left or
+// moved since. It reports whether it left.
+func (vm *VoiceManager) disconnect(vc *VoiceConnection) bool {
+	vm.Mu.Lock()
+	defer vm.Mu.Unlock()
+
+	if vm.Connections[vc.GuildID] != vc {
+		return false
+	}
+
+	// The queue stops once its player finds the connection gone
+	vc.close()
+	if err := vc.Conn.Disconnect(); err != nil {
+		logrus.Warnf("Error disconnecting from voice channel: %v", err)
+	}
+	delete(vm.Connections, vc.GuildID)
+	return true
+}
+
+// connections returns every open voice connection
+func (vm *VoiceManager) connections() []*VoiceConnection {
+	vm.Mu.Lock()
+	defer vm.Mu.Unlock()
+
+	connections := make([]*VoiceConnection, 0, len(vm.Connections))
+	for _, vc := range vm.Connections {
+		connections = append(connections, vc)
+	}
+	return connections
+}
+
+// voiceDisconnecter periodically leaves voice channels that are empty or idle
+func (b *Bot) voiceDisconnecter() {
+	ticker := time.NewTicker(voiceCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		b.disconnectIdleVoice(now)
+	}
+}
+
+// disconnectIdleVoice leaves the voice channels whose guild's timeouts ran
+// out by now, posting a notice where the bot was asked to join from
+func (b *Bot) disconnectIdleVoice(now time.Time) {
+	for _, vc := range b.Voice.connections() {
+		listeners, err := voiceListeners(b.Session, vc.GuildID, vc.ChannelID)
+		if err != nil {
+			logrus.Warnf("Error counting voice listeners in guild %s: %v", vc.GuildID, err)
+			continue
+		}
+		aloneSince := vc.markAlone(listeners == 0, now)
+		idleSince := vc.markIdle(now)
+		if aloneSince.IsZero() && idleSince.IsZero() {
+			continue
+		}
+
+		settings, err := b.VoiceSettings.GetVoiceSettings(vc.GuildID)
+		if err != nil {
+			logrus.Errorf("Error loading voice settings: %v", err)
+			continue
+		}
+
+		var notice string
+		switch {
+		case timedOut(aloneSince, settings.EmptyTimeout, now):
+			notice = fmt.Sprintf("Left <#%s> because everyone else left.", vc.ChannelID)
+		case timedOut(idleSince, settings.IdleTimeout, now):
+			notice = fmt.Sprintf("Left <#%s> after %s without playing anything.", vc.ChannelID, describeMinutes(settings.IdleTimeout))
+		default:
+			continue
+		}
+
+		if b.Voice.disconnect(vc) {
+			b.Voice.notify(vc.TextChannelID, notice)
+		}
+	}
+}
+
+// timedOut reports whether a timeout that started at since has run out by
+// now. Timeouts that haven't started, or are off, never run out.
+func timedOut(since time.Time, timeout time.Duration, now time.Time) bool {
+	return !since.IsZero() && timeout > 0 && now.Sub(since) >= timeout
+}
+
+// describeMinutes describes a timeout in whole minutes
+func describeMinutes(d time.Duration) string {
+	minutes := int(d / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// onVoiceStateUpdate keeps track of who is listening with the bot. The
+// state cache already has the change by the time handlers run.
+func (b *Bot) onVoiceStateUpdate(_ *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	vc, ok := b.Voice.Connection(v.GuildID)
+	if !ok {
+		return
+	}
+
+	left := v.BeforeUpdate != nil && v.BeforeUpdate.ChannelID == vc.ChannelID && v.ChannelID != vc.ChannelID
+
+	if v.UserID == b.Session.BotUserID() {
+		// Disconnected by a moderator or by Discord. When the bot leaves on
+		// its own, the connection is gone before the update arrives.
+		if left && v.ChannelID == "" && b.Voice.disconnect(vc) {
+			b.Voice.notify(vc.TextChannelID, fmt.Sprintf("I was disconnected from <#%s>.", vc.ChannelID))
+		}
+		return
+	}
+
+	if !left && v.ChannelID != vc.ChannelID {
+		return
+	}
+
+	listeners, err := voiceListeners(b.Session, v.GuildID, vc.ChannelID)
+	if err != nil {
+		logrus.Warnf("Error counting voice listeners in guild %s: %v", v.GuildID, err)
+		return
+	}
+	vc.markAlone(listeners == 0, time.Now())
+}
+
+// describeVoiceSettings describes a guild's voice timeouts in a sentence
+func describeVoiceSettings(settings *database.VoiceSettings) string {
+	empty := "I stay in voice channels when everyone else leaves."
+	if settings.EmptyTimeout > 0 {
+		empty = fmt.Sprintf("I leave voice channels %s after everyone else leaves.", describeMinutes(settings.EmptyTimeout))
+	}
+
+	idle := "I stay when nothing is playing."
+	if settings.IdleTimeout > 0 {
+		idle = fmt.Sprintf("I leave after %s without playing anything.", describeMinutes(settings.IdleTimeout))
+	}
+	return empty + " " + idle
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+)
+
+// joinTestVoice connects the bot to the test voice channel, as if /play
+// had been used in the test text channel
+func joinTestVoice(t *testing.T, b *Bot, session *fakeSession) *VoiceConnection {
+	t.Helper()
+
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	vc, err := b.Voice.JoinVoiceChannel(testGuildID, testVoiceChannelID, testChannelID)
+	if err != nil {
+		t.Fatalf("joining voice channel: %v", err)
+	}
+	return vc
+}
+
+// leaveTestVoice takes the test user out of the voice channel and sends the
+// update the gateway would
+func leaveTestVoice(b *Bot, session *fakeSession) {
+	guild, _ := session.State.Guild(testGuildID)
+	guild.VoiceStates = nil
+
+	b.onVoiceStateUpdate(nil, &discordgo.VoiceStateUpdate{
+		VoiceState:   &discordgo.VoiceState{GuildID: testGuildID, UserID: testUserID},
+		BeforeUpdate: &discordgo.VoiceState{GuildID: testGuildID, UserID: testUserID, ChannelID: testVoiceChannelID},
+	})
+}
+
+// sentNotices returns the content of every plain message the bot sent
+func sentNotices(session *fakeSession) []string {
+	var notices []string
+	for _, call := range session.Calls("ChannelMessageSend") {
+		notices = append(notices, call.Args[1].(string))
+	}
+	return notices
+}
+
+func TestVoiceLeavesWhenEveryoneLeaves(t *testing.T) {
+	b, session := newTestBot()
+	vc := joinTestVoice(t, b, session)
+
+	leaveTestVoice(b, session)
+	left := time.Now()
+
+	b.disconnectIdleVoice(left.Add(database.DefaultVoiceEmptyTimeout / 2))
+	if _, ok := b.Voice.Connection(testGuildID); !ok {
+		t.Fatal("expected the bot to wait before leaving")
+	}
+
+	b.disconnectIdleVoice(left.Add(database.DefaultVoiceEmptyTimeout + time.Second))
+	if _, ok := b.Voice.Connection(testGuildID); ok {
+		t.Fatal("expected the bot to leave the empty channel")
+	}
+	if !session.VoiceConn(testGuildID).Disconnected() || !vc.Closed {
+		t.Error("expected the voice connection to be closed")
+	}
+
+	notices := sentNotices(session)
+	if len(notices) != 1 || notices[0] != "Left <#310> because everyone else left." {
+		t.Errorf("unexpected notices: %q", notices)
+	}
+	if sent := session.Calls("ChannelMessageSend"); sent[0].Args[0] != testChannelID {
+		t.Errorf("expected the notice in the channel /play was used in, got %v", sent[0].Args[0])
+	}
+}
+
+func TestVoiceStaysWhenListenerReturns(t *testing.T) {
+	b, session := newTestBot()
+	joinTestVoice(t, b, session)
+	b.VoiceSettings.SaveVoiceSettings(&database.VoiceSettings{GuildID: testGuildID, EmptyTimeout: time.Minute})
+
+	leaveTestVoice(b, session)
+	addTestVoiceChannel(t, session)
+	b.onVoiceStateUpdate(nil, &discordgo.VoiceStateUpdate{
+		VoiceState: &discordgo.VoiceState{GuildID: testGuildID, UserID: testUserID, ChannelID: testVoiceChannelID},
+	})
+
+	b.disconnectIdleVoice(time.Now().Add(time.Hour))
+	if _, ok := b.Voice.Connection(testGuildID); !ok {
+		t.Error("expected the bot to stay with its listener")
+	}
+}
+
+func TestVoiceLeavesWhenIdle(t *testing.T) {
+	b, session := newTestBot()
+	joinTestVoice(t, b, session)
+
+	start := time.Now()
+	b.disconnectIdleVoice(start)
+	b.disconnectIdleVoice(start.Add(database.DefaultVoiceIdleTimeout - time.Second))
+	if _, ok := b.Voice.Connection(testGuildID); !ok {
+		t.Fatal("expected the bot to wait before leaving")
+	}
+
+	b.disconnectIdleVoice(start.Add(database.DefaultVoiceIdleTimeout))
+	if _, ok := b.Voice.Connection(testGuildID); ok {
+		t.Fatal("expected the idle bot to leave")
+	}
+	notices := sentNotices(session)
+	if len(notices) != 1 || notices[0] != "Left <#310> after 5 minutes without playing anything." {
+		t.Errorf("unexpected notices: %q", notices)
+	}
+}
+
+func TestVoiceStaysWhilePlaying(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "play", "first.dca")
+	defer audio.track(t, "first.dca").Close()
+	waitForPlayback(t, b)
+
+	start := time.Now()
+	b.disconnectIdleVoice(start)
+	b.disconnectIdleVoice(start.Add(time.Hour))
+	if _, ok := b.Voice.Connection(testGuildID); !ok {
+		t.Error("expected the bot to stay while playing")
+	}
+}
+
+func TestVoiceTimeoutsCanBeTurnedOff(t *testing.T) {
+	b, session := newTestBot()
+	joinTestVoice(t, b, session)
+	b.VoiceSettings.SaveVoiceSettings(&database.VoiceSettings{GuildID: testGuildID})
+
+	leaveTestVoice(b, session)
+	start := time.Now()
+	b.disconnectIdleVoice(start)
+	b.disconnectIdleVoice(start.Add(24 * time.Hour))
+	if _, ok := b.Voice.Connection(testGuildID); !ok {
+		t.Error("expected the bot to stay with both timeouts off")
+	}
+}
+
+func TestVoiceForgetsExternalDisconnect(t *testing.T) {
+	b, session := newTestBot()
+	joinTestVoice(t, b, session)
+
+	b.onVoiceStateUpdate(nil, &discordgo.VoiceStateUpdate{
+		VoiceState:   &discordgo.VoiceState{GuildID: testGuildID, UserID: session.BotUserID()},
+		BeforeUpdate: &discordgo.VoiceState{GuildID: testGuildID, UserID: session.BotUserID(), ChannelID: testVoiceChannelID},
+	})
+
+	if _, ok := b.Voice.Connection(testGuildID); ok {
+		t.Error("expected the connection to be forgotten")
+	}
+	notices := sentNotices(session)
+	if len(notices) != 1 || notices[0] != "I was disconnected from <#310>." {
+		t.Errorf("unexpected notices: %q", notices)
+	}
+}
+
+func TestSettingsVoice(t *testing.T) {
+	b, session := newTestBot()
+	newManagedTestGuild(t, session)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("voice")))
+	if got := session.Responses()[0].Data.Content; got != "I leave voice channels 1 minute after everyone else leaves. I leave after 5 minutes without playing anything." {
+		t.Errorf("unexpected response: %q", got)
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("voice",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "empty", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(0)},
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "idle", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(10)},
+	)))
+	if got := session.Responses()[1].Data.Content; got != "I stay in voice channels when everyone else leaves. I leave after 10 minutes without playing anything." {
+		t.Errorf("unexpected response: %q", got)
+	}
+
+	settings, _ := b.VoiceSettings.GetVoiceSettings(testGuildID)
+	if settings.EmptyTimeout != 0 || settings.IdleTimeout != 10*time.Minute {
+		t.Errorf("unexpected settings: %+v", settings)
+	}
+}
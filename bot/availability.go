@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Availability controls where a command can be used
+type Availability int
+
+// Command availabilities
+const (
+	AvailableEverywhere Availability = iota
+	AvailableGuildOnly
+	AvailableDMOnly
+)
+
+// allows reports whether a command can run in the given guild ("" for DMs)
+func (a Availability) allows(guildID string) bool {
+	switch a {
+	case AvailableGuildOnly:
+		return guildID != ""
+	case AvailableDMOnly:
+		return guildID == ""
+	default:
+		return true
+	}
+}
+
+// dmPermission returns the DMPermission registered with Discord. Discord
+// can't hide commands from guilds, so DM-only commands are enforced at
+// dispatch only.
+func (a Availability) dmPermission() *bool {
+	allowed := a != AvailableGuildOnly
+	return &allowed
+}
+
+// interactionUser returns the user who triggered an interaction. Interactions
+// in guilds carry a Member, while those in DMs carry a User.
+func interactionUser(i *discordgo.Interaction) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// interactionUserID returns the ID of the user who triggered an interaction
+func interactionUserID(i *discordgo.Interaction) string {
+	if user := interactionUser(i); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// AvailabilityMiddleware rejects commands used outside where they are available
+func AvailabilityMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			if ctx.Availability.allows(ctx.GuildID) {
+				return next(ctx)
+			}
+
+			if ctx.Availability == AvailableGuildOnly {
+				return ctx.replyEphemeral("This command can only be used in a server.")
+			}
+			return ctx.replyEphemeral("This command can only be used in DMs.")
+		}
+	}
+}
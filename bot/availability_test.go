@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newTestDMInteraction builds a slash command interaction sent in a DM, which
+// carries a User instead of a Member
+func newTestDMInteraction(name string) *discordgo.InteractionCreate {
+	i := newTestInteraction(name)
+	i.GuildID = ""
+	i.Member = nil
+	i.User = &discordgo.User{ID: testUserID}
+	return i
+}
+
+func TestSlashCommandInDM(t *testing.T) {
+	b, session := newTestBot()
+
+	var userID string
+	b.Commands.RegisterCommand(Command{
+		Name:        "whoami",
+		Description: "Show the invoking user",
+		Handler: func(ctx *CommandContext) error {
+			userID = ctx.UserID
+			return ctx.Reply("ok")
+		},
+	})
+
+	b.Commands.HandleSlashCommand(session, newTestDMInteraction("whoami"))
+
+	if userID != testUserID {
+		t.Errorf("expected user %s, got %q", testUserID, userID)
+	}
+}
+
+func TestGuildOnlyCommandRejectedInDM(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestDMInteraction("settings"))
+
+	responses := session.Responses()
+	if len(responses) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(responses))
+	}
+	if got := responses[0].Data.Content; got != "This command can only be used in a server." {
+		t.Errorf("unexpected response %q", got)
+	}
+}
+
+func TestDMOnlyCommandRejectedInGuild(t *testing.T) {
+	b, session := newTestBot()
+
+	ran := false
+	b.Commands.RegisterCommand(Command{
+		Name:         "secret",
+		Description:  "DM only",
+		Availability: AvailableDMOnly,
+		Handler: func(ctx *CommandContext) error {
+			ran = true
+			return nil
+		},
+	})
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!secret"), "secret", nil)
+
+	if ran {
+		t.Error("expected DM-only command not to run in a guild")
+	}
+	messages := sentMessages(session)
+	if len(messages) != 1 || messages[0].Content != "This command can only be used in DMs." {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+}
+
+func TestApplicationCommandsSetDMPermission(t *testing.T) {
+	b, _ := newTestBot()
+
+	for _, cmd := range b.Commands.applicationCommands() {
+		want := b.Commands.SlashCommands[cmd.Name].Availability != AvailableGuildOnly
+		if cmd.DMPermission == nil || *cmd.DMPermission != want {
+			t.Errorf("%s: expected DMPermission %v", cmd.Name, want)
+		}
+	}
+}
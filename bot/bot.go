@@ -14,13 +14,29 @@ import (
 
 // Bot represents the Discord bot instance
 type Bot struct {
-	Config     *config.Config
-	Session    *discordgo.Session
-	Repository *database.Repository
-	Commands   *CommandHandler
-	StartTime  time.Time
-	Guilds     map[string]*discordgo.Guild
-	guildMutex sync.RWMutex
+	Config        *config.Config
+	Session       Session
+	Discord       *discordgo.Session // Underlying gateway connection
+	Repository    *database.Repository
+	Commands      *CommandHandler
+	Components    *ComponentRouter
+	Prefixes      *PrefixCache
+	Cooldowns     CooldownStore
+	CommandLog    CommandRecorder
+	ErrorLog      CommandErrorRecorder
+	RoleAudit     RoleAuditRecorder
+	TempRoles     TempRoleStore
+	RoleBindings  *RoleBindingCache
+	JoinedGuilds  *JoinedGuilds
+	Welcomes      WelcomeStore
+	VoiceSettings VoiceSettingsStore
+	Reports       ReportChannelStore
+	Voice         *VoiceManager
+	StartTime     time.Time
+	Guilds        map[string]*discordgo.Guild
+	guildMutex    sync.RWMutex
+
+	reactionSwitches reactionSwitches // Reactions taken off by unique self-role switches
 }
 
 // New creates a new Discord bot instance
@@ -32,26 +48,58 @@ func New(cfg *config.Config, db *sql.DB) (*Bot, error) {
 	}
 
 	// Create bot instance
+	repository := database.NewRepository(db)
 	bot := &Bot{
-		Config:     cfg,
-		Session:    session,
-		Repository: database.NewRepository(db),
-		Guilds:     make(map[string]*discordgo.Guild),
+		Config:        cfg,
+		Session:       NewSession(session),
+		Discord:       session,
+		Repository:    repository,
+		Prefixes:      NewPrefixCache(repository, cfg.CommandPrefix),
+		Cooldowns:     NewMemoryCooldownStore(),
+		CommandLog:    repository,
+		ErrorLog:      repository,
+		RoleAudit:     repository,
+		TempRoles:     repository,
+		RoleBindings:  NewRoleBindingCache(repository),
+		JoinedGuilds:  NewJoinedGuilds(repository),
+		Welcomes:      repository,
+		VoiceSettings: repository,
+		Reports:       repository,
+		Components:    NewComponentRouter(),
+		Guilds:        make(map[string]*discordgo.Guild),
 	}
 
+	// Keep cooldowns in Postgres so they survive restarts
+	if cfg.CooldownStore == "postgres" {
+		bot.Cooldowns = CooldownStoreFunc(repository.TakeCooldown)
+	}
+
+	bot.Voice = NewVoiceManager(bot, NewDefaultAudioSource(cfg.AudioDir))
+	bot.Voice.Encoder = func() (OpusEncoder, error) {
+		return NewFFmpegEncoder(cfg.FFmpegPath)
+	}
+
+	bot.Components.Use(ErrorMiddleware(bot.ErrorLog), RecoveryMiddleware())
+
 	// Initialize command handler
 	bot.Commands = NewCommandHandler(bot)
 
-	// Register event handlers
-	session.AddHandler(bot.onReady)
-	session.AddHandler(bot.onGuildCreate)
-	session.AddHandler(bot.onGuildDelete)
-	session.AddHandler(bot.onMessageCreate)
-	session.AddHandler(bot.onInteractionCreate)
+	// Register event handlers, recovering from panics in each
+	session.AddHandler(safeHandler(bot, "ready", bot.onReady))
+	session.AddHandler(safeHandler(bot, "guild create", bot.onGuildCreate))
+	session.AddHandler(safeHandler(bot, "guild delete", bot.onGuildDelete))
+	session.AddHandler(safeHandler(bot, "message create", bot.onMessageCreate))
+	session.AddHandler(safeHandler(bot, "interaction create", bot.onInteractionCreate))
+	session.AddHandler(safeHandler(bot, "message reaction add", bot.onMessageReactionAdd))
+	session.AddHandler(safeHandler(bot, "message reaction remove", bot.onMessageReactionRemove))
+	session.AddHandler(safeHandler(bot, "guild member add", bot.onGuildMemberAdd))
+	session.AddHandler(safeHandler(bot, "guild member remove", bot.onGuildMemberRemove))
+	session.AddHandler(safeHandler(bot, "voice state update", bot.onVoiceStateUpdate))
 
 	// Set intents
 	session.Identify.Intents = discordgo.IntentsGuilds |
 		discordgo.IntentsGuildMessages |
+		discordgo.IntentsGuildMembers |
 		discordgo.IntentsGuildVoiceStates |
 		discordgo.IntentsGuildMessageReactions |
 		discordgo.IntentsDirectMessages
@@ -61,14 +109,19 @@ func New(cfg *config.Config, db *sql.DB) (*Bot, error) {
 
 // Start connects the bot to Discord
 func (b *Bot) Start() error {
+	// Load the joined guilds before guild create events start arriving
+	if err := b.JoinedGuilds.Load(time.Now()); err != nil {
+		logrus.Errorf("Error loading joined guilds: %v", err)
+	}
+
 	// Connect to Discord
-	if err := b.Session.Open(); err != nil {
+	if err := b.Discord.Open(); err != nil {
 		return fmt.Errorf("error opening connection to Discord: %w", err)
 	}
 
-	// Register slash commands
-	if err := b.Commands.RegisterSlashCommands(); err != nil {
-		return fmt.Errorf("error registering slash commands: %w", err)
+	// Sync slash commands with Discord
+	if _, err := b.Commands.SyncSlashCommands(b.Config.CommandSyncDryRun); err != nil {
+		return fmt.Errorf("error syncing slash commands: %w", err)
 	}
 
 	// Set start time for uptime tracking
@@ -77,6 +130,12 @@ func (b *Bot) Start() error {
 	// Start stats updater
 	go b.statsUpdater()
 
+	// Remove temporary roles as they expire
+	go b.tempRoleExpirer()
+
+	// Leave voice channels that are empty or idle
+	go b.voiceDisconnecter()
+
 	return nil
 }
 
@@ -90,7 +149,7 @@ func (b *Bot) Stop() {
 	}
 
 	// Close Discord session
-	if err := b.Session.Close(); err != nil {
+	if err := b.Discord.Close(); err != nil {
 		logrus.Errorf("Error closing Discord session: %v", err)
 	}
 }
@@ -121,6 +180,12 @@ func (b *Bot) statsUpdater() {
 
 	for range ticker.C {
 		b.updateStats()
+
+		if b.Config.CooldownStore == "postgres" {
+			if err := b.Repository.DeleteExpiredCooldowns(); err != nil {
+				logrus.Errorf("Error deleting expired cooldowns: %v", err)
+			}
+		}
 	}
 }
 
@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectOptions limits how long a collector runs
+type CollectOptions struct {
+	Timeout time.Duration // Stop after this long. Zero waits until Max or cancellation.
+	Max     int           // Stop after this many matches. Zero collects until the timeout.
+}
+
+// collect gathers the items passed to emit until the timeout, the maximum
+// count or the cancellation of ctx. subscribe starts delivering items and
+// returns a function that stops it, which is called before collect returns.
+// Cancellation returns the items collected so far along with ctx's error.
+func collect[T any](ctx context.Context, opts CollectOptions, subscribe func(emit func(T)) func()) ([]T, error) {
+	parent := ctx
+	var cancel context.CancelFunc
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	items := make(chan T)
+	unsubscribe := subscribe(func(item T) {
+		// Drop items arriving after collection has ended
+		select {
+		case items <- item:
+		case <-ctx.Done():
+		}
+	})
+	defer unsubscribe()
+
+	var results []T
+	for {
+		select {
+		case item := <-items:
+			results = append(results, item)
+			if opts.Max > 0 && len(results) >= opts.Max {
+				return results, nil
+			}
+
+		case <-ctx.Done():
+			// Running out of time is the normal way for a collector to end
+			return results, parent.Err()
+		}
+	}
+}
+
+// CollectReactions collects reactions added to a message that pass filter.
+// A nil filter accepts every reaction, including the bot's own.
+func CollectReactions(ctx context.Context, s Session, messageID string, filter func(*discordgo.MessageReactionAdd) bool, opts CollectOptions) ([]*discordgo.MessageReactionAdd, error) {
+	return collect(ctx, opts, func(emit func(*discordgo.MessageReactionAdd)) func() {
+		return s.AddHandler(func(_ *discordgo.Session, r *discordgo.MessageReactionAdd) {
+			if r.MessageID == messageID && (filter == nil || filter(r)) {
+				emit(r)
+			}
+		})
+	})
+}
+
+// CollectMessages collects messages sent in a channel that pass filter.
+// A nil filter accepts every message, including the bot's own.
+func CollectMessages(ctx context.Context, s Session, channelID string, filter func(*discordgo.MessageCreate) bool, opts CollectOptions) ([]*discordgo.MessageCreate, error) {
+	return collect(ctx, opts, func(emit func(*discordgo.MessageCreate)) func() {
+		return s.AddHandler(func(_ *discordgo.Session, m *discordgo.MessageCreate) {
+			if m.ChannelID == channelID && (filter == nil || filter(m)) {
+				emit(m)
+			}
+		})
+	})
+}
+
+// componentWatcher receives component interactions on a message for a collector
+type componentWatcher struct {
+	messageID string
+	filter    func(*ComponentContext) bool
+	emit      func(*ComponentContext)
+}
+
+// Collect collects button clicks and select menu choices on a message that
+// pass filter. Collected interactions are acknowledged with a deferred
+// update, so the caller can answer them later with Edit or FollowUp.
+// Interactions that don't pass the filter are routed as usual.
+//
+// Unlike reactions and messages, component interactions are collected
+// through the router, so clicks nobody collects still get an answer.
+func (r *ComponentRouter) Collect(ctx context.Context, messageID string, filter func(*ComponentContext) bool, opts CollectOptions) ([]*ComponentContext, error) {
+	return collect(ctx, opts, func(emit func(*ComponentContext)) func() {
+		watcher := &componentWatcher{messageID: messageID, filter: filter, emit: emit}
+
+		r.mu.Lock()
+		r.watchers = append(r.watchers, watcher)
+		r.mu.Unlock()
+
+		return func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			for i, w := range r.watchers {
+				if w == watcher {
+					r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+					break
+				}
+			}
+		}
+	})
+}
+
+// dispatchWatchers hands a component interaction to the first collector
+// watching its message that accepts it. It reports whether one did.
+func (r *ComponentRouter) dispatchWatchers(s Session, i *discordgo.InteractionCreate) bool {
+	if i.Message == nil {
+		return false
+	}
+
+	r.mu.RLock()
+	var watchers []*componentWatcher
+	for _, w := range r.watchers {
+		if w.messageID == i.Message.ID {
+			watchers = append(watchers, w)
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(watchers) == 0 {
+		return false
+	}
+
+	data := i.MessageComponentData()
+	ctx := &ComponentContext{
+		CommandContext: &CommandContext{
+			Session:     s,
+			Name:        data.CustomID,
+			Type:        CommandTypeComponent,
+			GuildID:     i.GuildID,
+			ChannelID:   i.ChannelID,
+			UserID:      interactionUserID(i.Interaction),
+			Interaction: i,
+		},
+		Data: data,
+	}
+	ctx.trackResponses()
+
+	for _, w := range watchers {
+		if w.filter != nil && !w.filter(ctx) {
+			continue
+		}
+
+		err := ctx.respondInteraction(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredMessageUpdate,
+		})
+		if err != nil {
+			logrus.Warnf("Error acknowledging collected component %s: %v", data.CustomID, err)
+		}
+
+		w.emit(ctx)
+		return true
+	}
+	return false
+}
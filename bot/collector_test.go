@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// waitFor polls until cond holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// collectResult is the outcome of a collector run in the background
+type collectResult[T any] struct {
+	items []T
+	err   error
+}
+
+func TestCollectReactionsStopsAtMax(t *testing.T) {
+	session := newFakeSession("100")
+	done := make(chan collectResult[*discordgo.MessageReactionAdd])
+
+	go func() {
+		items, err := CollectReactions(context.Background(), session, testMessageID, func(r *discordgo.MessageReactionAdd) bool {
+			return r.UserID != session.BotUserID()
+		}, CollectOptions{Timeout: time.Minute, Max: 2})
+		done <- collectResult[*discordgo.MessageReactionAdd]{items, err}
+	}()
+	waitFor(t, func() bool { return session.HandlerCount() == 1 })
+
+	reaction := func(messageID, userID string) *discordgo.MessageReactionAdd {
+		return &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{MessageID: messageID, UserID: userID, Emoji: discordgo.Emoji{Name: "👍"}}}
+	}
+	session.Emit(reaction(testMessageID, session.BotUserID())) // Filtered out
+	session.Emit(reaction("801", testUserID))                  // Another message
+	session.Emit(reaction(testMessageID, testUserID))
+	session.Emit(reaction(testMessageID, "401"))
+
+	result := <-done
+	if result.err != nil || len(result.items) != 2 {
+		t.Fatalf("expected 2 reactions, got %d (%v)", len(result.items), result.err)
+	}
+	if result.items[0].UserID != testUserID || result.items[1].UserID != "401" {
+		t.Errorf("unexpected reactions: %v, %v", result.items[0].UserID, result.items[1].UserID)
+	}
+	if n := session.HandlerCount(); n != 0 {
+		t.Errorf("expected the handler to be removed, %d remain", n)
+	}
+
+	// Events after collection has ended don't block the gateway
+	session.Emit(reaction(testMessageID, "402"))
+}
+
+func TestCollectMessagesStopsAtTimeout(t *testing.T) {
+	session := newFakeSession("100")
+	done := make(chan collectResult[*discordgo.MessageCreate])
+
+	go func() {
+		items, err := CollectMessages(context.Background(), session, testChannelID, nil, CollectOptions{Timeout: 50 * time.Millisecond})
+		done <- collectResult[*discordgo.MessageCreate]{items, err}
+	}()
+	waitFor(t, func() bool { return session.HandlerCount() == 1 })
+
+	session.Emit(newTestMessage("first"))
+	session.Emit(&discordgo.MessageCreate{Message: &discordgo.Message{ChannelID: "301", Content: "elsewhere"}})
+
+	result := <-done
+	if result.err != nil || len(result.items) != 1 || result.items[0].Content != "first" {
+		t.Fatalf("expected the one message in the channel, got %v (%v)", result.items, result.err)
+	}
+	if n := session.HandlerCount(); n != 0 {
+		t.Errorf("expected the handler to be removed, %d remain", n)
+	}
+}
+
+func TestCollectStopsOnCancel(t *testing.T) {
+	session := newFakeSession("100")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan collectResult[*discordgo.MessageCreate])
+
+	go func() {
+		items, err := CollectMessages(ctx, session, testChannelID, nil, CollectOptions{})
+		done <- collectResult[*discordgo.MessageCreate]{items, err}
+	}()
+	waitFor(t, func() bool { return session.HandlerCount() == 1 })
+
+	session.Emit(newTestMessage("before"))
+	cancel()
+
+	result := <-done
+	if !errors.Is(result.err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", result.err)
+	}
+	if len(result.items) != 1 {
+		t.Errorf("expected the messages collected before cancelling, got %v", result.items)
+	}
+	if n := session.HandlerCount(); n != 0 {
+		t.Errorf("expected the handler to be removed, %d remain", n)
+	}
+}
+
+func TestComponentRouterCollect(t *testing.T) {
+	b, session := newTestBot()
+	done := make(chan collectResult[*ComponentContext])
+
+	go func() {
+		items, err := b.Components.Collect(context.Background(), testMessageID, func(ctx *ComponentContext) bool {
+			return ctx.UserID == testUserID
+		}, CollectOptions{Timeout: time.Minute, Max: 1})
+		done <- collectResult[*ComponentContext]{items, err}
+	}()
+	waitFor(t, func() bool {
+		b.Components.mu.RLock()
+		defer b.Components.mu.RUnlock()
+		return len(b.Components.watchers) == 1
+	})
+
+	message := &discordgo.Message{ID: testMessageID, ChannelID: testChannelID}
+
+	// Clicks that don't pass the filter are routed as usual
+	other := newTestComponentInteraction("vote:yes", message)
+	other.Member.User.ID = "401"
+	if b.Components.Dispatch(session, other) {
+		t.Fatal("expected the filtered click not to be handled")
+	}
+
+	if !b.Components.Dispatch(session, newTestComponentInteraction("vote:yes", message)) {
+		t.Fatal("expected the click to be collected")
+	}
+
+	result := <-done
+	if result.err != nil || len(result.items) != 1 || result.items[0].Data.CustomID != "vote:yes" {
+		t.Fatalf("expected the click to be collected, got %v (%v)", result.items, result.err)
+	}
+	if responses := session.Responses(); len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseDeferredMessageUpdate {
+		t.Errorf("expected the click to be acknowledged, got %v", responses)
+	}
+	if len(b.Components.watchers) != 0 {
+		t.Errorf("expected the watcher to be removed, %d remain", len(b.Components.watchers))
+	}
+
+	// The collected interaction can still be answered
+	if err := result.items[0].Edit(&CommandResponse{Content: "Thanks for voting!"}); err != nil {
+		t.Errorf("editing collected interaction: %v", err)
+	}
+}
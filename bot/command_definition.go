@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command defines a command once and exposes it as both a prefix and a slash
+// command. The slash command schema and the prefix argument parser are both
+// generated from Options.
+type Command struct {
+	Name           string
+	Description    string
+	Options        []*discordgo.ApplicationCommandOption
+	Permissions    int64 // Required of the invoking user
+	BotPermissions int64 // Required of the bot
+	Cooldown       *Cooldown
+	Availability   Availability
+	Autocomplete   map[string]AutocompleteHandler // Only used by the slash command
+	Handler        Handler
+}
+
+// RegisterCommand registers a command as both a prefix and a slash command
+func (h *CommandHandler) RegisterCommand(cmd Command) {
+	h.PrefixCommands[cmd.Name] = PrefixCommand{
+		Name:           cmd.Name,
+		Description:    cmd.Description,
+		Usage:          commandUsage(cmd.Name, cmd.Options),
+		Options:        cmd.Options,
+		Permissions:    cmd.Permissions,
+		BotPermissions: cmd.BotPermissions,
+		Cooldown:       cmd.Cooldown,
+		Availability:   cmd.Availability,
+		Run:            cmd.Handler,
+	}
+
+	h.SlashCommands[cmd.Name] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Options:     cmd.Options,
+		},
+		Permissions:    cmd.Permissions,
+		BotPermissions: cmd.BotPermissions,
+		Cooldown:       cmd.Cooldown,
+		Availability:   cmd.Availability,
+		Autocomplete:   cmd.Autocomplete,
+		Run:            cmd.Handler,
+	}
+}
+
+// isChatCommand reports whether a command is a slash command rather than a
+// context-menu command
+func isChatCommand(cmd *discordgo.ApplicationCommand) bool {
+	return cmd.Type == 0 || cmd.Type == discordgo.ChatApplicationCommand
+}
+
+// commandUsage builds a usage string such as "help [command]" from options
+func commandUsage(name string, options []*discordgo.ApplicationCommandOption) string {
+	parts := []string{name}
+	for _, opt := range options {
+		if opt.Required {
+			parts = append(parts, "<"+opt.Name+">")
+		} else {
+			parts = append(parts, "["+opt.Name+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
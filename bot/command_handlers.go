@@ -2,177 +2,35 @@ package bot
 
 import (
 	"fmt"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
 	"github.com/sirupsen/logrus"
 )
 
-// helpCommand handles the help prefix command
-func (h *CommandHandler) helpCommand(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+// helpCommand handles the help command
+func (h *CommandHandler) helpCommand(ctx *CommandContext) error {
+	prefix := h.Bot.Prefixes.Get(ctx.GuildID)
 	var response string
 
-	if len(args) > 0 {
+	if cmdName := strings.ToLower(ctx.StringOption("command")); cmdName != "" {
 		// Help for specific command
-		cmdName := strings.ToLower(args[0])
-		cmd, exists := h.PrefixCommands[cmdName]
-		if exists {
-			response = fmt.Sprintf("**%s**\nDescription: %s\nUsage: `%s%s`", 
-				cmd.Name, cmd.Description, h.Bot.Config.CommandPrefix, cmd.Usage)
-		} else {
-			response = fmt.Sprintf("Command `%s` not found. Use `%shelp` to see all commands.", 
-				cmdName, h.Bot.Config.CommandPrefix)
-		}
-	} else {
-		// General help
-		response = "**Available Commands:**\n"
-		for _, cmd := range h.PrefixCommands {
-			response += fmt.Sprintf("`%s%s` - %s\n", 
-				h.Bot.Config.CommandPrefix, cmd.Name, cmd.Description)
-		}
-		response += "\nUse `/help [command]` for more information about a specific command."
-	}
-
-	// Create embed
-	embed := &discordgo.MessageEmbed{
-		Title:       "Help",
-		Description: response,
-		Color:       0x00AAFF,
-		Footer: &discordgo.MessageEmbedFooter{
-			Text: "Discord Bot Template",
-		},
-	}
-
-	_, err := s.ChannelMessageSendEmbed(m.ChannelID, embed)
-	if err != nil {
-		logrus.Errorf("Error sending help message: %v", err)
-	}
-}
-
-// pingCommand handles the ping prefix command
-func (h *CommandHandler) pingCommand(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	// Calculate latency
-	start := time.Now()
-	msg, err := s.ChannelMessageSend(m.ChannelID, "Pinging...")
-	if err != nil {
-		logrus.Errorf("Error sending ping message: %v", err)
-		return
-	}
-
-	latency := time.Since(start)
-
-	// Edit message with latency
-	_, err = s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Pong! Latency: %s", latency.Round(time.Millisecond)))
-	if err != nil {
-		logrus.Errorf("Error editing ping message: %v", err)
-	}
-}
-
-// infoCommand handles the info prefix command
-func (h *CommandHandler) infoCommand(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	// Get bot stats
-	guildsCount := len(h.Bot.GetGuilds())
-	uptime := h.Bot.GetUptime().Round(time.Second)
-
-	// Create embed
-	embed := &discordgo.MessageEmbed{
-		Title: "Bot Information",
-		Color: 0x00AAFF,
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Servers",
-				Value:  fmt.Sprintf("%d", guildsCount),
-				Inline: true,
-			},
-			{
-				Name:   "Uptime",
-				Value:  uptime.String(),
-				Inline: true,
-			},
-			{
-				Name:   "Library",
-				Value:  "discordgo",
-				Inline: true,
-			},
-		},
-		Footer: &discordgo.MessageEmbedFooter{
-			Text: "Discord Bot Template",
-		},
-		Timestamp: time.Now().Format(time.RFC3339),
-	}
-
-	_, err := s.ChannelMessageSendEmbed(m.ChannelID, embed)
-	if err != nil {
-		logrus.Errorf("Error sending info message: %v", err)
-	}
-}
-
-// playCommand handles the play prefix command (example for voice)
-func (h *CommandHandler) playCommand(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	// Check if a search term or URL was provided
-	if len(args) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "Please provide a URL or search term.")
-		return
-	}
+		slashCmd, slashExists := h.SlashCommands[cmdName]
+		prefixCmd, prefixExists := h.PrefixCommands[cmdName]
 
-	// Find the user's voice channel
-	var voiceChannelID string
-	if m.GuildID != "" {
-		// Get guild
-		guild, err := s.State.Guild(m.GuildID)
-		if err != nil {
-			logrus.Errorf("Error getting guild: %v", err)
-			s.ChannelMessageSend(m.ChannelID, "Error finding your voice channel.")
-			return
-		}
+		switch {
+		case slashExists:
+			response = fmt.Sprintf("**/%s**\nDescription: %s\n",
+				slashCmd.Command.Name, slashCmd.Command.Description)
 
-		// Find the user in a voice channel
-		for _, vs := range guild.VoiceStates {
-			if vs.UserID == m.Author.ID {
-				voiceChannelID = vs.ChannelID
-				break
+			if prefixExists {
+				response += fmt.Sprintf("Usage: `%s%s`\n", prefix, prefixCmd.Usage)
 			}
-		}
 
-		if voiceChannelID == "" {
-			s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel to use this command.")
-			return
-		}
-	} else {
-		s.ChannelMessageSend(m.ChannelID, "This command can only be used in a server.")
-		return
-	}
-
-	// In a real implementation, you would:
-	// 1. Connect to the voice channel
-	// 2. Download/stream the audio
-	// 3. Play the audio
-	// For this template, we'll just send a message
-	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Would play audio in voice channel <#%s>: %s", 
-		voiceChannelID, strings.Join(args, " ")))
-}
-
-// helpSlashCommand handles the help slash command
-func (h *CommandHandler) helpSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	options := i.ApplicationCommandData().Options
-	var response string
-
-	if len(options) > 0 && options[0].Name == "command" {
-		// Help for specific command
-		cmdName := strings.ToLower(options[0].StringValue())
-		
-		// Check slash commands
-		slashCmd, slashExists := h.SlashCommands[cmdName]
-		
-		// Check prefix commands
-		prefixCmd, prefixExists := h.PrefixCommands[cmdName]
-		
-		if slashExists {
-			response = fmt.Sprintf("**/%s**\nDescription: %s\n", 
-				slashCmd.Command.Name, slashCmd.Command.Description)
-			
 			// Add options if any
 			if len(slashCmd.Command.Options) > 0 {
 				response += "\n**Options:**\n"
@@ -184,77 +42,100 @@ func (h *CommandHandler) helpSlashCommand(s *discordgo.Session, i *discordgo.Int
 					response += fmt.Sprintf("`%s`%s - %s\n", opt.Name, requiredText, opt.Description)
 				}
 			}
-		} else if prefixExists {
-			response = fmt.Sprintf("**%s%s**\nDescription: %s\nUsage: `%s%s`", 
-				h.Bot.Config.CommandPrefix, prefixCmd.Name, prefixCmd.Description, h.Bot.Config.CommandPrefix, prefixCmd.Usage)
-		} else {
+		case prefixExists:
+			response = fmt.Sprintf("**%s%s**\nDescription: %s\nUsage: `%s%s`",
+				prefix, prefixCmd.Name, prefixCmd.Description, prefix, prefixCmd.Usage)
+		default:
 			response = fmt.Sprintf("Command `%s` not found. Use `/help` to see all commands.", cmdName)
 		}
 	} else {
 		// General help
 		response = "**Available Slash Commands:**\n"
-		for _, cmd := range h.SlashCommands {
+		var menuCommands []string
+		for _, name := range sortedKeys(h.SlashCommands) {
+			cmd := h.SlashCommands[name]
+			if !isChatCommand(cmd.Command) {
+				menuCommands = append(menuCommands, name)
+				continue
+			}
 			response += fmt.Sprintf("`/%s` - %s\n", cmd.Command.Name, cmd.Command.Description)
 		}
-		
+
+		if len(menuCommands) > 0 {
+			response += "\n**Available Context Menu Commands:**\n"
+			for _, name := range menuCommands {
+				response += fmt.Sprintf("`%s`\n", name)
+			}
+		}
+
 		response += "\n**Available Prefix Commands:**\n"
-		for _, cmd := range h.PrefixCommands {
-			response += fmt.Sprintf("`%s%s` - %s\n", h.Bot.Config.CommandPrefix, cmd.Name, cmd.Description)
+		for _, name := range sortedKeys(h.PrefixCommands) {
+			cmd := h.PrefixCommands[name]
+			response += fmt.Sprintf("`%s%s` - %s\n", prefix, cmd.Name, cmd.Description)
 		}
-		
+
 		response += "\nUse `/help command:name` for more information about a specific command."
 	}
 
-	// Create embed
-	embed := &discordgo.MessageEmbed{
+	return ctx.ReplyEmbed(&discordgo.MessageEmbed{
 		Title:       "Help",
 		Description: response,
 		Color:       0x00AAFF,
 		Footer: &discordgo.MessageEmbedFooter{
 			Text: "Discord Bot Template",
 		},
+	})
+}
+
+// helpAutocomplete suggests command names for /help
+func (h *CommandHandler) helpAutocomplete(ctx *AutocompleteContext) []*discordgo.ApplicationCommandOptionChoice {
+	typed := strings.ToLower(ctx.Value())
+
+	names := make(map[string]bool)
+	for name, cmd := range h.SlashCommands {
+		if isChatCommand(cmd.Command) {
+			names[name] = true
+		}
+	}
+	for name := range h.PrefixCommands {
+		names[name] = true
 	}
 
-	// Respond to interaction
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Embeds: []*discordgo.MessageEmbed{embed},
-		},
-	})
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, name := range sortedKeys(names) {
+		if strings.HasPrefix(name, typed) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+		}
+	}
+	return choices
 }
 
-// pingSlashCommand handles the ping slash command
-func (h *CommandHandler) pingSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// pingCommand handles the ping command
+func (h *CommandHandler) pingCommand(ctx *CommandContext) error {
 	// Respond immediately
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: "Pong! Calculating latency...",
-		},
-	})
+	if err := ctx.Reply("Pong! Calculating latency..."); err != nil {
+		return err
+	}
 
-	// Calculate latency
-	// Parse the snowflake ID to get the timestamp
-	id, _ := strconv.ParseInt(i.Interaction.ID, 10, 64)
-	timestamp := (id >> 22) + 1420070400000
-	latency := time.Since(time.UnixMilli(timestamp))
+	// Calculate latency from the timestamp encoded in the invoking snowflake
+	latency := time.Duration(0)
+	if sent, err := discordgo.SnowflakeTimestamp(ctx.InvocationID()); err == nil {
+		latency = time.Since(sent)
+	}
 
 	// Edit the response with latency
-	content := fmt.Sprintf("Pong! Latency: %s", latency.Round(time.Millisecond))
-	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &content,
+	return ctx.Edit(&CommandResponse{
+		Content: fmt.Sprintf("Pong! Latency: %s", latency.Round(time.Millisecond)),
 	})
 }
 
-// infoSlashCommand handles the info slash command
-func (h *CommandHandler) infoSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// infoCommand handles the info command
+func (h *CommandHandler) infoCommand(ctx *CommandContext) error {
 	// Get bot stats
 	guildsCount := len(h.Bot.GetGuilds())
 	uptime := h.Bot.GetUptime().Round(time.Second)
 
-	// Create embed
-	embed := &discordgo.MessageEmbed{
+	return ctx.ReplyEmbed(&discordgo.MessageEmbed{
 		Title: "Bot Information",
 		Color: 0x00AAFF,
 		Fields: []*discordgo.MessageEmbedField{
@@ -278,87 +159,166 @@ func (h *CommandHandler) infoSlashCommand(s *discordgo.Session, i *discordgo.Int
 			Text: "Discord Bot Template",
 		},
 		Timestamp: time.Now().Format(time.RFC3339),
+	})
+}
+
+// userInfoCommand handles the User Info context-menu command
+func (h *CommandHandler) userInfoCommand(ctx *CommandContext) error {
+	user := ctx.TargetUser()
+	if user == nil {
+		return ctx.replyEphemeral("Could not find that user.")
+	}
+
+	created, err := discordgo.SnowflakeTimestamp(user.ID)
+	if err != nil {
+		return err
 	}
 
-	// Respond to interaction
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Embeds: []*discordgo.MessageEmbed{embed},
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:   "ID",
+			Value:  user.ID,
+			Inline: true,
 		},
+		{
+			Name:   "Account Created",
+			Value:  fmt.Sprintf("<t:%d:R>", created.Unix()),
+			Inline: true,
+		},
+	}
+
+	if member := ctx.TargetMember(); member != nil {
+		fields = append(fields,
+			&discordgo.MessageEmbedField{
+				Name:   "Joined Server",
+				Value:  fmt.Sprintf("<t:%d:R>", member.JoinedAt.Unix()),
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Roles",
+				Value:  fmt.Sprintf("%d", len(member.Roles)),
+				Inline: true,
+			},
+		)
+	}
+
+	return ctx.Respond(&CommandResponse{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:     user.Username,
+			Color:     0x00AAFF,
+			Thumbnail: &discordgo.MessageEmbedThumbnail{URL: user.AvatarURL("")},
+			Fields:    fields,
+		}},
+		Ephemeral: true,
 	})
 }
 
+// reportMessageCommand handles the Report Message context-menu command. The
+// report is posted in the guild's report channel, and also stored in the
+// command log with the message content.
+func (h *CommandHandler) reportMessageCommand(ctx *CommandContext) error {
+	msg := ctx.TargetMessage()
+	if msg == nil {
+		return ctx.replyEphemeral("Could not find that message.")
+	}
+	if msg.Author != nil && msg.Author.ID == ctx.UserID {
+		return ctx.replyEphemeral("You can't report your own message.")
+	}
+
+	channelID, err := h.Bot.Reports.GetReportChannel(ctx.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading report channel: %v", err)
+		return ctx.replyEphemeral("An error occurred while sending the report.")
+	}
+	if channelID == "" {
+		return ctx.replyEphemeral("This server has no channel for reports, so the moderators weren't notified. Ask them to set one up with `/settings reports`.")
+	}
+
+	report := *msg
+	if report.ChannelID == "" {
+		report.ChannelID = ctx.ChannelID
+	}
+	if _, err := ctx.Session.ChannelMessageSendEmbed(channelID, reportEmbed(ctx.GuildID, ctx.UserID, &report)); err != nil {
+		logrus.Errorf("Error posting report in channel %s: %v", channelID, err)
+		return ctx.replyEphemeral("I couldn't post the report for the moderators. Please contact them directly.")
+	}
+
+	return ctx.replyEphemeral("Thanks, the message has been reported to the moderators.")
+}
+
+// exampleComponentTimeout is how long the example components stay usable
+const exampleComponentTimeout = 5 * time.Minute
+
 // buttonSlashCommand handles the button slash command
-func (h *CommandHandler) buttonSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) buttonSlashCommand(ctx *CommandContext) error {
 	// Create a message with buttons
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: "Here's an example button:",
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.Button{
-							Label:    "Click Me",
-							Style:    discordgo.PrimaryButton,
-							CustomID: "example_button",
-							Emoji: discordgo.ComponentEmoji{
-								Name: "👋",
-							},
+	err := ctx.Respond(&CommandResponse{
+		Content: "Here's an example button:",
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Click Me",
+						Style:    discordgo.PrimaryButton,
+						CustomID: "example_button",
+						Emoji: discordgo.ComponentEmoji{
+							Name: "👋",
 						},
-						discordgo.Button{
-							Label: "Visit Website",
-							Style: discordgo.LinkButton,
-							URL:   "https://github.com/bwmarrin/discordgo",
-							Emoji: discordgo.ComponentEmoji{
-								Name: "🔗",
-							},
+					},
+					discordgo.Button{
+						Label: "Visit Website",
+						Style: discordgo.LinkButton,
+						URL:   "https://github.com/bwmarrin/discordgo",
+						Emoji: discordgo.ComponentEmoji{
+							Name: "🔗",
 						},
 					},
 				},
 			},
 		},
 	})
+	if err != nil {
+		return err
+	}
+
+	h.Bot.Components.ExpireAfter(ctx, exampleComponentTimeout)
+	return nil
 }
 
 // selectSlashCommand handles the select menu slash command
-func (h *CommandHandler) selectSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) selectSlashCommand(ctx *CommandContext) error {
 	// Create a message with a select menu
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: "Here's an example select menu:",
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.SelectMenu{
-							CustomID:    "example_select",
-							Placeholder: "Choose an option",
-							Options: []discordgo.SelectMenuOption{
-								{
-									Label:       "Option 1",
-									Value:       "option_1",
-									Description: "This is the first option",
-									Emoji: discordgo.ComponentEmoji{
-										Name: "1️⃣",
-									},
+	err := ctx.Respond(&CommandResponse{
+		Content: "Here's an example select menu:",
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:    "example_select",
+						Placeholder: "Choose an option",
+						Options: []discordgo.SelectMenuOption{
+							{
+								Label:       "Option 1",
+								Value:       "option_1",
+								Description: "This is the first option",
+								Emoji: discordgo.ComponentEmoji{
+									Name: "1️⃣",
 								},
-								{
-									Label:       "Option 2",
-									Value:       "option_2",
-									Description: "This is the second option",
-									Emoji: discordgo.ComponentEmoji{
-										Name: "2️⃣",
-									},
+							},
+							{
+								Label:       "Option 2",
+								Value:       "option_2",
+								Description: "This is the second option",
+								Emoji: discordgo.ComponentEmoji{
+									Name: "2️⃣",
 								},
-								{
-									Label:       "Option 3",
-									Value:       "option_3",
-									Description: "This is the third option",
-									Emoji: discordgo.ComponentEmoji{
-										Name: "3️⃣",
-									},
+							},
+							{
+								Label:       "Option 3",
+								Value:       "option_3",
+								Description: "This is the third option",
+								Emoji: discordgo.ComponentEmoji{
+									Name: "3️⃣",
 								},
 							},
 						},
@@ -367,80 +327,589 @@ func (h *CommandHandler) selectSlashCommand(s *discordgo.Session, i *discordgo.I
 			},
 		},
 	})
+	if err != nil {
+		return err
+	}
+
+	h.Bot.Components.ExpireAfter(ctx, exampleComponentTimeout)
+	return nil
+}
+
+// exampleButtonComponent handles clicks on the example button
+func (h *CommandHandler) exampleButtonComponent(ctx *ComponentContext) error {
+	return ctx.replyEphemeral("You clicked the example button!")
+}
+
+// exampleSelectComponent handles choices in the example select menu
+func (h *CommandHandler) exampleSelectComponent(ctx *ComponentContext) error {
+	return ctx.replyEphemeral("You selected: " + strings.Join(ctx.Values(), ", "))
+}
+
+// feedbackSlashCommand opens the feedback form
+func (h *CommandHandler) feedbackSlashCommand(ctx *CommandContext) error {
+	return ctx.ShowModal(NewModal("feedback", "Send Feedback").
+		Short("subject", "Subject").
+		Paragraph("details", "Details"))
+}
+
+// feedbackModal handles submitted feedback forms. The submission itself is
+// stored by the interaction log.
+func (h *CommandHandler) feedbackModal(ctx *ModalContext) error {
+	return ctx.replyEphemeral(fmt.Sprintf("Thanks for your feedback on \"%s\"!", ctx.Value("subject")))
 }
 
 // roleSlashCommand handles the role management slash command
-func (h *CommandHandler) roleSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	options := i.ApplicationCommandData().Options
+func (h *CommandHandler) roleSlashCommand(ctx *CommandContext) error {
+	options := ctx.Interaction.ApplicationCommandData().Options
 	if len(options) == 0 {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "Invalid command usage.",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-		return
+		return ctx.replyEphemeral("Invalid command usage.")
 	}
 
 	subcmd := options[0].Name
-	subcmdOptions := options[0].Options
+	ctx.Options = options[0].Options
 
 	// Get user and role from options
-	var userID, roleID string
-	for _, opt := range subcmdOptions {
-		switch opt.Name {
-		case "user":
-			userID = opt.UserValue(s).ID
-		case "role":
-			roleID = opt.RoleValue(s, i.GuildID).ID
-		}
-	}
-
-	// Check if we have the required permissions
-	perms, err := s.State.UserChannelPermissions(s.State.User.ID, i.ChannelID)
-	if err != nil || perms&discordgo.PermissionManageRoles == 0 {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "I don't have permission to manage roles.",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-		return
+	userID := ctx.IDOption("user")
+	role, err := ctx.Session.StateRole(ctx.GuildID, ctx.IDOption("role"))
+	if err != nil {
+		return ctx.replyEphemeral("I couldn't find that role.")
 	}
 
-	// Handle subcommands
-	var responseContent string
+	// Both the invoker and the bot must be above the role in the hierarchy
+	actor, err := ctx.member()
+	if err != nil {
+		return err
+	}
+	reason, err := roleAssignError(ctx.Session, ctx.GuildID, actor, role)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
 
+	if subcmd == "mass" {
+		return h.massRoleSlashCommand(ctx, role)
+	}
+
+	// Handle subcommands
+	var action, response string
 	switch subcmd {
 	case "add":
+		var duration time.Duration
+		if value := ctx.StringOption("duration"); value != "" {
+			if duration, err = parseRoleDuration(value); err != nil {
+				return ctx.replyEphemeral("Durations look like 30m, 12h or 7d, and must be between a minute and 365 days.")
+			}
+		}
+
 		// Add role to user
-		err = s.GuildMemberRoleAdd(i.GuildID, userID, roleID)
-		if err != nil {
-			responseContent = fmt.Sprintf("Error adding role: %v", err)
-		} else {
-			responseContent = fmt.Sprintf("Added role <@&%s> to <@%s>", roleID, userID)
+		if err := ctx.Session.GuildMemberRoleAdd(ctx.GuildID, userID, role.ID); err != nil {
+			return ctx.replyEphemeral(fmt.Sprintf("Error adding role: %v", err))
+		}
+		action, response = RoleActionAdd, fmt.Sprintf("Added role <@&%s> to <@%s>", role.ID, userID)
+
+		if duration > 0 {
+			expiresAt := time.Now().Add(duration)
+			if err := h.Bot.TempRoles.AddTempRole(ctx.GuildID, userID, role.ID, expiresAt); err != nil {
+				logrus.Errorf("Error scheduling temporary role removal: %v", err)
+				response += ", but I couldn't schedule its removal"
+			} else {
+				response += fmt.Sprintf(" until <t:%d:f>", expiresAt.Unix())
+			}
+		} else if err := h.Bot.TempRoles.DeleteTempRole(ctx.GuildID, userID, role.ID); err != nil {
+			// Adding a role permanently cancels an earlier expiry
+			logrus.Errorf("Error cancelling temporary role removal: %v", err)
 		}
 
 	case "remove":
 		// Remove role from user
-		err = s.GuildMemberRoleRemove(i.GuildID, userID, roleID)
+		if err := ctx.Session.GuildMemberRoleRemove(ctx.GuildID, userID, role.ID); err != nil {
+			return ctx.replyEphemeral(fmt.Sprintf("Error removing role: %v", err))
+		}
+		action, response = RoleActionRemove, fmt.Sprintf("Removed role <@&%s> from <@%s>", role.ID, userID)
+
+		if err := h.Bot.TempRoles.DeleteTempRole(ctx.GuildID, userID, role.ID); err != nil {
+			logrus.Errorf("Error cancelling temporary role removal: %v", err)
+		}
+
+	default:
+		return ctx.replyEphemeral("Unknown subcommand.")
+	}
+
+	if err := h.Bot.RoleAudit.LogRoleChange(ctx.GuildID, ctx.UserID, userID, role.ID, action); err != nil {
+		logrus.Errorf("Error recording role change: %v", err)
+	}
+
+	return ctx.Reply(response)
+}
+
+// massRoleSlashCommand adds or removes a role for every member matching the
+// filters. Members are updated in batches, with the progress shown in the
+// command's response.
+func (h *CommandHandler) massRoleSlashCommand(ctx *CommandContext, role *discordgo.Role) error {
+	filter := massRoleFilter{
+		HasRole: ctx.IDOption("has_role"),
+		Members: ctx.StringOption("members"),
+	}
+	if value := ctx.StringOption("joined_after"); value != "" {
+		joinedAfter, err := time.Parse("2006-01-02", value)
 		if err != nil {
-			responseContent = fmt.Sprintf("Error removing role: %v", err)
-		} else {
-			responseContent = fmt.Sprintf("Removed role <@&%s> from <@%s>", roleID, userID)
+			return ctx.replyEphemeral("Dates look like 2024-01-31.")
+		}
+		filter.JoinedAfter = joinedAfter
+	}
+	add := ctx.StringOption("action") != RoleActionRemove
+
+	// Only one mass update can run per guild at a time
+	if _, running := h.massRoleGuilds.LoadOrStore(ctx.GuildID, true); running {
+		return ctx.replyEphemeral("A mass role update is already running in this server.")
+	}
+	defer h.massRoleGuilds.Delete(ctx.GuildID)
+
+	// Listing and updating members takes a while
+	if err := ctx.Defer(false); err != nil {
+		return err
+	}
+	reporter := &massRoleReporter{ctx: ctx, started: time.Now()}
+
+	members, err := fetchGuildMembers(ctx.Session, ctx.GuildID)
+	if err != nil {
+		return fmt.Errorf("error listing guild members: %w", err)
+	}
+
+	// Skip members the update wouldn't change
+	var userIDs []string
+	for _, member := range members {
+		if member.User == nil || !filter.matches(member) || memberHasRole(member, role.ID) == add {
+			continue
+		}
+		userIDs = append(userIDs, member.User.ID)
+	}
+
+	if len(userIDs) == 0 {
+		return ctx.Edit(&CommandResponse{Content: "No members matched those filters."})
+	}
+
+	action := RoleActionRemove
+	if add {
+		action = RoleActionAdd
+	}
+
+	var updated, failed int
+	for i, userID := range userIDs {
+		// Report progress and pause between batches
+		if i > 0 && i%massRoleBatchSize == 0 {
+			progress := fmt.Sprintf("Updating <@&%s>: %d/%d members...", role.ID, i, len(userIDs))
+			if err := reporter.report(progress); err != nil {
+				logrus.Warnf("Error updating mass role progress: %v", err)
+			}
+			time.Sleep(massRoleBatchDelay)
+		}
+
+		err := retryRateLimited(func() error {
+			if add {
+				return ctx.Session.GuildMemberRoleAdd(ctx.GuildID, userID, role.ID)
+			}
+			return ctx.Session.GuildMemberRoleRemove(ctx.GuildID, userID, role.ID)
+		})
+		if err != nil {
+			logrus.Warnf("Error updating role %s for %s: %v", role.ID, userID, err)
+			failed++
+			continue
+		}
+		updated++
+
+		if err := h.Bot.RoleAudit.LogRoleChange(ctx.GuildID, ctx.UserID, userID, role.ID, action); err != nil {
+			logrus.Errorf("Error recording role change: %v", err)
+		}
+	}
+
+	summary := fmt.Sprintf("Removed <@&%s> from %d members.", role.ID, updated)
+	if add {
+		summary = fmt.Sprintf("Added <@&%s> to %d members.", role.ID, updated)
+	}
+	if failed > 0 {
+		summary += fmt.Sprintf(" %d members couldn't be updated.", failed)
+	}
+
+	return reporter.report(summary)
+}
+
+// selfRoleSlashCommand manages the roles members can give themselves by
+// reacting to a message or clicking a button on it
+func (h *CommandHandler) selfRoleSlashCommand(ctx *CommandContext) error {
+	options := ctx.Interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return ctx.replyEphemeral("Invalid command usage.")
+	}
+
+	subcmd := options[0].Name
+	ctx.Options = options[0].Options
+
+	switch subcmd {
+	case "panel":
+		return h.selfRolePanel(ctx)
+	case "list":
+		return h.selfRoleList(ctx)
+	}
+
+	messageID := strings.TrimSpace(ctx.StringOption("message_id"))
+	role, err := ctx.Session.StateRole(ctx.GuildID, ctx.IDOption("role"))
+	if err != nil {
+		return ctx.replyEphemeral("I couldn't find that role.")
+	}
+	bindings := h.Bot.RoleBindings.Message(ctx.GuildID, messageID)
+
+	if subcmd == "remove" {
+		return h.removeSelfRole(ctx, bindings, messageID, role)
+	}
+
+	// Members get the role through the bot, so the usual hierarchy rules apply
+	actor, err := ctx.member()
+	if err != nil {
+		return err
+	}
+	reason, err := roleAssignError(ctx.Session, ctx.GuildID, actor, role)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	mode := ctx.StringOption("mode")
+	if mode == "" {
+		mode = RoleModeToggle
+	}
+	binding := database.RoleBinding{
+		GuildID:   ctx.GuildID,
+		ChannelID: ctx.ChannelID,
+		MessageID: messageID,
+		RoleID:    role.ID,
+		Mode:      mode,
+	}
+
+	switch subcmd {
+	case "reaction":
+		emoji, ok := parseEmoji(ctx.StringOption("emoji"))
+		if !ok {
+			return ctx.replyEphemeral("That doesn't look like an emoji.")
+		}
+		if other, taken := findRoleBinding(bindings, RoleBindingReaction, func(b database.RoleBinding) bool {
+			return b.Emoji == emoji && b.RoleID != role.ID
+		}); taken {
+			return ctx.replyEphemeral(fmt.Sprintf("That emoji already gives <@&%s> on that message.", other.RoleID))
+		}
+
+		// Reacting first also checks that the message is in this channel
+		if err := ctx.Session.MessageReactionAdd(ctx.ChannelID, messageID, emoji); err != nil {
+			return ctx.replyEphemeral("I couldn't react to that message. Make sure it's in this channel and that I can use the emoji.")
+		}
+
+		binding.Kind, binding.Emoji = RoleBindingReaction, emoji
+		if err := h.Bot.RoleBindings.Save(binding); err != nil {
+			return fmt.Errorf("error saving role binding: %w", err)
+		}
+		return ctx.replyEphemeral(fmt.Sprintf("Reacting to that message now gives <@&%s> (%s mode).", role.ID, mode))
+
+	case "button":
+		msg, err := ctx.Session.ChannelMessage(ctx.ChannelID, messageID)
+		if err != nil {
+			return ctx.replyEphemeral("I couldn't find that message in this channel.")
+		}
+		if msg.Author == nil || msg.Author.ID != ctx.Session.BotUserID() {
+			return ctx.replyEphemeral("I can only add buttons to my own messages. Create one with `/selfrole panel`.")
+		}
+
+		buttons := 0
+		for _, b := range bindings {
+			if b.Kind == RoleBindingButton && b.RoleID != role.ID {
+				buttons++
+			}
+		}
+		if buttons >= maxRoleButtons {
+			return ctx.replyEphemeral(fmt.Sprintf("That message already has the maximum of %d role buttons.", maxRoleButtons))
+		}
+
+		binding.Kind, binding.Label = RoleBindingButton, ctx.StringOption("label")
+		if value := ctx.StringOption("emoji"); value != "" {
+			emoji, ok := parseEmoji(value)
+			if !ok {
+				return ctx.replyEphemeral("That doesn't look like an emoji.")
+			}
+			binding.Emoji = emoji
+		}
+
+		if err := h.Bot.RoleBindings.Save(binding); err != nil {
+			return fmt.Errorf("error saving role binding: %w", err)
+		}
+		if err := h.Bot.refreshRoleButtons(ctx.GuildID, ctx.ChannelID, messageID); err != nil {
+			return err
 		}
+		return ctx.replyEphemeral(fmt.Sprintf("Added a button for <@&%s> to that message (%s mode).", role.ID, mode))
 
 	default:
-		responseContent = "Unknown subcommand."
+		return ctx.replyEphemeral("Unknown subcommand.")
 	}
+}
 
-	// Respond to interaction
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: responseContent,
-		},
+// selfRolePanel posts a message for role buttons to be added to
+func (h *CommandHandler) selfRolePanel(ctx *CommandContext) error {
+	msg, err := ctx.Session.ChannelMessageSendComplex(ctx.ChannelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       ctx.StringOption("title"),
+			Description: ctx.StringOption("description"),
+			Color:       0x00AAFF,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error sending role panel: %w", err)
+	}
+
+	return ctx.replyEphemeral(fmt.Sprintf("Created a role panel with message ID `%s`. Add roles to it with `/selfrole button` or `/selfrole reaction`.", msg.ID))
+}
+
+// selfRoleList lists the self-assignable roles set up in the guild
+func (h *CommandHandler) selfRoleList(ctx *CommandContext) error {
+	bindings, err := h.Bot.RoleBindings.Guild(ctx.GuildID)
+	if err != nil {
+		return fmt.Errorf("error loading role bindings: %w", err)
+	}
+	if len(bindings) == 0 {
+		return ctx.replyEphemeral("No self-assignable roles are set up in this server.")
+	}
+
+	var description strings.Builder
+	for i, binding := range bindings {
+		if i == 0 || bindings[i-1].MessageID != binding.MessageID {
+			fmt.Fprintf(&description, "\nhttps://discord.com/channels/%s/%s/%s (%s)\n", binding.GuildID, binding.ChannelID, binding.MessageID, binding.Mode)
+		}
+		if binding.Kind == RoleBindingReaction {
+			fmt.Fprintf(&description, "- %s reaction: <@&%s>\n", binding.Emoji, binding.RoleID)
+		} else {
+			fmt.Fprintf(&description, "- \"%s\" button: <@&%s>\n", binding.Label, binding.RoleID)
+		}
+	}
+
+	// Stay within the embed description limit
+	text := strings.TrimSpace(description.String())
+	if len(text) > 4000 {
+		text = text[:strings.LastIndex(text[:4000], "\n")] + "\n..."
+	}
+
+	return ctx.Respond(&CommandResponse{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Self-assignable roles",
+			Description: text,
+			Color:       0x00AAFF,
+		}},
+		Ephemeral: true,
 	})
-}
\ No newline at end of file
+}
+
+// removeSelfRole unbinds a role from a message
+func (h *CommandHandler) removeSelfRole(ctx *CommandContext, bindings []database.RoleBinding, messageID string, role *discordgo.Role) error {
+	binding, ok := findRoleBinding(bindings, RoleBindingReaction, func(b database.RoleBinding) bool { return b.RoleID == role.ID })
+	if !ok {
+		binding, ok = findRoleBinding(bindings, RoleBindingButton, func(b database.RoleBinding) bool { return b.RoleID == role.ID })
+	}
+	if !ok {
+		return ctx.replyEphemeral(fmt.Sprintf("<@&%s> isn't bound to that message.", role.ID))
+	}
+
+	if _, err := h.Bot.RoleBindings.Delete(ctx.GuildID, messageID, role.ID); err != nil {
+		return fmt.Errorf("error deleting role binding: %w", err)
+	}
+
+	// Take down the bot's reaction or the button, so members can't pick the role anymore
+	if binding.Kind == RoleBindingReaction {
+		if err := ctx.Session.MessageReactionRemove(binding.ChannelID, messageID, binding.Emoji, "@me"); err != nil {
+			logrus.Warnf("Error removing role reaction: %v", err)
+		}
+	} else if err := h.Bot.refreshRoleButtons(ctx.GuildID, binding.ChannelID, messageID); err != nil {
+		logrus.Warnf("Error removing role button: %v", err)
+	}
+
+	return ctx.replyEphemeral(fmt.Sprintf("<@&%s> can no longer be picked on that message.", role.ID))
+}
+
+// settingsSlashCommand handles the guild settings slash command
+func (h *CommandHandler) settingsSlashCommand(ctx *CommandContext) error {
+	options := ctx.Interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return ctx.replyEphemeral("Invalid command usage.")
+	}
+
+	switch options[0].Name {
+	case "prefix":
+		// Show the current prefix if no new one was given
+		if len(options[0].Options) == 0 {
+			return ctx.replyEphemeral(fmt.Sprintf("The command prefix is `%s`.", h.Bot.Prefixes.Get(ctx.GuildID)))
+		}
+
+		prefix := strings.TrimSpace(options[0].Options[0].StringValue())
+		if strings.EqualFold(prefix, "reset") {
+			prefix = ""
+		}
+
+		if strings.ContainsAny(prefix, " \t\n`") || utf8.RuneCountInString(prefix) > MaxPrefixLength {
+			return ctx.replyEphemeral(fmt.Sprintf("Prefixes must be at most %d characters with no spaces or backticks.", MaxPrefixLength))
+		}
+
+		if err := h.Bot.Prefixes.Set(ctx.GuildID, prefix); err != nil {
+			logrus.Errorf("Error saving prefix: %v", err)
+			return ctx.replyEphemeral("An error occurred while saving the prefix.")
+		}
+
+		return ctx.Reply(fmt.Sprintf("Command prefix set to `%s`.", h.Bot.Prefixes.Get(ctx.GuildID)))
+
+	case "welcome", "goodbye":
+		ctx.Options = options[0].Options
+		return h.welcomeSettings(ctx, options[0].Name == "goodbye")
+
+	case "voice":
+		ctx.Options = options[0].Options
+		return h.voiceSettings(ctx)
+
+	case "reports":
+		ctx.Options = options[0].Options
+		return h.reportSettings(ctx)
+
+	default:
+		return ctx.replyEphemeral("Unknown subcommand.")
+	}
+}
+
+// welcomeSettings shows or changes the member welcome or goodbye settings
+func (h *CommandHandler) welcomeSettings(ctx *CommandContext, goodbye bool) error {
+	settings, err := h.Bot.Welcomes.GetWelcomeSettings(ctx.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading welcome settings: %v", err)
+		return ctx.replyEphemeral("An error occurred while loading the welcome settings.")
+	}
+
+	// Show the current settings if nothing was given
+	if len(ctx.Options) == 0 {
+		return ctx.replyEphemeral(describeWelcome(settings))
+	}
+
+	if channelID := ctx.IDOption("channel"); channelID != "" {
+		perms, err := ctx.Session.UserChannelPermissions(ctx.Session.BotUserID(), channelID)
+		if err != nil || perms&(discordgo.PermissionViewChannel|discordgo.PermissionSendMessages) != discordgo.PermissionViewChannel|discordgo.PermissionSendMessages {
+			return ctx.replyEphemeral(fmt.Sprintf("I can't send messages in <#%s>.", channelID))
+		}
+		settings.ChannelID = channelID
+	}
+	settings.Embed = ctx.BoolOption("embed", settings.Embed)
+	settings.DM = ctx.BoolOption("dm", settings.DM)
+
+	template := &settings.WelcomeMessage
+	if goodbye {
+		template = &settings.GoodbyeMessage
+	}
+	if opt := ctx.Option("message"); opt != nil {
+		*template = strings.TrimSpace(opt.StringValue())
+		if strings.EqualFold(*template, "off") {
+			*template = ""
+		}
+	}
+
+	if len(settings.WelcomeMessage) > MaxWelcomeLength || len(settings.GoodbyeMessage) > MaxWelcomeLength {
+		return ctx.replyEphemeral(fmt.Sprintf("Messages must be at most %d characters.", MaxWelcomeLength))
+	}
+
+	// Goodbyes always go to the channel, and so do welcomes unless they are sent by DM
+	needsChannel := settings.GoodbyeMessage != "" || (settings.WelcomeMessage != "" && !settings.DM)
+	if needsChannel && settings.ChannelID == "" {
+		return ctx.replyEphemeral("Pick a channel for the messages with `/settings welcome channel:`.")
+	}
+
+	if err := h.Bot.Welcomes.SaveWelcomeSettings(settings); err != nil {
+		logrus.Errorf("Error saving welcome settings: %v", err)
+		return ctx.replyEphemeral("An error occurred while saving the welcome settings.")
+	}
+
+	resp := &CommandResponse{Content: describeWelcome(settings), Ephemeral: true}
+	if *template != "" {
+		// Preview the message as the invoking user would see it
+		user := &discordgo.User{ID: ctx.UserID}
+		if ctx.Interaction != nil {
+			if u := interactionUser(ctx.Interaction.Interaction); u != nil {
+				user = u
+			}
+		}
+		preview := welcomeMessage(settings, renderWelcome(*template, user, h.Bot.memberGuild(ctx.GuildID)), user, false)
+		resp.Content += " Preview:\n\n" + preview.Content
+		resp.Embeds = preview.Embeds
+	}
+	return ctx.Respond(resp)
+}
+
+// voiceSettings shows or changes the voice auto-disconnect timeouts
+func (h *CommandHandler) voiceSettings(ctx *CommandContext) error {
+	settings, err := h.Bot.VoiceSettings.GetVoiceSettings(ctx.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading voice settings: %v", err)
+		return ctx.replyEphemeral("An error occurred while loading the voice settings.")
+	}
+
+	// Show the current settings if nothing was given
+	if len(ctx.Options) == 0 {
+		return ctx.replyEphemeral(describeVoiceSettings(settings))
+	}
+
+	if ctx.Option("empty") != nil {
+		settings.EmptyTimeout = time.Duration(ctx.IntOption("empty", 0)) * time.Minute
+	}
+	if ctx.Option("idle") != nil {
+		settings.IdleTimeout = time.Duration(ctx.IntOption("idle", 0)) * time.Minute
+	}
+
+	if err := h.Bot.VoiceSettings.SaveVoiceSettings(settings); err != nil {
+		logrus.Errorf("Error saving voice settings: %v", err)
+		return ctx.replyEphemeral("An error occurred while saving the voice settings.")
+	}
+	return ctx.replyEphemeral(describeVoiceSettings(settings))
+}
+
+// reportSettings shows or changes the channel message reports are posted in
+func (h *CommandHandler) reportSettings(ctx *CommandContext) error {
+	channelID, err := h.Bot.Reports.GetReportChannel(ctx.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading report channel: %v", err)
+		return ctx.replyEphemeral("An error occurred while loading the report channel.")
+	}
+
+	// Show the current channel if nothing was given
+	if len(ctx.Options) == 0 {
+		return ctx.replyEphemeral(describeReportChannel(channelID))
+	}
+
+	if ctx.BoolOption("off", false) {
+		channelID = ""
+	} else if id := ctx.IDOption("channel"); id != "" {
+		perms, err := ctx.Session.UserChannelPermissions(ctx.Session.BotUserID(), id)
+		needed := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks)
+		if err != nil || perms&needed != needed {
+			return ctx.replyEphemeral(fmt.Sprintf("I can't send embeds in <#%s>.", id))
+		}
+		channelID = id
+	}
+
+	if err := h.Bot.Reports.SaveReportChannel(ctx.GuildID, channelID); err != nil {
+		logrus.Errorf("Error saving report channel: %v", err)
+		return ctx.replyEphemeral("An error occurred while saving the report channel.")
+	}
+	return ctx.replyEphemeral(describeReportChannel(channelID))
+}
+
+// sortedKeys returns the keys of a command map in alphabetical order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,462 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testGuildID         = "200"
+	testChannelID       = "300"
+	testUserID          = "400"
+	testRoleID          = "500"
+	testModeratorRoleID = "650"
+)
+
+// addTestGuild populates the fake state with a guild, a text channel,
+// the bot member and a regular member. botPerms is granted to the bot
+// through a dedicated role.
+func addTestGuild(t *testing.T, session *fakeSession, botPerms int64) {
+	t.Helper()
+
+	guild := &discordgo.Guild{
+		ID:      testGuildID,
+		Name:    "Test Guild",
+		OwnerID: "1",
+		Roles: []*discordgo.Role{
+			{ID: testGuildID, Name: "@everyone", Permissions: discordgo.PermissionViewChannel | discordgo.PermissionSendMessages},
+			{ID: "600", Name: "Bot", Permissions: botPerms, Position: 2},
+			{ID: testRoleID, Name: "Member", Position: 1},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: testChannelID, GuildID: testGuildID, Name: "general", Type: discordgo.ChannelTypeGuildText},
+		},
+		Members: []*discordgo.Member{
+			{GuildID: testGuildID, User: &discordgo.User{ID: session.BotUserID()}, Roles: []string{"600"}},
+			{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}},
+		},
+	}
+
+	if err := session.State.GuildAdd(guild); err != nil {
+		t.Fatalf("adding guild to state: %v", err)
+	}
+}
+
+// newTestMessage creates a message event from the test user
+func newTestMessage(content string) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        "700",
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			Content:   content,
+			Author:    &discordgo.User{ID: testUserID},
+		},
+	}
+}
+
+// newTestInteraction creates a slash command interaction from the test user
+func newTestInteraction(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:        "1100000000000000000",
+			Type:      discordgo.InteractionApplicationCommand,
+			GuildID:   testGuildID,
+			ChannelID: testChannelID,
+			// Like Discord, the interaction carries the member's roles. The
+			// moderator role only exists once addTestModerator adds it.
+			Member: &discordgo.Member{User: &discordgo.User{ID: testUserID}, Roles: []string{testModeratorRoleID}},
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name:    name,
+				Options: options,
+			},
+		},
+	}
+}
+
+// sentMessages returns every message sent through ChannelMessageSendComplex
+func sentMessages(session *fakeSession) []*discordgo.MessageSend {
+	var messages []*discordgo.MessageSend
+	for _, call := range session.Calls("ChannelMessageSendComplex") {
+		messages = append(messages, call.Args[1].(*discordgo.MessageSend))
+	}
+	return messages
+}
+
+func TestHelpCommandListsPrefixCommands(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!help"), "help", nil)
+
+	messages := sentMessages(session)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+
+	embed := messages[0].Embeds[0]
+	for name := range b.Commands.PrefixCommands {
+		if !strings.Contains(embed.Description, "`!"+name+"`") {
+			t.Errorf("help output is missing command %q:\n%s", name, embed.Description)
+		}
+	}
+}
+
+func TestHelpCommandShowsUsage(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!help PING"), "help", []string{"PING"})
+
+	embed := sentMessages(session)[0].Embeds[0]
+	if !strings.Contains(embed.Description, "Usage: `!ping`") {
+		t.Errorf("expected usage for ping, got:\n%s", embed.Description)
+	}
+}
+
+func TestHelpSlashCommandUnknownCommand(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("help", &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  "command",
+		Type:  discordgo.ApplicationCommandOptionString,
+		Value: "nope",
+	}))
+
+	responses := session.Responses()
+	if len(responses) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(responses))
+	}
+	if got := responses[0].Data.Embeds[0].Description; !strings.Contains(got, "Command `nope` not found") {
+		t.Errorf("unexpected help response: %s", got)
+	}
+}
+
+func TestPingCommandEditsWithLatency(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!ping"), "ping", nil)
+
+	messages := sentMessages(session)
+	if len(messages) != 1 || messages[0].Content != "Pong! Calculating latency..." {
+		t.Fatalf("expected a single reply, got %v", messages)
+	}
+
+	edits := session.Calls("ChannelMessageEditComplex")
+	if len(edits) != 1 {
+		t.Fatalf("expected 1 edit, got %d", len(edits))
+	}
+	if edit := edits[0].Args[0].(*discordgo.MessageEdit); !strings.HasPrefix(*edit.Content, "Pong! Latency:") {
+		t.Errorf("unexpected edit content: %q", *edit.Content)
+	}
+}
+
+func TestPingSlashCommandRespondsThenEdits(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("ping"))
+
+	calls := session.Calls("")
+	if len(calls) != 2 || calls[0].Method != "InteractionRespond" || calls[1].Method != "InteractionResponseEdit" {
+		t.Fatalf("expected respond then edit, got %v", calls)
+	}
+
+	edit := calls[1].Args[1].(*discordgo.WebhookEdit)
+	if !strings.HasPrefix(*edit.Content, "Pong! Latency:") {
+		t.Errorf("unexpected edit content: %q", *edit.Content)
+	}
+}
+
+func TestInfoSlashCommandReportsGuildCount(t *testing.T) {
+	b, session := newTestBot()
+	b.Guilds["1"] = &discordgo.Guild{ID: "1"}
+	b.Guilds["2"] = &discordgo.Guild{ID: "2"}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("info"))
+
+	embed := session.Responses()[0].Data.Embeds[0]
+	if embed.Fields[0].Name != "Servers" || embed.Fields[0].Value != "2" {
+		t.Errorf("expected 2 servers, got %s=%s", embed.Fields[0].Name, embed.Fields[0].Value)
+	}
+}
+
+func TestInfoCommandSendsEmbed(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!info"), "info", nil)
+
+	messages := sentMessages(session)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if embed := messages[0].Embeds[0]; embed.Title != "Bot Information" {
+		t.Errorf("unexpected embed title: %q", embed.Title)
+	}
+}
+
+func TestRegisterCommandGeneratesBothForms(t *testing.T) {
+	b, _ := newTestBot()
+
+	prefixCmd, ok := b.Commands.PrefixCommands["help"]
+	if !ok || prefixCmd.Usage != "help [command]" {
+		t.Errorf("expected a prefix help command with generated usage, got %+v", prefixCmd)
+	}
+
+	slashCmd, ok := b.Commands.SlashCommands["help"]
+	if !ok || len(slashCmd.Command.Options) != 1 || slashCmd.Command.Options[0].Name != "command" {
+		t.Errorf("expected a slash help command with a command option, got %+v", slashCmd.Command)
+	}
+}
+
+// roleOptions builds the options of a /role subcommand
+func roleOptions(subcommand string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name: subcommand,
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "user", Type: discordgo.ApplicationCommandOptionUser, Value: testUserID},
+			{Name: "role", Type: discordgo.ApplicationCommandOptionRole, Value: testRoleID},
+		},
+	}
+}
+
+// addTestModerator gives the test user a role that can manage roles at the given position
+func addTestModerator(t *testing.T, session *fakeSession, position int) {
+	t.Helper()
+
+	moderator := &discordgo.Role{ID: testModeratorRoleID, Name: "Moderator", Permissions: discordgo.PermissionManageRoles, Position: position}
+	if err := session.State.RoleAdd(testGuildID, moderator); err != nil {
+		t.Fatalf("adding role to state: %v", err)
+	}
+
+	member := &discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}, Roles: []string{moderator.ID}}
+	if err := session.State.MemberAdd(member); err != nil {
+		t.Fatalf("adding member to state: %v", err)
+	}
+}
+
+// setTestRole changes a role in the fake state
+func setTestRole(t *testing.T, session *fakeSession, roleID string, update func(role *discordgo.Role)) {
+	t.Helper()
+
+	role, err := session.State.Role(testGuildID, roleID)
+	if err != nil {
+		t.Fatalf("getting role from state: %v", err)
+	}
+	update(role)
+}
+
+func TestRoleSlashCommandAddsRole(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", roleOptions("add")))
+
+	adds := session.Calls("GuildMemberRoleAdd")
+	if len(adds) != 1 {
+		t.Fatalf("expected 1 role add, got %d", len(adds))
+	}
+	if adds[0].Args[0] != testGuildID || adds[0].Args[1] != testUserID || adds[0].Args[2] != testRoleID {
+		t.Errorf("unexpected role add arguments: %v", adds[0].Args)
+	}
+	if got := session.Responses()[0].Data.Content; got != "Added role <@&500> to <@400>" {
+		t.Errorf("unexpected response: %q", got)
+	}
+	if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 1 || changes[0] != "400 add 500 400" {
+		t.Errorf("expected the change to be audited, got %v", changes)
+	}
+}
+
+func TestRoleSlashCommandRemovesRole(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", roleOptions("remove")))
+
+	if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != 1 {
+		t.Fatalf("expected 1 role removal, got %d", len(removes))
+	}
+	if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 1 || changes[0] != "400 remove 500 400" {
+		t.Errorf("expected the change to be audited, got %v", changes)
+	}
+}
+
+func TestRoleSlashCommandHierarchy(t *testing.T) {
+	tests := []struct {
+		name          string
+		moderatorRole int
+		update        func(role *discordgo.Role)
+		want          string
+	}{
+		{
+			name:          "role above invoker",
+			moderatorRole: 1,
+			update:        func(role *discordgo.Role) {},
+			want:          "You can only manage roles below your highest role, and <@&500> isn't.",
+		},
+		{
+			name:          "role above bot",
+			moderatorRole: 5,
+			update:        func(role *discordgo.Role) { role.Position = 4 },
+			want:          "I can only manage roles below my highest role. Move my role above <@&500> and try again.",
+		},
+		{
+			name:          "managed role",
+			moderatorRole: 3,
+			update:        func(role *discordgo.Role) { role.Managed = true },
+			want:          "<@&500> is managed by an integration and can't be assigned manually.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, session := newTestBot()
+			addTestGuild(t, session, discordgo.PermissionManageRoles)
+			addTestModerator(t, session, tt.moderatorRole)
+			setTestRole(t, session, testRoleID, tt.update)
+
+			b.Commands.HandleSlashCommand(session, newTestInteraction("role", roleOptions("add")))
+
+			if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 0 {
+				t.Fatalf("expected no role changes, got %v", adds)
+			}
+			resp := session.Responses()[0]
+			if resp.Data.Flags != discordgo.MessageFlagsEphemeral || resp.Data.Content != tt.want {
+				t.Errorf("unexpected response: %+v", resp.Data)
+			}
+			if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 0 {
+				t.Errorf("expected nothing to be audited, got %v", changes)
+			}
+		})
+	}
+}
+
+func TestRoleSlashCommandUsesInteractionMember(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	// Members of large guilds are mostly missing from the state cache
+	if err := session.State.MemberRemove(&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}}); err != nil {
+		t.Fatalf("removing member from state: %v", err)
+	}
+
+	i := newTestInteraction("role", roleOptions("add"))
+	i.Member.Permissions = discordgo.PermissionManageRoles
+	b.Commands.HandleSlashCommand(session, i)
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 1 {
+		t.Fatalf("expected 1 role addition, got %d: %+v", len(adds), session.Responses()[0].Data)
+	}
+	if lookups := session.Calls("GuildMember"); len(lookups) != 0 {
+		t.Errorf("expected the member to come from the interaction, got %v", lookups)
+	}
+}
+
+func TestRoleSlashCommandRejectsEveryone(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	options := roleOptions("add")
+	options.Options[1].Value = testGuildID
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", options))
+
+	if got := session.Responses()[0].Data.Content; got != "The @everyone role can't be assigned." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestRoleSlashCommandRequiresBotPermission(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, 0)
+
+	// Let the invoking user manage roles, so only the bot lacks the permission
+	guild, _ := session.State.Guild(testGuildID)
+	guild.OwnerID = testUserID
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", roleOptions("add")))
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 0 {
+		t.Fatalf("expected no role changes, got %v", adds)
+	}
+
+	resp := session.Responses()[0]
+	if resp.Data.Flags != discordgo.MessageFlagsEphemeral || resp.Data.Content != "I need the following permissions to do that: Manage Roles." {
+		t.Errorf("unexpected response: %+v", resp.Data)
+	}
+}
+
+// newTestContextMenu builds a context-menu invocation on targetID
+func newTestContextMenu(name, targetID string, resolved *discordgo.ApplicationCommandInteractionDataResolved) *discordgo.InteractionCreate {
+	i := newTestInteraction(name)
+	i.Data = discordgo.ApplicationCommandInteractionData{
+		Name:     name,
+		TargetID: targetID,
+		Resolved: resolved,
+	}
+	return i
+}
+
+func TestUserInfoContextMenu(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestContextMenu("User Info", "700", &discordgo.ApplicationCommandInteractionDataResolved{
+		Users: map[string]*discordgo.User{"700": {ID: "700", Username: "target"}},
+	}))
+
+	resp := session.Responses()[0]
+	if len(resp.Data.Embeds) != 1 || resp.Data.Embeds[0].Title != "target" {
+		t.Errorf("unexpected response: %+v", resp.Data)
+	}
+}
+
+func TestReportMessageContextMenuIsLoggedWithType(t *testing.T) {
+	b, session := newTestBot()
+	b.Reports.SaveReportChannel(testGuildID, "301")
+
+	var logged *CommandContext
+	b.Commands.Use(func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			logged = ctx
+			return next(ctx)
+		}
+	})
+
+	b.Commands.HandleSlashCommand(session, newTestContextMenu("Report Message", "800", &discordgo.ApplicationCommandInteractionDataResolved{
+		Messages: map[string]*discordgo.Message{"800": {ID: "800", Content: "spam", Author: &discordgo.User{ID: "700"}}},
+	}))
+
+	if logged == nil || logged.Type != CommandTypeMessageMenu {
+		t.Fatalf("expected a message context-menu invocation, got %+v", logged)
+	}
+	if logged.Arguments["target_id"] != "800" || logged.Arguments["content"] != "spam" {
+		t.Errorf("unexpected logged arguments %v", logged.Arguments)
+	}
+	if got := session.Responses()[0].Data.Content; got != "Thanks, the message has been reported to the moderators." {
+		t.Errorf("unexpected response %q", got)
+	}
+
+	reports := session.Calls("ChannelMessageSendEmbed")
+	if len(reports) != 1 || reports[0].Args[0] != "301" {
+		t.Fatalf("expected the report in the report channel, got %v", reports)
+	}
+	embed := reports[0].Args[1].(*discordgo.MessageEmbed)
+	if embed.URL != "https://discord.com/channels/200/300/800" || embed.Description != "spam" || embed.Fields[0].Value != "<@700>" || embed.Fields[1].Value != "<@400>" {
+		t.Errorf("unexpected report: %+v", embed)
+	}
+}
+
+func TestHelpListsContextMenuCommandsSeparately(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("help"))
+
+	description := session.Responses()[0].Data.Embeds[0].Description
+	if strings.Contains(description, "`/User Info`") || !strings.Contains(description, "`User Info`") {
+		t.Errorf("expected User Info under context menu commands, got:\n%s", description)
+	}
+}
@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// CommandSyncPlan describes the changes needed to bring the commands
+// registered with Discord in line with the local definitions
+type CommandSyncPlan struct {
+	Create    []*discordgo.ApplicationCommand
+	Update    []*discordgo.ApplicationCommand
+	Delete    []*discordgo.ApplicationCommand
+	Unchanged []*discordgo.ApplicationCommand
+}
+
+// Empty reports whether the plan has no changes to apply
+func (p *CommandSyncPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// String summarizes the plan for logging
+func (p *CommandSyncPlan) String() string {
+	return fmt.Sprintf("%d to create, %d to update, %d to delete, %d unchanged",
+		len(p.Create), len(p.Update), len(p.Delete), len(p.Unchanged))
+}
+
+// PlanCommandSync computes the create/update/delete diff between the local
+// command definitions and the commands currently registered with Discord
+func PlanCommandSync(local, remote []*discordgo.ApplicationCommand) *CommandSyncPlan {
+	plan := &CommandSyncPlan{}
+
+	remoteByKey := make(map[string]*discordgo.ApplicationCommand, len(remote))
+	for _, cmd := range remote {
+		remoteByKey[commandKey(cmd)] = cmd
+	}
+
+	for _, cmd := range local {
+		key := commandKey(cmd)
+		existing, ok := remoteByKey[key]
+		switch {
+		case !ok:
+			plan.Create = append(plan.Create, cmd)
+		case !commandsEqual(cmd, existing):
+			plan.Update = append(plan.Update, cmd)
+		default:
+			plan.Unchanged = append(plan.Unchanged, cmd)
+		}
+		delete(remoteByKey, key)
+	}
+
+	for _, cmd := range remote {
+		if _, stale := remoteByKey[commandKey(cmd)]; stale {
+			plan.Delete = append(plan.Delete, cmd)
+		}
+	}
+
+	return plan
+}
+
+// SyncSlashCommands brings the commands registered with Discord in line with
+// the local definitions using a single bulk overwrite. In dry-run mode the
+// planned changes are only logged.
+func (h *CommandHandler) SyncSlashCommands(dryRun bool) (*CommandSyncPlan, error) {
+	appID := h.Bot.Session.BotUserID()
+	guildID := h.commandGuildID()
+
+	remote, err := h.Bot.Session.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching registered slash commands: %w", err)
+	}
+
+	local := h.applicationCommands()
+	plan := PlanCommandSync(local, remote)
+
+	for _, cmd := range plan.Create {
+		logrus.Infof("Slash command sync: create %s", cmd.Name)
+	}
+	for _, cmd := range plan.Update {
+		logrus.Infof("Slash command sync: update %s", cmd.Name)
+	}
+	for _, cmd := range plan.Delete {
+		logrus.Infof("Slash command sync: delete %s", cmd.Name)
+	}
+
+	if dryRun {
+		logrus.Infof("Slash command sync dry run: %s", plan)
+		return plan, nil
+	}
+
+	if plan.Empty() {
+		logrus.Infof("Slash commands are up to date (%d registered)", len(plan.Unchanged))
+		return plan, nil
+	}
+
+	if _, err := h.Bot.Session.ApplicationCommandBulkOverwrite(appID, guildID, local); err != nil {
+		return nil, fmt.Errorf("error overwriting slash commands: %w", err)
+	}
+
+	logrus.Infof("Synced slash commands: %s", plan)
+	return plan, nil
+}
+
+// applicationCommands returns the local command definitions sorted by name
+func (h *CommandHandler) applicationCommands() []*discordgo.ApplicationCommand {
+	commands := make([]*discordgo.ApplicationCommand, 0, len(h.SlashCommands))
+	for _, cmd := range h.SlashCommands {
+		// Copy so the registered definition carries the DM and member permissions
+		command := *cmd.Command
+		command.DMPermission = cmd.Availability.dmPermission()
+		if cmd.Permissions != 0 && command.DefaultMemberPermissions == nil {
+			perms := cmd.Permissions
+			command.DefaultMemberPermissions = &perms
+		}
+		commands = append(commands, &command)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commandKey(commands[i]) < commandKey(commands[j])
+	})
+
+	return commands
+}
+
+// commandGuildID returns the guild commands are registered to, or an empty
+// string for global registration
+func (h *CommandHandler) commandGuildID() string {
+	if h.Bot.Config.DevMode && h.Bot.Config.DevGuildID != "" {
+		return h.Bot.Config.DevGuildID
+	}
+	return ""
+}
+
+// commandKey identifies a command by type and name, since Discord allows
+// the same name to be reused across command types
+func commandKey(cmd *discordgo.ApplicationCommand) string {
+	cmdType := cmd.Type
+	if cmdType == 0 {
+		cmdType = discordgo.ChatApplicationCommand
+	}
+	return fmt.Sprintf("%d:%s", cmdType, cmd.Name)
+}
+
+// commandsEqual reports whether two commands have the same user-visible definition
+func commandsEqual(a, b *discordgo.ApplicationCommand) bool {
+	aJSON, errA := json.Marshal(normalizeCommand(a))
+	bJSON, errB := json.Marshal(normalizeCommand(b))
+	if errA != nil || errB != nil {
+		return false
+	}
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// normalizeCommand strips server-assigned fields and fills in Discord's
+// defaults so local and remote definitions can be compared
+func normalizeCommand(cmd *discordgo.ApplicationCommand) *discordgo.ApplicationCommand {
+	dmPermission := true
+	if cmd.DMPermission != nil {
+		dmPermission = *cmd.DMPermission
+	}
+
+	var defaultMemberPermissions *int64
+	if cmd.DefaultMemberPermissions != nil {
+		perms := *cmd.DefaultMemberPermissions
+		defaultMemberPermissions = &perms
+	}
+
+	nsfw := cmd.NSFW != nil && *cmd.NSFW
+
+	cmdType := cmd.Type
+	if cmdType == 0 {
+		cmdType = discordgo.ChatApplicationCommand
+	}
+
+	return &discordgo.ApplicationCommand{
+		Type:                     cmdType,
+		Name:                     cmd.Name,
+		NameLocalizations:        normalizeLocalizations(cmd.NameLocalizations),
+		Description:              cmd.Description,
+		DescriptionLocalizations: normalizeLocalizations(cmd.DescriptionLocalizations),
+		DefaultMemberPermissions: defaultMemberPermissions,
+		DMPermission:             &dmPermission,
+		NSFW:                     &nsfw,
+		Options:                  normalizeOptions(cmd.Options),
+	}
+}
+
+// normalizeLocalizations treats an empty localization map the same as a missing one
+func normalizeLocalizations(l *map[discordgo.Locale]string) *map[discordgo.Locale]string {
+	if l == nil || len(*l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// normalizeOptions treats empty slices the same as missing ones, recursively
+func normalizeOptions(options []*discordgo.ApplicationCommandOption) []*discordgo.ApplicationCommandOption {
+	if len(options) == 0 {
+		return nil
+	}
+
+	normalized := make([]*discordgo.ApplicationCommandOption, len(options))
+	for i, opt := range options {
+		o := *opt
+		if len(o.NameLocalizations) == 0 {
+			o.NameLocalizations = nil
+		}
+		if len(o.DescriptionLocalizations) == 0 {
+			o.DescriptionLocalizations = nil
+		}
+		if len(o.ChannelTypes) == 0 {
+			o.ChannelTypes = nil
+		}
+		if len(o.Choices) == 0 {
+			o.Choices = nil
+		}
+		o.Options = normalizeOptions(o.Options)
+		normalized[i] = &o
+	}
+
+	return normalized
+}
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPlanCommandSync(t *testing.T) {
+	local := []*discordgo.ApplicationCommand{
+		{Name: "help", Description: "Shows the help message"},
+		{Name: "ping", Description: "Checks if the bot is online"},
+		{Name: "info", Description: "Shows information about the bot"},
+	}
+	remote := []*discordgo.ApplicationCommand{
+		{ID: "1", Type: discordgo.ChatApplicationCommand, Name: "help", Description: "Shows the help message", Options: []*discordgo.ApplicationCommandOption{}},
+		{ID: "2", Type: discordgo.ChatApplicationCommand, Name: "ping", Description: "Old description"},
+		{ID: "3", Type: discordgo.ChatApplicationCommand, Name: "stale", Description: "No longer defined"},
+	}
+
+	plan := PlanCommandSync(local, remote)
+
+	assertNames := func(kind string, got []*discordgo.ApplicationCommand, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %v, got %d commands", kind, want, len(got))
+		}
+		for i, cmd := range got {
+			if cmd.Name != want[i] {
+				t.Errorf("%s: expected %s at %d, got %s", kind, want[i], i, cmd.Name)
+			}
+		}
+	}
+
+	assertNames("create", plan.Create, "info")
+	assertNames("update", plan.Update, "ping")
+	assertNames("delete", plan.Delete, "stale")
+	assertNames("unchanged", plan.Unchanged, "help")
+}
+
+func TestSyncSlashCommandsDryRun(t *testing.T) {
+	b, session := newTestBot()
+
+	plan, err := b.Commands.SyncSlashCommands(true)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if len(plan.Create) != len(b.Commands.SlashCommands) {
+		t.Errorf("expected every command to be created, got %s", plan)
+	}
+	if calls := session.Calls("ApplicationCommandBulkOverwrite"); len(calls) != 0 {
+		t.Errorf("dry run must not overwrite commands, got %d calls", len(calls))
+	}
+}
+
+func TestSyncSlashCommandsIsIdempotent(t *testing.T) {
+	b, session := newTestBot()
+
+	if _, err := b.Commands.SyncSlashCommands(false); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	plan, err := b.Commands.SyncSlashCommands(false)
+	if err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+
+	if !plan.Empty() {
+		t.Errorf("expected no changes on second sync, got %s", plan)
+	}
+	if calls := session.Calls("ApplicationCommandBulkOverwrite"); len(calls) != 1 {
+		t.Errorf("expected a single bulk overwrite, got %d", len(calls))
+	}
+}
+
+func TestApplicationCommandsSetDefaultMemberPermissions(t *testing.T) {
+	b, _ := newTestBot()
+
+	for _, cmd := range b.Commands.applicationCommands() {
+		perms := b.Commands.SlashCommands[cmd.Name].Permissions
+		switch {
+		case perms == 0 && cmd.DefaultMemberPermissions != nil:
+			t.Errorf("%s: expected no default member permissions", cmd.Name)
+		case perms != 0 && (cmd.DefaultMemberPermissions == nil || *cmd.DefaultMemberPermissions != perms):
+			t.Errorf("%s: expected default member permissions %d", cmd.Name, perms)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package bot
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sirupsen/logrus"
@@ -13,21 +15,42 @@ type CommandHandler struct {
 	Bot            *Bot
 	PrefixCommands map[string]PrefixCommand
 	SlashCommands  map[string]SlashCommand
+	Middlewares    []Middleware // Applied in order, the first one outermost
+
+	massRoleGuilds sync.Map // Guilds with a mass role update in progress
 }
 
 // PrefixCommand represents a text-based command
 type PrefixCommand struct {
-	Name        string
-	Description string
-	Usage       string
-	Handler     func(s *discordgo.Session, m *discordgo.MessageCreate, args []string)
+	Name           string
+	Description    string
+	Usage          string
+	Handler        func(s Session, m *discordgo.MessageCreate, args []string)
+	Permissions    int64 // Required of the invoking user
+	BotPermissions int64 // Required of the bot
+	Cooldown       *Cooldown
+	Availability   Availability
+
+	// Set for commands registered with RegisterCommand
+	Options []*discordgo.ApplicationCommandOption
+	Run     Handler
 }
 
 // SlashCommand represents a slash command
 type SlashCommand struct {
-	Command     *discordgo.ApplicationCommand
-	Handler     func(s *discordgo.Session, i *discordgo.InteractionCreate)
-	Permissions int64
+	Command        *discordgo.ApplicationCommand
+	Handler        func(s Session, i *discordgo.InteractionCreate)
+	Permissions    int64 // Required of the invoking user
+	BotPermissions int64 // Required of the bot
+	Cooldown       *Cooldown
+	Availability   Availability
+
+	// Autocomplete callbacks by option name. The options must also set
+	// Autocomplete: true in the command definition.
+	Autocomplete map[string]AutocompleteHandler
+
+	// Set for commands registered with RegisterCommand
+	Run Handler
 }
 
 // NewCommandHandler creates a new command handler
@@ -38,6 +61,9 @@ func NewCommandHandler(bot *Bot) *CommandHandler {
 		SlashCommands:  make(map[string]SlashCommand),
 	}
 
+	// Install the default middleware chain
+	handler.Middlewares = handler.defaultMiddlewares()
+
 	// Register commands
 	handler.registerCommands()
 
@@ -46,106 +72,459 @@ func NewCommandHandler(bot *Bot) *CommandHandler {
 
 // registerCommands registers all bot commands
 func (h *CommandHandler) registerCommands() {
-	// Register prefix commands
-	h.registerPrefixCommands()
+	// Register commands available as both prefix and slash commands
+	h.registerSharedCommands()
 
 	// Register slash commands
 	h.registerSlashCommands()
 }
 
-// registerPrefixCommands registers all prefix commands
-func (h *CommandHandler) registerPrefixCommands() {
+// registerSharedCommands registers commands available as both prefix and slash commands
+func (h *CommandHandler) registerSharedCommands() {
 	// Help command
-	h.PrefixCommands["help"] = PrefixCommand{
+	h.RegisterCommand(Command{
 		Name:        "help",
 		Description: "Shows the help message",
-		Usage:       "help [command]",
-		Handler:     h.helpCommand,
-	}
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "command",
+				Description:  "The command to get help for",
+				Required:     false,
+				Autocomplete: true,
+			},
+		},
+		Autocomplete: map[string]AutocompleteHandler{
+			"command": h.helpAutocomplete,
+		},
+		Handler: h.helpCommand,
+	})
 
 	// Ping command
-	h.PrefixCommands["ping"] = PrefixCommand{
+	h.RegisterCommand(Command{
 		Name:        "ping",
 		Description: "Checks if the bot is online",
-		Usage:       "ping",
+		Cooldown:    &Cooldown{Scope: CooldownScopeUser, Period: 10 * time.Second, Burst: 2},
 		Handler:     h.pingCommand,
-	}
+	})
 
 	// Info command
-	h.PrefixCommands["info"] = PrefixCommand{
+	h.RegisterCommand(Command{
 		Name:        "info",
 		Description: "Shows information about the bot",
-		Usage:       "info",
 		Handler:     h.infoCommand,
-	}
+	})
 
-	// Play command (example for voice)
-	h.PrefixCommands["play"] = PrefixCommand{
+	// Play command
+	h.RegisterCommand(Command{
 		Name:        "play",
-		Description: "Plays audio in a voice channel",
-		Usage:       "play [URL or search term]",
-		Handler:     h.playCommand,
-	}
+		Description: "Plays audio in your voice channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "track",
+				Description: "A URL or the name of an audio file",
+				Required:    true,
+			},
+		},
+		Cooldown:     &Cooldown{Scope: CooldownScopeGuild, Period: 5 * time.Second},
+		Availability: AvailableGuildOnly,
+		Handler:      h.playCommand,
+	})
+
+	// Playback controls
+	h.RegisterCommand(Command{
+		Name:         "skip",
+		Description:  "Skips the current track",
+		Availability: AvailableGuildOnly,
+		Handler:      h.skipCommand,
+	})
+	h.RegisterCommand(Command{
+		Name:         "pause",
+		Description:  "Pauses playback",
+		Availability: AvailableGuildOnly,
+		Handler:      h.pauseCommand,
+	})
+	h.RegisterCommand(Command{
+		Name:         "resume",
+		Description:  "Resumes paused playback",
+		Availability: AvailableGuildOnly,
+		Handler:      h.resumeCommand,
+	})
+	h.RegisterCommand(Command{
+		Name:        "loop",
+		Description: "Repeats the current track or the whole queue",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "mode",
+				Description: "What to repeat",
+				Required:    true,
+				Choices:     loopModeChoices,
+			},
+		},
+		Availability: AvailableGuildOnly,
+		Handler:      h.loopCommand,
+	})
+	h.RegisterCommand(Command{
+		Name:        "volume",
+		Description: "Shows or changes the playback volume",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "percent",
+				Description: "New volume, from 0 to 200",
+				MinValue:    &minVolume,
+				MaxValue:    MaxVolume,
+			},
+		},
+		Availability: AvailableGuildOnly,
+		Handler:      h.volumeCommand,
+	})
+	h.Bot.Components.Handle("music:{action}", h.musicButton)
 }
 
 // registerSlashCommands defines all slash commands
 func (h *CommandHandler) registerSlashCommands() {
-	// Help command
-	h.SlashCommands["help"] = SlashCommand{
+	// Example button command
+	h.SlashCommands["button"] = SlashCommand{
 		Command: &discordgo.ApplicationCommand{
-			Name:        "help",
-			Description: "Shows the help message",
-			Options: []*discordgo.ApplicationCommandOption{
-				{
-					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        "command",
-					Description: "The command to get help for",
-					Required:    false,
-				},
-			},
+			Name:        "button",
+			Description: "Shows an example button",
 		},
-		Handler:     h.helpSlashCommand,
+		Run:         h.buttonSlashCommand,
 		Permissions: 0, // No special permissions required
 	}
 
-	// Ping command
-	h.SlashCommands["ping"] = SlashCommand{
+	// Example select menu command
+	h.SlashCommands["select"] = SlashCommand{
 		Command: &discordgo.ApplicationCommand{
-			Name:        "ping",
-			Description: "Checks if the bot is online",
+			Name:        "select",
+			Description: "Shows an example select menu",
 		},
-		Handler:     h.pingSlashCommand,
+		Run:         h.selectSlashCommand,
 		Permissions: 0, // No special permissions required
 	}
 
-	// Info command
-	h.SlashCommands["info"] = SlashCommand{
+	// Example modal command
+	h.SlashCommands["feedback"] = SlashCommand{
 		Command: &discordgo.ApplicationCommand{
-			Name:        "info",
-			Description: "Shows information about the bot",
+			Name:        "feedback",
+			Description: "Send feedback about the bot",
 		},
-		Handler:     h.infoSlashCommand,
-		Permissions: 0, // No special permissions required
+		Run: h.feedbackSlashCommand,
 	}
 
-	// Example button command
-	h.SlashCommands["button"] = SlashCommand{
+	// Context-menu commands
+	h.SlashCommands["User Info"] = SlashCommand{
 		Command: &discordgo.ApplicationCommand{
-			Name:        "button",
-			Description: "Shows an example button",
+			Name: "User Info",
+			Type: discordgo.UserApplicationCommand,
 		},
-		Handler:     h.buttonSlashCommand,
-		Permissions: 0, // No special permissions required
+		Run: h.userInfoCommand,
 	}
 
-	// Example select menu command
-	h.SlashCommands["select"] = SlashCommand{
+	h.SlashCommands["Report Message"] = SlashCommand{
 		Command: &discordgo.ApplicationCommand{
-			Name:        "select",
-			Description: "Shows an example select menu",
+			Name: "Report Message",
+			Type: discordgo.MessageApplicationCommand,
 		},
-		Handler:     h.selectSlashCommand,
-		Permissions: 0, // No special permissions required
+		Run:          h.reportMessageCommand,
+		Availability: AvailableGuildOnly,
+	}
+
+	// Self-assignable roles
+	h.SlashCommands["selfrole"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "selfrole",
+			Description: "Sets up roles members can give themselves",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "panel",
+					Description: "Posts a message to add role buttons to",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "title",
+							Description: "The title of the panel",
+							Required:    true,
+							MaxLength:   256,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "description",
+							Description: "Text shown below the title",
+							Required:    false,
+							MaxLength:   4000,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reaction",
+					Description: "Gives a role to members who react to a message",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message_id",
+							Description: "The message in this channel to react to",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "emoji",
+							Description: "The emoji to react with",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "The role to give",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "mode",
+							Description: "How members pick roles on the message (default: toggle)",
+							Required:    false,
+							Choices:     selfRoleModeChoices,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "button",
+					Description: "Adds a role button to one of the bot's messages",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message_id",
+							Description: "The bot's message in this channel, e.g. from /selfrole panel",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "The role to give",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "label",
+							Description: "The button text",
+							Required:    true,
+							MaxLength:   80,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "emoji",
+							Description: "An emoji shown on the button",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "mode",
+							Description: "How members pick roles on the message (default: toggle)",
+							Required:    false,
+							Choices:     selfRoleModeChoices,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "Stops a role from being picked on a message",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message_id",
+							Description: "The message the role is on",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "The role to remove",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "Lists the self-assignable roles in this server",
+				},
+			},
+		},
+		Run:            h.selfRoleSlashCommand,
+		Permissions:    discordgo.PermissionManageRoles,
+		BotPermissions: discordgo.PermissionManageRoles,
+		Availability:   AvailableGuildOnly,
+	}
+	h.Bot.Components.Handle("selfrole:{roleID}", h.Bot.selfRoleButton)
+
+	// Components and modals used by the example commands
+	h.Bot.Components.Handle("example_button", h.exampleButtonComponent)
+	h.Bot.Components.Handle("example_select", h.exampleSelectComponent)
+	h.Bot.Components.HandleModal("feedback", h.feedbackModal)
+
+	// Music queue command
+	h.SlashCommands["queue"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "queue",
+			Description: "Shows or changes the music queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "view",
+					Description: "Lists the current and upcoming tracks",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "shuffle",
+					Description: "Shuffles the upcoming tracks",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "Removes a track from the queue",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "position",
+							Description: "The track's position in /queue view",
+							Required:    true,
+							MinValue:    &minQueuePosition,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "clear",
+					Description: "Removes every upcoming track",
+				},
+			},
+		},
+		Run:          h.queueSlashCommand,
+		Availability: AvailableGuildOnly,
+	}
+
+	// Guild settings command
+	h.SlashCommands["settings"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "settings",
+			Description: "Configures the bot for this server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "prefix",
+					Description: "Shows or changes the command prefix",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "prefix",
+							Description: "The new prefix, or \"reset\" for the default",
+							Required:    false,
+							MaxLength:   MaxPrefixLength,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "welcome",
+					Description: "Shows or changes the message new members are welcomed with",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionChannel,
+							Name:         "channel",
+							Description:  "The channel welcome and goodbye messages are posted in",
+							Required:     false,
+							ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message",
+							Description: "The message, with {user}, {username}, {guild} and {memberCount}, or \"off\"",
+							Required:    false,
+							MaxLength:   MaxWelcomeLength,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "embed",
+							Description: "Whether to post welcome and goodbye messages as embeds",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "dm",
+							Description: "Whether to welcome new members by direct message instead",
+							Required:    false,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "goodbye",
+					Description: "Shows or changes the message posted when members leave",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message",
+							Description: "The message, with {user}, {username}, {guild} and {memberCount}, or \"off\"",
+							Required:    false,
+							MaxLength:   MaxWelcomeLength,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "voice",
+					Description: "Shows or changes when the bot leaves voice channels on its own",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "empty",
+							Description: "Minutes to stay once everyone else has left, or 0 to stay",
+							Required:    false,
+							MinValue:    &minVoiceTimeout,
+							MaxValue:    maxVoiceEmptyMinutes,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "idle",
+							Description: "Minutes to stay without playing anything, or 0 to stay",
+							Required:    false,
+							MinValue:    &minVoiceTimeout,
+							MaxValue:    maxVoiceIdleMinutes,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reports",
+					Description: "Shows or changes the channel reported messages are posted in",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionChannel,
+							Name:         "channel",
+							Description:  "The channel reports are posted in, for moderators",
+							Required:     false,
+							ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "off",
+							Description: "Stop posting reports",
+							Required:    false,
+						},
+					},
+				},
+			},
+		},
+		Run:          h.settingsSlashCommand,
+		Permissions:  discordgo.PermissionManageServer, // Requires manage guild permission
+		Availability: AvailableGuildOnly,
 	}
 
 	// Example role management command
@@ -171,6 +550,12 @@ func (h *CommandHandler) registerSlashCommands() {
 							Description: "The role to add",
 							Required:    true,
 						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "duration",
+							Description: "Remove the role again after this long, e.g. 30m, 12h or 7d",
+							Required:    false,
+						},
 					},
 				},
 				{
@@ -192,56 +577,80 @@ func (h *CommandHandler) registerSlashCommands() {
 						},
 					},
 				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "mass",
+					Description: "Adds or removes a role for every member matching the filters",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "action",
+							Description: "Whether to add or remove the role",
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "add", Value: RoleActionAdd},
+								{Name: "remove", Value: RoleActionRemove},
+							},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "The role to add or remove",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "has_role",
+							Description: "Only members who have this role",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "joined_after",
+							Description: "Only members who joined after this date (YYYY-MM-DD)",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "members",
+							Description: "Which members to include (default: all)",
+							Required:    false,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "all", Value: MassRoleAllMembers},
+								{Name: "humans", Value: MassRoleHumans},
+								{Name: "bots", Value: MassRoleBots},
+							},
+						},
+					},
+				},
 			},
 		},
-		Handler:     h.roleSlashCommand,
-		Permissions: discordgo.PermissionManageRoles, // Requires manage roles permission
+		Run:            h.roleSlashCommand,
+		Permissions:    discordgo.PermissionManageRoles, // Requires manage roles permission
+		BotPermissions: discordgo.PermissionManageRoles,
+		Availability:   AvailableGuildOnly,
 	}
 }
 
-// RegisterSlashCommands registers slash commands with Discord
-func (h *CommandHandler) RegisterSlashCommands() error {
-	for _, cmd := range h.SlashCommands {
-		var err error
-
-		// Register command globally or to a specific guild based on dev mode
-		if h.Bot.Config.DevMode && h.Bot.Config.DevGuildID != "" {
-			// Register to specific guild in dev mode
-			_, err = h.Bot.Session.ApplicationCommandCreate(
-				h.Bot.Session.State.User.ID,
-				h.Bot.Config.DevGuildID,
-				cmd.Command,
-			)
-		} else {
-			// Register globally in production
-			_, err = h.Bot.Session.ApplicationCommandCreate(
-				h.Bot.Session.State.User.ID,
-				"", // Empty string for global commands
-				cmd.Command,
-			)
-		}
-
-		if err != nil {
-			return fmt.Errorf("error creating slash command '%s': %w", cmd.Command.Name, err)
-		}
-
-		logrus.Infof("Registered slash command: %s", cmd.Command.Name)
-	}
-
-	return nil
+// selfRoleModeChoices are the modes offered by /selfrole
+var selfRoleModeChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "toggle: add and remove freely", Value: RoleModeToggle},
+	{Name: "unique: one role from the message", Value: RoleModeUnique},
+	{Name: "verify: can only be added", Value: RoleModeVerify},
 }
 
 // UnregisterSlashCommands removes slash commands from Discord
 func (h *CommandHandler) UnregisterSlashCommands() error {
 	// Only unregister guild commands in dev mode
-	if !h.Bot.Config.DevMode || h.Bot.Config.DevGuildID == "" {
+	guildID := h.commandGuildID()
+	if guildID == "" {
 		return nil
 	}
 
 	// Get all commands for the guild
 	commands, err := h.Bot.Session.ApplicationCommands(
-		h.Bot.Session.State.User.ID,
-		h.Bot.Config.DevGuildID,
+		h.Bot.Session.BotUserID(),
+		guildID,
 	)
 	if err != nil {
 		return fmt.Errorf("error getting slash commands: %w", err)
@@ -250,8 +659,8 @@ func (h *CommandHandler) UnregisterSlashCommands() error {
 	// Delete each command
 	for _, cmd := range commands {
 		err := h.Bot.Session.ApplicationCommandDelete(
-			h.Bot.Session.State.User.ID,
-			h.Bot.Config.DevGuildID,
+			h.Bot.Session.BotUserID(),
+			guildID,
 			cmd.ID,
 		)
 		if err != nil {
@@ -264,36 +673,106 @@ func (h *CommandHandler) UnregisterSlashCommands() error {
 	return nil
 }
 
+// Use appends middlewares to the chain every command goes through
+func (h *CommandHandler) Use(middlewares ...Middleware) {
+	h.Middlewares = append(h.Middlewares, middlewares...)
+}
+
+// HandlePrefixMessage handles a message with the prefix already stripped.
+// Arguments are only read once the command is known, so ordinary chat that
+// happens to start with the prefix is ignored.
+func (h *CommandHandler) HandlePrefixMessage(s Session, m *discordgo.MessageCreate, content string) {
+	cmdName, rest := strings.TrimLeft(content, " \t\n"), ""
+	if i := strings.IndexAny(cmdName, " \t\n"); i >= 0 {
+		cmdName, rest = cmdName[:i], cmdName[i:]
+	}
+
+	cmd, exists := h.PrefixCommands[strings.ToLower(cmdName)]
+	if !exists {
+		return
+	}
+
+	args, err := tokenizeArgs(rest)
+	if err != nil {
+		h.sendUsage(s, m, cmd, err)
+		return
+	}
+	h.runPrefixCommand(s, m, cmd, args)
+}
+
 // HandlePrefixCommand handles a prefix command
-func (h *CommandHandler) HandlePrefixCommand(s *discordgo.Session, m *discordgo.MessageCreate, cmdName string, args []string) {
+func (h *CommandHandler) HandlePrefixCommand(s Session, m *discordgo.MessageCreate, cmdName string, args []string) {
 	// Check if command exists
 	cmd, exists := h.PrefixCommands[strings.ToLower(cmdName)]
 	if !exists {
 		return
 	}
+	h.runPrefixCommand(s, m, cmd, args)
+}
 
-	// Log command usage
-	guildID := ""
-	if m.GuildID != "" {
-		guildID = m.GuildID
+// sendUsage answers invalid arguments with the error and the command's usage
+func (h *CommandHandler) sendUsage(s Session, m *discordgo.MessageCreate, cmd PrefixCommand, err error) {
+	usageErr := &UsageError{Err: err, Usage: h.Bot.Prefixes.Get(m.GuildID) + cmd.Usage}
+	if _, err := s.ChannelMessageSend(m.ChannelID, usageErr.Error()); err != nil {
+		logrus.Errorf("Error sending usage message: %v", err)
 	}
+}
 
+// runPrefixCommand runs a prefix command with its tokenized arguments
+func (h *CommandHandler) runPrefixCommand(s Session, m *discordgo.MessageCreate, cmd PrefixCommand, args []string) {
 	argumentsMap := make(map[string]interface{})
 	for i, arg := range args {
 		argumentsMap[fmt.Sprintf("arg%d", i+1)] = arg
 	}
 
-	err := h.Bot.Repository.LogCommand(guildID, m.ChannelID, m.Author.ID, cmdName, "prefix", argumentsMap)
-	if err != nil {
-		logrus.Errorf("Error logging command: %v", err)
+	// Parse options for commands that declare them. Commands without any,
+	// such as those with a legacy Handler, read ctx.Args themselves.
+	var options []*discordgo.ApplicationCommandInteractionDataOption
+	if len(cmd.Options) > 0 {
+		parser := &argParser{session: s, guildID: m.GuildID}
+		parsed, err := parser.parse(cmd.Options, args)
+		if err != nil {
+			h.sendUsage(s, m, cmd, err)
+			return
+		}
+		options = parsed
+	}
+
+	// Use the registered name so "!Daily" and "!daily" share cooldowns and logs
+	ctx := &CommandContext{
+		Session:        s,
+		Name:           cmd.Name,
+		Type:           CommandTypePrefix,
+		GuildID:        m.GuildID,
+		ChannelID:      m.ChannelID,
+		UserID:         m.Author.ID,
+		Arguments:      argumentsMap,
+		Permissions:    cmd.Permissions,
+		BotPermissions: cmd.BotPermissions,
+		Cooldown:       cmd.Cooldown,
+		Availability:   cmd.Availability,
+		Options:        options,
+		Message:        m,
+		Args:           args,
 	}
 
-	// Execute command handler
-	cmd.Handler(s, m, args)
+	run := cmd.Run
+	if run == nil {
+		run = func(ctx *CommandContext) error {
+			cmd.Handler(ctx.Session, ctx.Message, ctx.Args)
+			return nil
+		}
+	}
+
+	handler := Chain(run, h.Middlewares...)
+
+	if err := handler(ctx); err != nil {
+		logrus.Errorf("Unhandled error in prefix command '%s': %v", ctx.Name, err)
+	}
 }
 
 // HandleSlashCommand handles a slash command
-func (h *CommandHandler) HandleSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) HandleSlashCommand(s Session, i *discordgo.InteractionCreate) {
 	// Get command name
 	cmdName := i.ApplicationCommandData().Name
 
@@ -310,45 +789,30 @@ func (h *CommandHandler) HandleSlashCommand(s *discordgo.Session, i *discordgo.I
 		return
 	}
 
-	// Check permissions if in a guild
-	if i.GuildID != "" && cmd.Permissions != 0 {
-		// Get member permissions
-		perms, err := s.State.UserChannelPermissions(i.Member.User.ID, i.ChannelID)
-		if err != nil {
-			logrus.Errorf("Error checking permissions: %v", err)
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "An error occurred while checking permissions.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
-			return
-		}
-
-		// Check if user has required permissions
-		if perms&cmd.Permissions == 0 {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You don't have permission to use this command.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
-			return
-		}
-	}
-
-	// Log command usage
-	guildID := ""
-	if i.GuildID != "" {
-		guildID = i.GuildID
-	}
-
 	// Extract command options as arguments
 	data := i.ApplicationCommandData()
 	argumentsMap := make(map[string]interface{})
 
+	// Context-menu commands have a target instead of options
+	cmdType := CommandTypeSlash
+	switch cmd.Command.Type {
+	case discordgo.UserApplicationCommand:
+		cmdType = CommandTypeUserMenu
+		argumentsMap["target_id"] = data.TargetID
+	case discordgo.MessageApplicationCommand:
+		cmdType = CommandTypeMessageMenu
+		argumentsMap["target_id"] = data.TargetID
+		if data.Resolved != nil {
+			if msg := data.Resolved.Messages[data.TargetID]; msg != nil {
+				// Keep the content in the log in case the message is deleted
+				argumentsMap["content"] = msg.Content
+				if msg.Author != nil {
+					argumentsMap["author_id"] = msg.Author.ID
+				}
+			}
+		}
+	}
+
 	// Handle options based on command structure
 	if len(data.Options) > 0 {
 		// Check if this is a subcommand
@@ -368,11 +832,41 @@ func (h *CommandHandler) HandleSlashCommand(s *discordgo.Session, i *discordgo.I
 		}
 	}
 
-	err := h.Bot.Repository.LogCommand(guildID, i.ChannelID, i.Member.User.ID, cmdName, "slash", argumentsMap)
-	if err != nil {
-		logrus.Errorf("Error logging command: %v", err)
+	ctx := &CommandContext{
+		Session:        s,
+		Name:           cmd.Command.Name,
+		Type:           cmdType,
+		GuildID:        i.GuildID,
+		ChannelID:      i.ChannelID,
+		UserID:         interactionUserID(i.Interaction),
+		Arguments:      argumentsMap,
+		Permissions:    cmd.Permissions,
+		BotPermissions: cmd.BotPermissions,
+		Cooldown:       cmd.Cooldown,
+		Availability:   cmd.Availability,
+		Options:        data.Options,
+		Interaction:    i,
 	}
 
-	// Execute command handler
-	cmd.Handler(s, i)
-}
\ No newline at end of file
+	// Track responses sent directly through the session by legacy handlers
+	ctx.trackResponses()
+
+	// Acknowledge the interaction before Discord's deadline if the handler is slow
+	if budget := h.Bot.Config.DeferAfter; budget > 0 {
+		defer ctx.deferAfter(budget)()
+	}
+
+	run := cmd.Run
+	if run == nil {
+		run = func(ctx *CommandContext) error {
+			cmd.Handler(ctx.Session, ctx.Interaction)
+			return nil
+		}
+	}
+
+	handler := Chain(run, h.Middlewares...)
+
+	if err := handler(ctx); err != nil {
+		logrus.Errorf("Unhandled error in slash command '%s': %v", ctx.Name, err)
+	}
+}
@@ -0,0 +1,335 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// CommandTypeComponent is recorded for failed component handlers
+const CommandTypeComponent = "component"
+
+// ComponentHandler handles a button click or select menu choice
+type ComponentHandler func(ctx *ComponentContext) error
+
+// ComponentContext carries a single component interaction to its handler.
+// Replies made through the embedded CommandContext are sent as new messages;
+// use Update to change the message the component is attached to.
+type ComponentContext struct {
+	*CommandContext
+	Data   discordgo.MessageComponentInteractionData
+	Params map[string]string // Values captured from the custom_id pattern
+}
+
+// Param returns a value captured from the custom_id pattern
+func (ctx *ComponentContext) Param(name string) string {
+	return ctx.Params[name]
+}
+
+// Values returns the values chosen in a select menu
+func (ctx *ComponentContext) Values() []string {
+	return ctx.Data.Values
+}
+
+// Update replaces the message the component is attached to
+func (ctx *ComponentContext) Update(resp *CommandResponse) error {
+	return ctx.respondInteraction(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    resp.Content,
+			Embeds:     resp.Embeds,
+			Components: resp.Components,
+		},
+	})
+}
+
+// customIDPattern matches custom IDs against a pattern such as
+// "poll:vote:{pollID}:{option}". A trailing "*" segment matches any remainder.
+type customIDPattern struct {
+	pattern  string
+	segments []string
+}
+
+func newCustomIDPattern(pattern string) customIDPattern {
+	return customIDPattern{pattern: pattern, segments: strings.Split(pattern, ":")}
+}
+
+// match reports whether a custom ID matches the pattern and returns the captured parameters
+func (p customIDPattern) match(customID string) (map[string]string, bool) {
+	parts := strings.Split(customID, ":")
+	params := make(map[string]string)
+
+	for i, segment := range p.segments {
+		if segment == "*" && i == len(p.segments)-1 {
+			if i >= len(parts) {
+				return nil, false
+			}
+			params["*"] = strings.Join(parts[i:], ":")
+			return params, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = parts[i]
+		} else if segment != parts[i] {
+			return nil, false
+		}
+	}
+
+	if len(parts) != len(p.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+type componentRoute struct {
+	customIDPattern
+	handler ComponentHandler
+}
+
+type modalRoute struct {
+	customIDPattern
+	handler ModalHandler
+}
+
+// ComponentRouter dispatches component interactions and modal submissions
+// to handlers by custom ID
+type ComponentRouter struct {
+	Middlewares []Middleware // Applied in order, the first one outermost
+
+	routes   []*componentRoute
+	modals   []*modalRoute
+	watchers []*componentWatcher  // Collectors waiting for interactions
+	expired  map[string]time.Time // When the components on a message expired, by key
+	mu       sync.RWMutex
+}
+
+// NewComponentRouter creates an empty component router
+func NewComponentRouter() *ComponentRouter {
+	return &ComponentRouter{
+		expired: make(map[string]time.Time),
+	}
+}
+
+// Handle registers a handler for custom IDs matching pattern. Patterns are
+// colon-separated; "{name}" segments capture a value and a trailing "*"
+// matches any remainder, e.g. "poll:vote:{pollID}:{option}" or "ticket:*".
+// Routes are tried in the order they were registered.
+func (r *ComponentRouter) Handle(pattern string, handler ComponentHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(r.routes, &componentRoute{
+		customIDPattern: newCustomIDPattern(pattern),
+		handler:         handler,
+	})
+}
+
+// HandleModal registers a handler for modal submissions whose custom ID
+// matches pattern. Patterns work the same as for Handle.
+func (r *ComponentRouter) HandleModal(pattern string, handler ModalHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.modals = append(r.modals, &modalRoute{
+		customIDPattern: newCustomIDPattern(pattern),
+		handler:         handler,
+	})
+}
+
+// Use appends middlewares to the chain run around every component handler
+func (r *ComponentRouter) Use(middlewares ...Middleware) {
+	r.Middlewares = append(r.Middlewares, middlewares...)
+}
+
+// route returns the first route matching a custom ID
+func (r *ComponentRouter) route(customID string) (*componentRoute, map[string]string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, route := range r.routes {
+		if params, ok := route.match(customID); ok {
+			return route, params
+		}
+	}
+	return nil, nil
+}
+
+// Dispatch routes a component interaction to its handler. It reports
+// whether a handler was found.
+func (r *ComponentRouter) Dispatch(s Session, i *discordgo.InteractionCreate) bool {
+	// Collectors take precedence over routes
+	if r.dispatchWatchers(s, i) {
+		return true
+	}
+
+	data := i.MessageComponentData()
+
+	route, params := r.route(data.CustomID)
+	if route == nil {
+		return false
+	}
+
+	ctx := &ComponentContext{
+		CommandContext: &CommandContext{
+			Session:     s,
+			Name:        route.pattern,
+			Type:        CommandTypeComponent,
+			GuildID:     i.GuildID,
+			ChannelID:   i.ChannelID,
+			UserID:      interactionUserID(i.Interaction),
+			Interaction: i,
+		},
+		Data:   data,
+		Params: params,
+	}
+	ctx.trackResponses()
+
+	if r.isExpired(i.Message) {
+		if err := ctx.replyEphemeral("This has expired."); err != nil {
+			logrus.Errorf("Error replying to expired component: %v", err)
+		}
+		return true
+	}
+
+	r.run(ctx.CommandContext, data.CustomID, func() error {
+		return route.handler(ctx)
+	})
+	return true
+}
+
+// run calls a handler through the router's middlewares
+func (r *ComponentRouter) run(ctx *CommandContext, customID string, handler func() error) {
+	run := func(*CommandContext) error {
+		return handler()
+	}
+	if err := Chain(run, r.Middlewares...)(ctx); err != nil {
+		logrus.Errorf("Unhandled error in %s %s: %v", ctx.Type, customID, err)
+	}
+}
+
+// ExpireAfter disables the components on a command's reply once the
+// duration has passed. Clicks arriving after that are rejected even if the
+// message could not be edited.
+func (r *ComponentRouter) ExpireAfter(ctx *CommandContext, after time.Duration) {
+	time.AfterFunc(after, func() {
+		r.expire(ctx)
+	})
+}
+
+// expiredRetention is how long expired replies are remembered, matching
+// the lifetime of an interaction token. The components were disabled when
+// they expired, so only clicks on replies that couldn't be edited get
+// through after this.
+const expiredRetention = 15 * time.Minute
+
+// expire marks a reply's components as expired and disables them
+func (r *ComponentRouter) expire(ctx *CommandContext) {
+	r.markExpired(expiryKey(ctx), time.Now())
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	components := disableComponents(ctx.components)
+
+	var err error
+	if ctx.Interaction == nil {
+		if ctx.reply == nil {
+			return
+		}
+		edit := discordgo.NewMessageEdit(ctx.reply.ChannelID, ctx.reply.ID)
+		edit.Components = components
+		_, err = ctx.session().ChannelMessageEditComplex(edit)
+	} else {
+		_, err = ctx.editInteractionLocked(&discordgo.WebhookEdit{
+			Components: &components,
+		})
+	}
+	if err != nil {
+		logrus.Warnf("Error disabling expired components on %s: %v", ctx.Name, err)
+	}
+}
+
+// markExpired records that the components under key expired at now, and
+// forgets replies that expired more than expiredRetention ago
+func (r *ComponentRouter) markExpired(key string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, at := range r.expired {
+		if now.Sub(at) > expiredRetention {
+			delete(r.expired, k)
+		}
+	}
+	r.expired[key] = now
+}
+
+// isExpired reports whether the components on a message have expired
+func (r *ComponentRouter) isExpired(msg *discordgo.Message) bool {
+	if msg == nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.expired["message:"+msg.ID]; ok {
+		return true
+	}
+	if msg.Interaction == nil {
+		return false
+	}
+	_, ok := r.expired["interaction:"+msg.Interaction.ID]
+	return ok
+}
+
+// expiryKey identifies a command's reply. Slash command replies are only
+// known by the interaction that created them.
+func expiryKey(ctx *CommandContext) string {
+	if ctx.Interaction != nil {
+		return "interaction:" + ctx.Interaction.ID
+	}
+	if ctx.reply != nil {
+		return "message:" + ctx.reply.ID
+	}
+	return ""
+}
+
+// disableComponents returns a copy of components with every button and select menu disabled
+func disableComponents(components []discordgo.MessageComponent) []discordgo.MessageComponent {
+	disabled := make([]discordgo.MessageComponent, 0, len(components))
+	for _, component := range components {
+		switch c := component.(type) {
+		case discordgo.ActionsRow:
+			disabled = append(disabled, discordgo.ActionsRow{Components: disableComponents(c.Components)})
+		case *discordgo.ActionsRow:
+			disabled = append(disabled, discordgo.ActionsRow{Components: disableComponents(c.Components)})
+		case discordgo.Button:
+			c.Disabled = c.Style != discordgo.LinkButton
+			disabled = append(disabled, c)
+		case *discordgo.Button:
+			button := *c
+			button.Disabled = button.Style != discordgo.LinkButton
+			disabled = append(disabled, button)
+		case discordgo.SelectMenu:
+			c.Disabled = true
+			disabled = append(disabled, c)
+		case *discordgo.SelectMenu:
+			menu := *c
+			menu.Disabled = true
+			disabled = append(disabled, menu)
+		default:
+			disabled = append(disabled, component)
+		}
+	}
+	return disabled
+}
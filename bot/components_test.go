@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newTestComponentInteraction builds a button click on the given message
+func newTestComponentInteraction(customID string, message *discordgo.Message) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:        "1200000000000000000",
+			Type:      discordgo.InteractionMessageComponent,
+			GuildID:   testGuildID,
+			ChannelID: testChannelID,
+			Member:    &discordgo.Member{User: &discordgo.User{ID: testUserID}},
+			Message:   message,
+			Data: discordgo.MessageComponentInteractionData{
+				CustomID:      customID,
+				ComponentType: discordgo.ButtonComponent,
+			},
+		},
+	}
+}
+
+func TestCustomIDPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		customID string
+		params   map[string]string
+	}{
+		{"example_button", "example_button", map[string]string{}},
+		{"example_button", "example_button:1", nil},
+		{"poll:vote:{pollID}:{option}", "poll:vote:42:yes", map[string]string{"pollID": "42", "option": "yes"}},
+		{"poll:vote:{pollID}:{option}", "poll:vote:42", nil},
+		{"poll:vote:{pollID}:{option}", "poll:vote::yes", nil},
+		{"poll:vote:{pollID}:{option}", "poll:close:42:yes", nil},
+		{"ticket:*", "ticket:open:7", map[string]string{"*": "open:7"}},
+		{"ticket:*", "ticket", nil},
+	}
+
+	for _, tt := range tests {
+		params, ok := newCustomIDPattern(tt.pattern).match(tt.customID)
+		if ok != (tt.params != nil) {
+			t.Errorf("%s / %s: expected match %v", tt.pattern, tt.customID, tt.params != nil)
+			continue
+		}
+		for name, want := range tt.params {
+			if params[name] != want {
+				t.Errorf("%s / %s: expected %s=%q, got %q", tt.pattern, tt.customID, name, want, params[name])
+			}
+		}
+	}
+}
+
+func TestComponentRouterPassesParams(t *testing.T) {
+	b, session := newTestBot()
+
+	var pollID, option string
+	b.Components.Handle("poll:vote:{pollID}:{option}", func(ctx *ComponentContext) error {
+		pollID, option = ctx.Param("pollID"), ctx.Param("option")
+		return ctx.Update(&CommandResponse{Content: "Thanks for voting!"})
+	})
+
+	if !b.Components.Dispatch(session, newTestComponentInteraction("poll:vote:42:yes", nil)) {
+		t.Fatal("expected the route to match")
+	}
+	if pollID != "42" || option != "yes" {
+		t.Errorf("unexpected params %q %q", pollID, option)
+	}
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseUpdateMessage {
+		t.Errorf("expected a message update, got %+v", responses)
+	}
+}
+
+func TestComponentRouterUnknownCustomID(t *testing.T) {
+	b, session := newTestBot()
+
+	if b.Components.Dispatch(session, newTestComponentInteraction("nope", nil)) {
+		t.Error("expected no route to match")
+	}
+}
+
+func TestComponentRouterRecordsPanics(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Components.Handle("boom", func(ctx *ComponentContext) error {
+		panic("kaboom")
+	})
+
+	b.Components.Dispatch(session, newTestComponentInteraction("boom", nil))
+
+	if errors := b.ErrorLog.(*memoryErrorLog).errors; len(errors) != 1 {
+		t.Errorf("expected the panic to be recorded, got %v", errors)
+	}
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Data.Content != genericErrorMessage {
+		t.Errorf("expected a generic error reply, got %+v", responses)
+	}
+}
+
+func TestExpiredComponentsAreDisabledAndRejected(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("button"))
+	ctx := &CommandContext{
+		Session:     session,
+		Name:        "button",
+		Interaction: newTestInteraction("button"),
+		components:  session.Responses()[0].Data.Components,
+		responded:   true,
+	}
+
+	b.Components.expire(ctx)
+
+	edits := session.Calls("InteractionResponseEdit")
+	if len(edits) != 1 {
+		t.Fatalf("expected the reply to be edited, got %d edits", len(edits))
+	}
+	row := (*edits[0].Args[1].(*discordgo.WebhookEdit).Components)[0].(discordgo.ActionsRow)
+	if button := row.Components[0].(discordgo.Button); !button.Disabled {
+		t.Error("expected the button to be disabled")
+	}
+	if link := row.Components[1].(discordgo.Button); link.Disabled {
+		t.Error("expected the link button to stay enabled")
+	}
+
+	message := &discordgo.Message{ID: "1300", Interaction: &discordgo.MessageInteraction{ID: ctx.Interaction.ID}}
+	b.Components.Dispatch(session, newTestComponentInteraction("example_button", message))
+
+	responses := session.Responses()
+	if got := responses[len(responses)-1].Data.Content; got != "This has expired." {
+		t.Errorf("expected the click to be rejected, got %q", got)
+	}
+}
+
+func TestExpiredRepliesAreForgotten(t *testing.T) {
+	router := NewComponentRouter()
+	start := time.Now()
+
+	router.markExpired("message:1", start)
+	router.markExpired("message:2", start.Add(expiredRetention))
+	if len(router.expired) != 2 {
+		t.Fatalf("expected both replies to be remembered, got %d", len(router.expired))
+	}
+
+	router.markExpired("message:3", start.Add(expiredRetention+time.Second))
+	if router.isExpired(&discordgo.Message{ID: "1"}) {
+		t.Error("expected the oldest reply to be forgotten")
+	}
+	if !router.isExpired(&discordgo.Message{ID: "2"}) || !router.isExpired(&discordgo.Message{ID: "3"}) {
+		t.Error("expected recent replies to stay expired")
+	}
+}
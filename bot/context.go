@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command types recorded in command logs
+const (
+	CommandTypePrefix      = "prefix"
+	CommandTypeSlash       = "slash"
+	CommandTypeUserMenu    = "user_context"
+	CommandTypeMessageMenu = "message_context"
+)
+
+// CommandContext carries a single command invocation through the middleware
+// chain and lets handlers reply without caring whether they were invoked
+// as a prefix or a slash command
+type CommandContext struct {
+	Session        Session
+	Name           string
+	Type           string
+	GuildID        string
+	ChannelID      string
+	UserID         string
+	Arguments      map[string]interface{}
+	Permissions    int64 // Required of the invoking user
+	BotPermissions int64 // Required of the bot
+	Cooldown       *Cooldown
+	Availability   Availability
+
+	// Options holds the parsed command options for both command types
+	Options []*discordgo.ApplicationCommandInteractionDataOption
+
+	// Set for prefix commands
+	Message *discordgo.MessageCreate
+	Args    []string
+
+	// Set for slash commands
+	Interaction *discordgo.InteractionCreate
+
+	mu         sync.Mutex // Guards the response state, which auto-defer updates concurrently
+	api        Session    // Session used for responses, set when Session is wrapped by trackResponses
+	deferred   bool
+	publicWait bool // The deferred response is a public "thinking" message
+	responded  bool
+	reply      *discordgo.Message           // Reply message sent for a prefix command
+	components []discordgo.MessageComponent // Components on the latest reply
+}
+
+// CommandResponse is a reply to a command
+type CommandResponse struct {
+	Content    string
+	Embeds     []*discordgo.MessageEmbed
+	Components []discordgo.MessageComponent
+	Ephemeral  bool // Only honored for slash commands
+}
+
+// ErrNoResponse is returned when editing a response that was never sent
+var ErrNoResponse = errors.New("no response has been sent")
+
+// InvocationID returns the ID of the message or interaction that invoked the command
+func (ctx *CommandContext) InvocationID() string {
+	if ctx.Interaction != nil {
+		return ctx.Interaction.ID
+	}
+	if ctx.Message != nil {
+		return ctx.Message.ID
+	}
+	return ""
+}
+
+// Option returns the option with the given name, or nil if it was not provided
+func (ctx *CommandContext) Option(name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range ctx.Options {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// StringOption returns a string option, or an empty string if it was not provided
+func (ctx *CommandContext) StringOption(name string) string {
+	opt := ctx.Option(name)
+	if opt == nil {
+		return ""
+	}
+	return opt.StringValue()
+}
+
+// IDOption returns the ID from a user, role, channel or mentionable option,
+// or an empty string if it was not provided
+func (ctx *CommandContext) IDOption(name string) string {
+	opt := ctx.Option(name)
+	if opt == nil {
+		return ""
+	}
+	id, _ := opt.Value.(string)
+	return id
+}
+
+// IntOption returns an integer option, or def if it was not provided
+func (ctx *CommandContext) IntOption(name string, def int64) int64 {
+	opt := ctx.Option(name)
+	if opt == nil {
+		return def
+	}
+	return opt.IntValue()
+}
+
+// BoolOption returns a boolean option, or def if it was not provided
+func (ctx *CommandContext) BoolOption(name string, def bool) bool {
+	opt := ctx.Option(name)
+	if opt == nil {
+		return def
+	}
+	return opt.BoolValue()
+}
+
+// TargetUser returns the user a user context-menu command was used on
+func (ctx *CommandContext) TargetUser() *discordgo.User {
+	if ctx.Interaction == nil {
+		return nil
+	}
+	data := ctx.Interaction.ApplicationCommandData()
+	if data.Resolved == nil {
+		return nil
+	}
+	return data.Resolved.Users[data.TargetID]
+}
+
+// TargetMember returns the guild member a user context-menu command was
+// used on, or nil outside guilds
+func (ctx *CommandContext) TargetMember() *discordgo.Member {
+	if ctx.Interaction == nil {
+		return nil
+	}
+	data := ctx.Interaction.ApplicationCommandData()
+	if data.Resolved == nil {
+		return nil
+	}
+	return data.Resolved.Members[data.TargetID]
+}
+
+// TargetMessage returns the message a message context-menu command was used on
+func (ctx *CommandContext) TargetMessage() *discordgo.Message {
+	if ctx.Interaction == nil {
+		return nil
+	}
+	data := ctx.Interaction.ApplicationCommandData()
+	if data.Resolved == nil {
+		return nil
+	}
+	return data.Resolved.Messages[data.TargetID]
+}
+
+// member returns the guild member who used the command. Interactions and
+// guild messages carry the member, so the state cache, which only holds a
+// fraction of the members of large guilds, isn't needed.
+func (ctx *CommandContext) member() (*discordgo.Member, error) {
+	if ctx.Interaction != nil && ctx.Interaction.Member != nil {
+		return ctx.Interaction.Member, nil
+	}
+	if ctx.Message != nil && ctx.Message.Member != nil {
+		// Message members leave out the user, who is the author
+		member := *ctx.Message.Member
+		member.User = ctx.Message.Author
+		return &member, nil
+	}
+
+	member, err := ctx.Session.GuildMember(ctx.GuildID, ctx.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting member: %w", err)
+	}
+	return member, nil
+}
+
+// Reply sends a text reply to the command
+func (ctx *CommandContext) Reply(content string) error {
+	return ctx.Respond(&CommandResponse{Content: content})
+}
+
+// ReplyEmbed sends an embed reply to the command
+func (ctx *CommandContext) ReplyEmbed(embed *discordgo.MessageEmbed) error {
+	return ctx.Respond(&CommandResponse{Embeds: []*discordgo.MessageEmbed{embed}})
+}
+
+// Respond sends a reply to the command. If the response was deferred, the
+// deferred response is filled in instead. Responding twice returns
+// ErrAlreadyResponded; use FollowUp to send further messages.
+func (ctx *CommandContext) Respond(resp *CommandResponse) error {
+	if ctx.Interaction == nil {
+		ctx.mu.Lock()
+		defer ctx.mu.Unlock()
+
+		if ctx.responded {
+			return ErrAlreadyResponded
+		}
+
+		msg, err := ctx.session().ChannelMessageSendComplex(ctx.ChannelID, &discordgo.MessageSend{
+			Content:    resp.Content,
+			Embeds:     resp.Embeds,
+			Components: resp.Components,
+		})
+		if err != nil {
+			return err
+		}
+		ctx.reply = msg
+		ctx.responded = true
+		ctx.components = resp.Components
+		return nil
+	}
+
+	var flags discordgo.MessageFlags
+	if resp.Ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	return ctx.respondInteraction(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    resp.Content,
+			Embeds:     resp.Embeds,
+			Components: resp.Components,
+			Flags:      flags,
+		},
+	})
+}
+
+// Defer acknowledges a slash command so a reply can be sent later. Prefix
+// commands need no acknowledgement, so this is a no-op for them, as it is
+// once a response has been sent.
+func (ctx *CommandContext) Defer(ephemeral bool) error {
+	if ctx.Interaction == nil {
+		return nil
+	}
+
+	var flags discordgo.MessageFlags
+	if ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	err := ctx.respondInteraction(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: flags},
+	})
+	if errors.Is(err, ErrAlreadyResponded) {
+		return nil
+	}
+	return err
+}
+
+// Edit replaces the content of the reply that was already sent
+func (ctx *CommandContext) Edit(resp *CommandResponse) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.Interaction == nil {
+		if ctx.reply == nil {
+			return ErrNoResponse
+		}
+
+		edit := discordgo.NewMessageEdit(ctx.reply.ChannelID, ctx.reply.ID).SetContent(resp.Content)
+		edit.Embeds = resp.Embeds
+		edit.Components = resp.Components
+
+		msg, err := ctx.session().ChannelMessageEditComplex(edit)
+		if err != nil {
+			return err
+		}
+		ctx.reply = msg
+		ctx.components = resp.Components
+		return nil
+	}
+
+	edit := &discordgo.WebhookEdit{Content: &resp.Content}
+	if resp.Embeds != nil {
+		edit.Embeds = &resp.Embeds
+	}
+	if resp.Components != nil {
+		edit.Components = &resp.Components
+	}
+	_, err := ctx.editInteractionLocked(edit)
+	return err
+}
+
+// replyEphemeral sends a short reply only the invoking user can see. Prefix
+// commands have no ephemeral messages, so they get a regular channel message.
+func (ctx *CommandContext) replyEphemeral(content string) error {
+	return ctx.Respond(&CommandResponse{Content: content, Ephemeral: true})
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CooldownScope determines who shares a cooldown
+type CooldownScope int
+
+// Cooldown scopes
+const (
+	CooldownScopeUser CooldownScope = iota
+	CooldownScopeChannel
+	CooldownScopeGuild
+)
+
+// Cooldown limits how often a command can be used
+type Cooldown struct {
+	Scope  CooldownScope
+	Period time.Duration
+	Burst  int // Uses allowed per period, defaults to 1
+}
+
+// key returns the rate limit bucket for an invocation
+func (c *Cooldown) key(ctx *CommandContext) string {
+	switch c.Scope {
+	case CooldownScopeChannel:
+		return fmt.Sprintf("channel:%s:%s", ctx.ChannelID, ctx.Name)
+	case CooldownScopeGuild:
+		// Outside guilds the channel is the closest thing to a guild
+		if ctx.GuildID == "" {
+			return fmt.Sprintf("channel:%s:%s", ctx.ChannelID, ctx.Name)
+		}
+		return fmt.Sprintf("guild:%s:%s", ctx.GuildID, ctx.Name)
+	default:
+		return fmt.Sprintf("user:%s:%s", ctx.UserID, ctx.Name)
+	}
+}
+
+// burst returns the number of uses allowed per period
+func (c *Cooldown) burst() int {
+	if c.Burst < 1 {
+		return 1
+	}
+	return c.Burst
+}
+
+// CooldownStore tracks command uses. Take records a use of key and returns
+// how long to wait before retrying, or zero if the use is allowed.
+type CooldownStore interface {
+	Take(key string, period time.Duration, burst int) (time.Duration, error)
+}
+
+// CooldownStoreFunc adapts a function to the CooldownStore interface
+type CooldownStoreFunc func(key string, period time.Duration, burst int) (time.Duration, error)
+
+// Take calls f
+func (f CooldownStoreFunc) Take(key string, period time.Duration, burst int) (time.Duration, error) {
+	return f(key, period, burst)
+}
+
+// cooldownWindow is a fixed rate limit window
+type cooldownWindow struct {
+	uses    int
+	resetAt time.Time
+}
+
+// MemoryCooldownStore keeps cooldowns in memory. They are lost on restart.
+type MemoryCooldownStore struct {
+	windows map[string]*cooldownWindow
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewMemoryCooldownStore creates an in-memory cooldown store
+func NewMemoryCooldownStore() *MemoryCooldownStore {
+	return &MemoryCooldownStore{
+		windows: make(map[string]*cooldownWindow),
+		now:     time.Now,
+	}
+}
+
+// Take records a use of key within a fixed window of period
+func (s *MemoryCooldownStore) Take(key string, period time.Duration, burst int) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	// Sweep expired windows so the map doesn't grow forever
+	if len(s.windows) > 1000 {
+		for k, w := range s.windows {
+			if !now.Before(w.resetAt) {
+				delete(s.windows, k)
+			}
+		}
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		s.windows[key] = &cooldownWindow{uses: 1, resetAt: now.Add(period)}
+		return 0, nil
+	}
+
+	if w.uses >= burst {
+		return w.resetAt.Sub(now), nil
+	}
+
+	w.uses++
+	return 0, nil
+}
+
+// CooldownMiddleware enforces the cooldown declared on each command. Commands
+// without one use a per-user cooldown of defaultPeriod; zero disables it.
+func CooldownMiddleware(store CooldownStore, defaultPeriod time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			cooldown := ctx.Cooldown
+			if cooldown == nil {
+				cooldown = &Cooldown{Scope: CooldownScopeUser, Period: defaultPeriod}
+			}
+			if cooldown.Period <= 0 {
+				return next(ctx)
+			}
+
+			retryAfter, err := store.Take(cooldown.key(ctx), cooldown.Period, cooldown.burst())
+			if err != nil {
+				// Fail open so a database outage doesn't disable every command
+				logrus.Errorf("Error checking cooldown: %v", err)
+				return next(ctx)
+			}
+
+			if retryAfter > 0 {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				return ctx.replyEphemeral(fmt.Sprintf("You're doing that too often. Try again in %ds.", seconds))
+			}
+
+			return next(ctx)
+		}
+	}
+}
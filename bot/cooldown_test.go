@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCooldownStoreBurst(t *testing.T) {
+	store := NewMemoryCooldownStore()
+	now := time.Unix(0, 0)
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := store.Take("key", 10*time.Second, 2); wait != 0 {
+			t.Fatalf("use %d should be allowed, got wait %s", i+1, wait)
+		}
+	}
+
+	now = now.Add(4 * time.Second)
+	if wait, _ := store.Take("key", 10*time.Second, 2); wait != 6*time.Second {
+		t.Fatalf("expected a 6s wait, got %s", wait)
+	}
+
+	now = now.Add(6 * time.Second)
+	if wait, _ := store.Take("key", 10*time.Second, 2); wait != 0 {
+		t.Fatalf("expected the window to reset, got wait %s", wait)
+	}
+}
+
+func TestCooldownMiddlewareScopes(t *testing.T) {
+	_, session := newTestBot()
+	runs := 0
+	handler := Chain(func(ctx *CommandContext) error {
+		runs++
+		return nil
+	}, CooldownMiddleware(NewMemoryCooldownStore(), 0))
+
+	invoke := func(userID string, cooldown *Cooldown) {
+		ctx := &CommandContext{
+			Session:     session,
+			Name:        "ping",
+			GuildID:     testGuildID,
+			ChannelID:   testChannelID,
+			UserID:      userID,
+			Cooldown:    cooldown,
+			Interaction: newTestInteraction("ping"),
+		}
+		if err := handler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	perUser := &Cooldown{Scope: CooldownScopeUser, Period: time.Minute}
+	invoke("1", perUser)
+	invoke("2", perUser)
+	invoke("1", perUser)
+	if runs != 2 {
+		t.Fatalf("expected each user to run once, got %d runs", runs)
+	}
+
+	resp := session.Responses()
+	if len(resp) != 1 || !strings.HasPrefix(resp[0].Data.Content, "You're doing that too often. Try again in") {
+		t.Fatalf("expected a cooldown reply, got %v", resp)
+	}
+
+	runs = 0
+	perGuild := &Cooldown{Scope: CooldownScopeGuild, Period: time.Minute}
+	invoke("1", perGuild)
+	invoke("2", perGuild)
+	if runs != 1 {
+		t.Fatalf("expected the guild to share a cooldown, got %d runs", runs)
+	}
+}
+
+func TestPrefixCooldownIgnoresCommandCase(t *testing.T) {
+	b, session := newTestBot()
+	runs := 0
+	b.Commands.RegisterCommand(Command{
+		Name:        "daily",
+		Description: "Claims a daily reward",
+		Cooldown:    &Cooldown{Scope: CooldownScopeUser, Period: 24 * time.Hour},
+		Handler: func(ctx *CommandContext) error {
+			runs++
+			return nil
+		},
+	})
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!daily"), "daily", nil)
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!Daily"), "Daily", nil)
+
+	if runs != 1 {
+		t.Fatalf("expected the cooldown to block !Daily, got %d runs", runs)
+	}
+	messages := sentMessages(session)
+	if len(messages) != 1 || !strings.HasPrefix(messages[0].Content, "You're doing that too often.") {
+		t.Errorf("expected a cooldown reply, got %v", messages)
+	}
+	if logged := b.CommandLog.(*memoryCommandLog).commands; len(logged) != 1 || logged[0] != "prefix daily" {
+		t.Errorf("expected the registered name to be logged, got %q", logged)
+	}
+}
@@ -8,11 +8,11 @@ import (
 )
 
 // onReady handles the ready event when the bot connects to Discord
-func (b *Bot) onReady(s *discordgo.Session, r *discordgo.Ready) {
+func (b *Bot) onReady(_ *discordgo.Session, r *discordgo.Ready) {
 	logrus.Infof("Bot is ready! Connected as %s#%s", r.User.Username, r.User.Discriminator)
 
 	// Set bot status
-	err := s.UpdateGameStatus(0, "Type /help for commands")
+	err := b.Session.UpdateGameStatus(0, "Type /help for commands")
 	if err != nil {
 		logrus.Errorf("Error setting bot status: %v", err)
 	}
@@ -28,10 +28,10 @@ func (b *Bot) onReady(s *discordgo.Session, r *discordgo.Ready) {
 	b.updateStats()
 }
 
-// onGuildCreate handles when the bot joins a new guild
-func (b *Bot) onGuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
-	logrus.Infof("Bot joined guild: %s (ID: %s)", g.Name, g.ID)
-
+// onGuildCreate handles a guild becoming available, either because the bot
+// joined it or because the gateway (re)connected or the guild recovered
+// from an outage
+func (b *Bot) onGuildCreate(_ *discordgo.Session, g *discordgo.GuildCreate) {
 	// Add guild to map
 	b.guildMutex.Lock()
 	b.Guilds[g.ID] = g.Guild
@@ -40,40 +40,25 @@ func (b *Bot) onGuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
 	// Update stats
 	b.updateStats()
 
-	// Send welcome message to the first text channel we can send to
-	for _, channel := range g.Channels {
-		if channel.Type == discordgo.ChannelTypeGuildText {
-			// Check if we have permission to send messages in this channel
-			perms, err := s.State.UserChannelPermissions(s.State.User.ID, channel.ID)
-			if err != nil {
-				logrus.Warnf("Error checking permissions: %v", err)
-				continue
-			}
-
-			if perms&discordgo.PermissionSendMessages != 0 {
-				// Create welcome embed
-				embed := &discordgo.MessageEmbed{
-					Title:       "Thanks for adding me!",
-					Description: "Use `/help` to see available commands.",
-					Color:       0x00AAFF,
-					Footer: &discordgo.MessageEmbedFooter{
-						Text: "Discord Bot Template",
-					},
-				}
-
-				_, err = s.ChannelMessageSendEmbed(channel.ID, embed)
-				if err != nil {
-					logrus.Warnf("Error sending welcome message: %v", err)
-				}
-
-				break
-			}
-		}
+	// Only thank guilds that just added the bot, not every guild on every reconnect
+	if !b.JoinedGuilds.Join(g.Guild) {
+		logrus.Debugf("Guild available: %s (ID: %s)", g.Name, g.ID)
+		return
 	}
+
+	logrus.Infof("Bot joined guild: %s (ID: %s)", g.Name, g.ID)
+	b.sendJoinWelcome(g.Guild)
 }
 
-// onGuildDelete handles when the bot leaves a guild
-func (b *Bot) onGuildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+// onGuildDelete handles when the bot leaves a guild or a guild becomes
+// unavailable
+func (b *Bot) onGuildDelete(_ *discordgo.Session, g *discordgo.GuildDelete) {
+	// An outage makes a guild unavailable without the bot leaving it
+	if g.Unavailable {
+		logrus.Warnf("Guild unavailable: %s", g.ID)
+		return
+	}
+
 	logrus.Infof("Bot left guild: %s (ID: %s)", g.Name, g.ID)
 
 	// Remove guild from map
@@ -81,31 +66,34 @@ func (b *Bot) onGuildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
 	delete(b.Guilds, g.ID)
 	b.guildMutex.Unlock()
 
+	// Rejoining later should be welcomed again
+	b.JoinedGuilds.Leave(g.ID)
+
+	// Drop cached settings
+	b.Prefixes.Forget(g.ID)
+	b.RoleBindings.Forget(g.ID)
+
 	// Update stats
 	b.updateStats()
 }
 
 // onMessageCreate handles when a message is created in a channel the bot has access to
-func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+func (b *Bot) onMessageCreate(_ *discordgo.Session, m *discordgo.MessageCreate) {
 	// Ignore messages from the bot itself
-	if m.Author.ID == s.State.User.ID {
+	if m.Author.ID == b.Session.BotUserID() {
 		return
 	}
 
-	// Check if message starts with the command prefix
-	if strings.HasPrefix(m.Content, b.Config.CommandPrefix) {
+	// Check if message starts with the guild's prefix or a mention of the bot
+	if cmdString, ok := b.matchPrefix(m.GuildID, m.Content); ok {
 		// Handle prefix command
-		cmdString := strings.TrimPrefix(m.Content, b.Config.CommandPrefix)
-		cmdName := strings.Fields(cmdString)[0]
-		cmdArgs := strings.Fields(cmdString)[1:]
-
-		b.Commands.HandlePrefixCommand(s, m, cmdName, cmdArgs)
+		b.Commands.HandlePrefixMessage(b.Session, m, cmdString)
 	}
 
 	// Handle message reactions (example)
 	if strings.Contains(strings.ToLower(m.Content), "hello bot") {
 		// React with a wave emoji
-		err := s.MessageReactionAdd(m.ChannelID, m.ID, "👋")
+		err := b.Session.MessageReactionAdd(m.ChannelID, m.ID, "👋")
 		if err != nil {
 			logrus.Warnf("Error adding reaction: %v", err)
 		}
@@ -113,100 +101,82 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 }
 
 // onInteractionCreate handles Discord interactions (slash commands, buttons, etc.)
-func (b *Bot) onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (b *Bot) onInteractionCreate(_ *discordgo.Session, i *discordgo.InteractionCreate) {
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
 		// Handle slash command
-		b.Commands.HandleSlashCommand(s, i)
+		b.Commands.HandleSlashCommand(b.Session, i)
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		// Suggest option values as the user types
+		b.Commands.HandleAutocomplete(b.Session, i)
 
 	case discordgo.InteractionMessageComponent:
 		// Handle button or select menu
-		data := i.MessageComponentData()
-		switch data.ComponentType {
-		case discordgo.ButtonComponent:
-			b.handleButtonInteraction(s, i, data)
-		case discordgo.SelectMenuComponent:
-			b.handleSelectMenuInteraction(s, i, data)
-		}
+		b.handleComponentInteraction(b.Session, i)
+
+	case discordgo.InteractionModalSubmit:
+		// Handle modal form submission
+		b.handleModalSubmit(b.Session, i)
 	}
 }
 
-// handleButtonInteraction handles button click interactions
-func (b *Bot) handleButtonInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
-	// Log the interaction
-	guildID := ""
-	if i.GuildID != "" {
-		guildID = i.GuildID
-	}
+// handleComponentInteraction logs a button or select menu interaction and
+// routes it to the handler registered for its custom ID
+func (b *Bot) handleComponentInteraction(s Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
 
+	interactionType := "button"
 	interactionData := map[string]interface{}{
 		"custom_id": data.CustomID,
 	}
+	if data.ComponentType != discordgo.ButtonComponent {
+		interactionType = "select_menu"
+		interactionData["values"] = data.Values
+	}
 
-	err := b.Repository.LogInteraction(guildID, i.ChannelID, i.Member.User.ID, "button", data.CustomID, interactionData)
+	err := b.Repository.LogInteraction(i.GuildID, i.ChannelID, interactionUserID(i.Interaction), interactionType, data.CustomID, interactionData)
 	if err != nil {
-		logrus.Errorf("Error logging button interaction: %v", err)
+		logrus.Errorf("Error logging %s interaction: %v", interactionType, err)
 	}
 
-	// Handle different button IDs
-	switch data.CustomID {
-	case "example_button":
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "You clicked the example button!",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-
-	default:
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "Unknown button interaction.",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
+	if b.Components.Dispatch(s, i) {
+		return
 	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Unknown component interaction.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
 }
 
-// handleSelectMenuInteraction handles select menu interactions
-func (b *Bot) handleSelectMenuInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
-	// Log the interaction
-	guildID := ""
-	if i.GuildID != "" {
-		guildID = i.GuildID
-	}
+// handleModalSubmit logs a modal submission and routes it to the handler
+// registered for its custom ID
+func (b *Bot) handleModalSubmit(s Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
 
 	interactionData := map[string]interface{}{
 		"custom_id": data.CustomID,
-		"values":    data.Values,
+		"values":    modalValues(data.Components),
 	}
 
-	err := b.Repository.LogInteraction(guildID, i.ChannelID, i.Member.User.ID, "select_menu", data.CustomID, interactionData)
+	err := b.Repository.LogInteraction(i.GuildID, i.ChannelID, interactionUserID(i.Interaction), "modal", data.CustomID, interactionData)
 	if err != nil {
-		logrus.Errorf("Error logging select menu interaction: %v", err)
+		logrus.Errorf("Error logging modal submission: %v", err)
 	}
 
-	// Handle different select menu IDs
-	switch data.CustomID {
-	case "example_select":
-		response := "You selected: " + strings.Join(data.Values, ", ")
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: response,
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-
-	default:
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "Unknown select menu interaction.",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
+	if b.Components.DispatchModal(s, i) {
+		return
 	}
-}
\ No newline at end of file
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Unknown modal submission.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler executes a command invocation
+type Handler func(ctx *CommandContext) error
+
+// Middleware wraps a Handler with additional behavior
+type Middleware func(next Handler) Handler
+
+// Chain wraps a handler with middlewares. The first middleware is the outermost.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// defaultMiddlewares returns the middleware chain every command goes through
+func (h *CommandHandler) defaultMiddlewares() []Middleware {
+	return []Middleware{
+		ErrorMiddleware(h.Bot.ErrorLog),
+		RecoveryMiddleware(),
+		TimingMiddleware(),
+		AvailabilityMiddleware(),
+		PermissionMiddleware(),
+		BotPermissionMiddleware(),
+		CooldownMiddleware(h.Bot.Cooldowns, h.Bot.Config.CommandCooldown),
+		LoggingMiddleware(h.Bot.CommandLog),
+	}
+}
+
+// TimingMiddleware logs how long each command took to run
+func TimingMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			start := time.Now()
+			err := next(ctx)
+			logrus.Debugf("%s command '%s' took %s", ctx.Type, ctx.Name, time.Since(start).Round(time.Microsecond))
+			return err
+		}
+	}
+}
+
+// PermissionMiddleware rejects guild invocations from users lacking any of
+// the command's required permissions
+func PermissionMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			if ctx.GuildID == "" || ctx.Permissions == 0 {
+				return next(ctx)
+			}
+
+			perms, err := ctx.memberPermissions()
+			if err != nil {
+				logrus.Errorf("Error checking permissions: %v", err)
+				return ctx.replyEphemeral("An error occurred while checking permissions.")
+			}
+
+			// Check if user has required permissions
+			if perms&ctx.Permissions != ctx.Permissions {
+				return ctx.replyEphemeral("You don't have permission to use this command.")
+			}
+
+			return next(ctx)
+		}
+	}
+}
+
+// memberPermissions returns the invoking member's permissions in the
+// channel. Guild interactions come with them, which matters in large guilds
+// where most members are missing from the state cache; otherwise they are
+// worked out from the cache.
+func (ctx *CommandContext) memberPermissions() (int64, error) {
+	if ctx.Interaction != nil && ctx.Interaction.Member != nil && ctx.Interaction.Member.Permissions != 0 {
+		return ctx.Interaction.Member.Permissions, nil
+	}
+	return ctx.Session.UserChannelPermissions(ctx.UserID, ctx.ChannelID)
+}
+
+// CommandRecorder persists command invocations
+type CommandRecorder interface {
+	LogCommand(guildID, channelID, userID, commandName, commandType string, arguments map[string]interface{}) error
+}
+
+// LoggingMiddleware records each command invocation
+func LoggingMiddleware(log CommandRecorder) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			err := log.LogCommand(ctx.GuildID, ctx.ChannelID, ctx.UserID, ctx.Name, ctx.Type, ctx.Arguments)
+			if err != nil {
+				logrus.Errorf("Error logging command: %v", err)
+			}
+
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestChainRunsMiddlewaresInOrder(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx *CommandContext) error {
+				order = append(order, name+":before")
+				err := next(ctx)
+				order = append(order, name+":after")
+				return err
+			}
+		}
+	}
+
+	handler := Chain(func(ctx *CommandContext) error {
+		order = append(order, "handler")
+		return nil
+	}, record("outer"), record("inner"))
+
+	if err := handler(&CommandContext{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"outer:before", "inner:before", "handler", "inner:after", "outer:after"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+}
+
+func TestRecoveryMiddlewareReturnsPanicAsError(t *testing.T) {
+	handler := Chain(func(ctx *CommandContext) error {
+		panic("boom")
+	}, RecoveryMiddleware())
+
+	if err := handler(&CommandContext{Name: "test"}); err == nil {
+		t.Fatal("expected the panic to be returned as an error")
+	}
+}
+
+func TestPermissionMiddlewareRejectsMissingPermissions(t *testing.T) {
+	_, session := newTestBot()
+	addTestGuild(t, session, 0)
+
+	called := false
+	handler := Chain(func(ctx *CommandContext) error {
+		called = true
+		return nil
+	}, PermissionMiddleware())
+
+	ctx := &CommandContext{
+		Session:     session,
+		Name:        "role",
+		GuildID:     testGuildID,
+		ChannelID:   testChannelID,
+		UserID:      testUserID,
+		Permissions: discordgo.PermissionManageRoles,
+		Interaction: newTestInteraction("role"),
+	}
+	if err := handler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if called {
+		t.Error("handler ran without the required permissions")
+	}
+	if resp := session.Responses(); len(resp) != 1 || resp[0].Data.Content != "You don't have permission to use this command." {
+		t.Errorf("unexpected responses: %v", resp)
+	}
+}
+
+func TestPermissionMiddlewareRequiresAllPermissions(t *testing.T) {
+	_, session := newTestBot()
+	addTestGuild(t, session, 0)
+
+	called := false
+	handler := Chain(func(ctx *CommandContext) error {
+		called = true
+		return nil
+	}, PermissionMiddleware())
+
+	// The test user has Send Messages from @everyone, but not Manage Roles
+	ctx := &CommandContext{
+		Session:     session,
+		Name:        "role",
+		GuildID:     testGuildID,
+		ChannelID:   testChannelID,
+		UserID:      testUserID,
+		Permissions: discordgo.PermissionSendMessages | discordgo.PermissionManageRoles,
+		Interaction: newTestInteraction("role"),
+	}
+	if err := handler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if called {
+		t.Error("handler ran with only some of the required permissions")
+	}
+}
+
+func TestBotPermissionMiddleware(t *testing.T) {
+	_, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+
+	called := false
+	handler := Chain(func(ctx *CommandContext) error {
+		called = true
+		return nil
+	}, BotPermissionMiddleware())
+
+	ctx := &CommandContext{
+		Session:        session,
+		Name:           "role",
+		GuildID:        testGuildID,
+		ChannelID:      testChannelID,
+		UserID:         testUserID,
+		BotPermissions: discordgo.PermissionManageRoles | discordgo.PermissionManageNicknames,
+		Interaction:    newTestInteraction("role"),
+	}
+	if err := handler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if called {
+		t.Error("handler ran without the bot's required permissions")
+	}
+	if resp := session.Responses(); len(resp) != 1 || resp[0].Data.Content != "I need the following permissions to do that: Manage Nicknames." {
+		t.Errorf("unexpected responses: %v", resp)
+	}
+}
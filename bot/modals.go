@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CommandTypeModal is recorded for failed modal handlers
+const CommandTypeModal = "modal"
+
+// maxModalInputs is the most text inputs Discord allows in a modal
+const maxModalInputs = 5
+
+// Errors returned by ShowModal
+var (
+	ErrModalUnsupported = errors.New("modals can only be shown in response to an interaction")
+	ErrModalNotFirst    = errors.New("a modal must be the first response to an interaction")
+)
+
+// ModalBuilder builds a modal with text inputs
+type ModalBuilder struct {
+	CustomID string
+	Title    string
+	Inputs   []discordgo.TextInput
+}
+
+// NewModal starts a modal with the given custom ID and title
+func NewModal(customID, title string) *ModalBuilder {
+	return &ModalBuilder{CustomID: customID, Title: title}
+}
+
+// Short adds a required single-line text input
+func (m *ModalBuilder) Short(customID, label string) *ModalBuilder {
+	return m.Input(discordgo.TextInput{
+		CustomID: customID,
+		Label:    label,
+		Style:    discordgo.TextInputShort,
+		Required: true,
+	})
+}
+
+// Paragraph adds a required multi-line text input
+func (m *ModalBuilder) Paragraph(customID, label string) *ModalBuilder {
+	return m.Input(discordgo.TextInput{
+		CustomID: customID,
+		Label:    label,
+		Style:    discordgo.TextInputParagraph,
+		Required: true,
+	})
+}
+
+// Input adds a text input with full control over its settings
+func (m *ModalBuilder) Input(input discordgo.TextInput) *ModalBuilder {
+	m.Inputs = append(m.Inputs, input)
+	return m
+}
+
+// Build returns the interaction response data for the modal
+func (m *ModalBuilder) Build() (*discordgo.InteractionResponseData, error) {
+	if len(m.Inputs) == 0 || len(m.Inputs) > maxModalInputs {
+		return nil, fmt.Errorf("modal %s must have between 1 and %d inputs, has %d", m.CustomID, maxModalInputs, len(m.Inputs))
+	}
+
+	// Each text input goes in its own row
+	rows := make([]discordgo.MessageComponent, len(m.Inputs))
+	for i, input := range m.Inputs {
+		rows[i] = discordgo.ActionsRow{Components: []discordgo.MessageComponent{input}}
+	}
+
+	return &discordgo.InteractionResponseData{
+		CustomID:   m.CustomID,
+		Title:      m.Title,
+		Components: rows,
+	}, nil
+}
+
+// ShowModal responds to a slash command or component interaction with a
+// modal. It must be the first response to the interaction.
+func (ctx *CommandContext) ShowModal(modal *ModalBuilder) error {
+	if ctx.Interaction == nil {
+		return ErrModalUnsupported
+	}
+	if deferred, responded := ctx.responseState(); deferred || responded {
+		return ErrModalNotFirst
+	}
+
+	data, err := modal.Build()
+	if err != nil {
+		return err
+	}
+
+	return ctx.respondInteraction(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: data,
+	})
+}
+
+// ModalHandler handles a modal submission
+type ModalHandler func(ctx *ModalContext) error
+
+// ModalContext carries a single modal submission to its handler
+type ModalContext struct {
+	*CommandContext
+	Data   discordgo.ModalSubmitInteractionData
+	Params map[string]string // Values captured from the custom_id pattern
+
+	values map[string]string
+}
+
+// Param returns a value captured from the custom_id pattern
+func (ctx *ModalContext) Param(name string) string {
+	return ctx.Params[name]
+}
+
+// Value returns the text entered in an input, or an empty string if it was left blank
+func (ctx *ModalContext) Value(customID string) string {
+	return ctx.values[customID]
+}
+
+// Values returns the text entered in every input, keyed by custom ID
+func (ctx *ModalContext) Values() map[string]string {
+	return ctx.values
+}
+
+// IntValue parses the text entered in an input as an integer, returning def
+// if it was left blank
+func (ctx *ModalContext) IntValue(customID string, def int64) (int64, error) {
+	value := strings.TrimSpace(ctx.values[customID])
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a whole number", customID)
+	}
+	return n, nil
+}
+
+// modalValues collects the submitted text input values by custom ID
+func modalValues(components []discordgo.MessageComponent) map[string]string {
+	values := make(map[string]string)
+	for _, component := range components {
+		switch c := component.(type) {
+		case *discordgo.ActionsRow:
+			for k, v := range modalValues(c.Components) {
+				values[k] = v
+			}
+		case discordgo.ActionsRow:
+			for k, v := range modalValues(c.Components) {
+				values[k] = v
+			}
+		case *discordgo.TextInput:
+			values[c.CustomID] = c.Value
+		case discordgo.TextInput:
+			values[c.CustomID] = c.Value
+		}
+	}
+	return values
+}
+
+// DispatchModal routes a modal submission to its handler. It reports
+// whether a handler was found.
+func (r *ComponentRouter) DispatchModal(s Session, i *discordgo.InteractionCreate) bool {
+	data := i.ModalSubmitData()
+
+	r.mu.RLock()
+	var route *modalRoute
+	var params map[string]string
+	for _, candidate := range r.modals {
+		if p, ok := candidate.match(data.CustomID); ok {
+			route, params = candidate, p
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if route == nil {
+		return false
+	}
+
+	ctx := &ModalContext{
+		CommandContext: &CommandContext{
+			Session:     s,
+			Name:        route.pattern,
+			Type:        CommandTypeModal,
+			GuildID:     i.GuildID,
+			ChannelID:   i.ChannelID,
+			UserID:      interactionUserID(i.Interaction),
+			Interaction: i,
+		},
+		Data:   data,
+		Params: params,
+		values: modalValues(data.Components),
+	}
+	ctx.trackResponses()
+
+	r.run(ctx.CommandContext, data.CustomID, func() error {
+		return route.handler(ctx)
+	})
+	return true
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newTestModalSubmit builds a modal submission with the given input values
+func newTestModalSubmit(customID string, values map[string]string) *discordgo.InteractionCreate {
+	var rows []discordgo.MessageComponent
+	for id, value := range values {
+		rows = append(rows, &discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: id, Value: value}},
+		})
+	}
+
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:        "1400000000000000000",
+			Type:      discordgo.InteractionModalSubmit,
+			GuildID:   testGuildID,
+			ChannelID: testChannelID,
+			Member:    &discordgo.Member{User: &discordgo.User{ID: testUserID}},
+			Data: discordgo.ModalSubmitInteractionData{
+				CustomID:   customID,
+				Components: rows,
+			},
+		},
+	}
+}
+
+func TestFeedbackCommandShowsModal(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("feedback"))
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseModal {
+		t.Fatalf("expected a modal response, got %+v", responses)
+	}
+	if data := responses[0].Data; data.CustomID != "feedback" || len(data.Components) != 2 {
+		t.Errorf("unexpected modal %+v", data)
+	}
+}
+
+func TestShowModalMustBeFirstResponse(t *testing.T) {
+	_, session := newTestBot()
+	ctx := &CommandContext{Session: session, Interaction: newTestInteraction("feedback")}
+
+	if err := ctx.Reply("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.ShowModal(NewModal("x", "X").Short("a", "A")); err != ErrModalNotFirst {
+		t.Errorf("expected ErrModalNotFirst, got %v", err)
+	}
+}
+
+func TestModalBuilderLimitsInputs(t *testing.T) {
+	modal := NewModal("x", "X")
+	if _, err := modal.Build(); err == nil {
+		t.Error("expected an error for a modal without inputs")
+	}
+
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		modal.Short(id, id)
+	}
+	if _, err := modal.Build(); err == nil {
+		t.Error("expected an error for a modal with too many inputs")
+	}
+}
+
+func TestModalSubmissionRouting(t *testing.T) {
+	b, session := newTestBot()
+
+	var ticketID, title string
+	var priority int64
+	b.Components.HandleModal("ticket:{ticketID}", func(ctx *ModalContext) error {
+		ticketID, title = ctx.Param("ticketID"), ctx.Value("title")
+
+		var err error
+		priority, err = ctx.IntValue("priority", 1)
+		if err != nil {
+			return err
+		}
+		return ctx.Reply("Saved")
+	})
+
+	handled := b.Components.DispatchModal(session, newTestModalSubmit("ticket:7", map[string]string{
+		"title":    "Broken link",
+		"priority": " 3 ",
+	}))
+	if !handled {
+		t.Fatal("expected the modal to be routed")
+	}
+	if ticketID != "7" || title != "Broken link" || priority != 3 {
+		t.Errorf("unexpected values %q %q %d", ticketID, title, priority)
+	}
+
+	if b.Components.DispatchModal(session, newTestModalSubmit("unknown", nil)) {
+		t.Error("expected an unknown modal not to be routed")
+	}
+}
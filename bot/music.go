@@ -0,0 +1,299 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// voicePermissions are the permissions the bot needs in a voice channel to play audio
+const voicePermissions = discordgo.PermissionVoiceConnect | discordgo.PermissionVoiceSpeak
+
+// queuePageSize is how many upcoming tracks /queue view lists
+const queuePageSize = 10
+
+// minQueuePosition is the lowest position /queue remove accepts
+var minQueuePosition = 1.0
+
+// minVolume is the lowest volume /volume accepts
+var minVolume = 0.0
+
+// loopModeChoices are the choices of the /loop mode option
+var loopModeChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Off", Value: string(LoopOff)},
+	{Name: "Current track", Value: string(LoopTrack)},
+	{Name: "Whole queue", Value: string(LoopQueue)},
+}
+
+// userVoiceChannel returns the voice channel a user is connected to in a
+// guild, or an empty string if they aren't in one
+func userVoiceChannel(s Session, guildID, userID string) (string, error) {
+	guild, err := s.StateGuild(guildID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID == userID {
+			return vs.ChannelID, nil
+		}
+	}
+	return "", nil
+}
+
+// playCommand joins the user's voice channel and queues the requested track
+func (h *CommandHandler) playCommand(ctx *CommandContext) error {
+	location := ctx.StringOption("track")
+
+	channelID, err := userVoiceChannel(ctx.Session, ctx.GuildID, ctx.UserID)
+	if err != nil {
+		return fmt.Errorf("error finding voice channel: %w", err)
+	}
+	if channelID == "" {
+		return ctx.replyEphemeral("You must be in a voice channel to use this command.")
+	}
+
+	perms, err := ctx.Session.UserChannelPermissions(ctx.Session.BotUserID(), channelID)
+	if err != nil {
+		return fmt.Errorf("error checking voice permissions: %w", err)
+	}
+	if missing := voicePermissions &^ perms; missing != 0 {
+		return ctx.replyEphemeral(fmt.Sprintf("I need the following permissions in <#%s> to do that: %s.", channelID, describePermissions(missing)))
+	}
+
+	// Moving the bot would cut off the listeners in its current channel
+	if vc, ok := h.Bot.Voice.Connection(ctx.GuildID); ok && vc.ChannelID != channelID && vc.IsPlaying() {
+		return ctx.replyEphemeral(fmt.Sprintf("I'm already playing in <#%s>.", vc.ChannelID))
+	}
+
+	// Joining a channel can take a few seconds
+	if err := ctx.Defer(false); err != nil {
+		return err
+	}
+
+	if _, err := h.Bot.Voice.JoinVoiceChannel(ctx.GuildID, channelID, ctx.ChannelID); err != nil {
+		logrus.Warnf("Error joining voice channel %s: %v", channelID, err)
+		return ctx.Reply(fmt.Sprintf("I couldn't join <#%s>.", channelID))
+	}
+
+	position := h.Bot.Voice.Enqueue(ctx.GuildID, Track{
+		Location:      location,
+		RequestedBy:   ctx.UserID,
+		TextChannelID: ctx.ChannelID,
+	})
+	if position == 0 {
+		return ctx.Reply(fmt.Sprintf("Playing `%s` in <#%s>.", location, channelID))
+	}
+	return ctx.Reply(fmt.Sprintf("Queued `%s` at position %d.", location, position))
+}
+
+// controlPlayback returns the guild's voice connection if the user may
+// control it, which needs them to be listening. Otherwise it returns the
+// reason they can't.
+func (h *CommandHandler) controlPlayback(ctx *CommandContext) (*VoiceConnection, string) {
+	vc, ok := h.Bot.Voice.Connection(ctx.GuildID)
+	if !ok {
+		return nil, "Nothing is playing."
+	}
+
+	channelID, err := userVoiceChannel(ctx.Session, ctx.GuildID, ctx.UserID)
+	if err != nil {
+		logrus.Warnf("Error finding voice channel: %v", err)
+	}
+	if channelID != vc.ChannelID {
+		return nil, fmt.Sprintf("You must be in <#%s> to control playback.", vc.ChannelID)
+	}
+	return vc, ""
+}
+
+// skipCommand skips the current track
+func (h *CommandHandler) skipCommand(ctx *CommandContext) error {
+	if _, reason := h.controlPlayback(ctx); reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	track, ok := h.Bot.Voice.Skip(ctx.GuildID)
+	if !ok {
+		return ctx.replyEphemeral("Nothing is playing.")
+	}
+	return ctx.Reply(fmt.Sprintf("Skipped `%s`.", track.Location))
+}
+
+// pauseCommand pauses playback
+func (h *CommandHandler) pauseCommand(ctx *CommandContext) error {
+	vc, reason := h.controlPlayback(ctx)
+	if reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	if !vc.Pause() {
+		return ctx.replyEphemeral("Nothing is playing, or playback is already paused.")
+	}
+	return ctx.Reply("Paused playback. Use `/resume` to continue.")
+}
+
+// resumeCommand resumes paused playback
+func (h *CommandHandler) resumeCommand(ctx *CommandContext) error {
+	vc, reason := h.controlPlayback(ctx)
+	if reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	if !vc.Resume() {
+		return ctx.replyEphemeral("Playback isn't paused.")
+	}
+	return ctx.Reply("Resumed playback.")
+}
+
+// loopCommand changes the loop mode of the queue
+func (h *CommandHandler) loopCommand(ctx *CommandContext) error {
+	if _, reason := h.controlPlayback(ctx); reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	mode := LoopMode(ctx.StringOption("mode"))
+	switch mode {
+	case LoopOff, LoopTrack, LoopQueue:
+	default:
+		return ctx.replyEphemeral("Loop mode must be off, track or queue.")
+	}
+
+	h.Bot.Voice.Queue(ctx.GuildID).SetLoop(mode)
+	return ctx.Reply(describeLoop(mode))
+}
+
+// volumeCommand shows the playback volume, or changes it for listeners
+func (h *CommandHandler) volumeCommand(ctx *CommandContext) error {
+	q := h.Bot.Voice.Queue(ctx.GuildID)
+	if ctx.Option("percent") == nil {
+		return ctx.replyEphemeral(fmt.Sprintf("The volume is %d%%.", q.Volume()))
+	}
+
+	if _, reason := h.controlPlayback(ctx); reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	volume := int(ctx.IntOption("percent", DefaultVolume))
+	q.SetVolume(volume)
+	return ctx.Reply(fmt.Sprintf("Volume set to %d%%.", volume))
+}
+
+// describeLoop describes a loop mode in a sentence
+func describeLoop(mode LoopMode) string {
+	switch mode {
+	case LoopTrack:
+		return "Looping the current track."
+	case LoopQueue:
+		return "Looping the whole queue."
+	default:
+		return "Looping is off."
+	}
+}
+
+// queueSlashCommand handles the queue slash command
+func (h *CommandHandler) queueSlashCommand(ctx *CommandContext) error {
+	options := ctx.Interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return ctx.replyEphemeral("Invalid command usage.")
+	}
+	ctx.Options = options[0].Options
+	q := h.Bot.Voice.Queue(ctx.GuildID)
+
+	if options[0].Name == "view" {
+		return ctx.ReplyEmbed(queueEmbed(q))
+	}
+
+	// Changing the queue is limited to listeners
+	if _, reason := h.controlPlayback(ctx); reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	switch options[0].Name {
+	case "shuffle":
+		q.Shuffle()
+		return ctx.Reply(fmt.Sprintf("Shuffled %d tracks.", len(q.Tracks())))
+
+	case "remove":
+		track, ok := q.Remove(int(ctx.IntOption("position", 0)))
+		if !ok {
+			return ctx.replyEphemeral("There is no track at that position. Check `/queue view`.")
+		}
+		return ctx.Reply(fmt.Sprintf("Removed `%s` from the queue.", track.Location))
+
+	case "clear":
+		return ctx.Reply(fmt.Sprintf("Removed %d tracks from the queue.", q.Clear()))
+
+	default:
+		return ctx.replyEphemeral("Unknown subcommand.")
+	}
+}
+
+// queueEmbed lists the current and upcoming tracks of a queue
+func queueEmbed(q *MusicQueue) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: "Queue",
+		Color: 0x00AAFF,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Loop: " + string(q.Loop()),
+		},
+	}
+
+	current, ok := q.Current()
+	if !ok {
+		embed.Description = "Nothing is playing. Add a track with `/play`."
+		return embed
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Now playing:** `%s` (<@%s>)\n", current.Location, current.RequestedBy)
+
+	tracks := q.Tracks()
+	for i, track := range tracks {
+		if i == queuePageSize {
+			fmt.Fprintf(&sb, "\n...and %d more", len(tracks)-queuePageSize)
+			break
+		}
+		fmt.Fprintf(&sb, "\n%d. `%s` (<@%s>)", i+1, track.Location, track.RequestedBy)
+	}
+	embed.Description = sb.String()
+	return embed
+}
+
+// musicButton handles the playback controls on a "now playing" message
+func (h *CommandHandler) musicButton(ctx *ComponentContext) error {
+	vc, reason := h.controlPlayback(ctx.CommandContext)
+	if reason != "" {
+		return ctx.replyEphemeral(reason)
+	}
+
+	q := h.Bot.Voice.Queue(ctx.GuildID)
+	switch ctx.Param("action") {
+	case "pause":
+		vc.Pause()
+	case "resume":
+		vc.Resume()
+	case "skip":
+		if _, ok := h.Bot.Voice.Skip(ctx.GuildID); !ok {
+			return ctx.replyEphemeral("Nothing is playing.")
+		}
+		// The next track gets its own message
+		return ctx.Update(&CommandResponse{Embeds: ctx.Interaction.Message.Embeds, Components: []discordgo.MessageComponent{}})
+	case "loop":
+		q.SetLoop(nextLoopMode(q.Loop()))
+	case "shuffle":
+		q.Shuffle()
+	default:
+		return ctx.replyEphemeral("Unknown playback control.")
+	}
+
+	current, ok := q.Current()
+	if !ok {
+		return ctx.replyEphemeral("Nothing is playing.")
+	}
+	return ctx.Update(&CommandResponse{
+		Embeds:     []*discordgo.MessageEmbed{nowPlayingEmbed(current, q.Loop(), len(q.Tracks()))},
+		Components: nowPlayingButtons(vc.IsPaused(), q.Loop()),
+	})
+}
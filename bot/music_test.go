@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const testVoiceChannelID = "310"
+
+// addTestVoiceChannel adds a voice channel to the test guild and puts the
+// test user in it
+func addTestVoiceChannel(t *testing.T, session *fakeSession) {
+	t.Helper()
+
+	err := session.State.ChannelAdd(&discordgo.Channel{ID: testVoiceChannelID, GuildID: testGuildID, Name: "music", Type: discordgo.ChannelTypeGuildVoice})
+	if err != nil {
+		t.Fatalf("adding voice channel to state: %v", err)
+	}
+
+	guild, _ := session.State.Guild(testGuildID)
+	guild.VoiceStates = append(guild.VoiceStates, &discordgo.VoiceState{GuildID: testGuildID, ChannelID: testVoiceChannelID, UserID: testUserID})
+}
+
+// playOptions builds the options of a /play invocation
+func playOptions(track string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: "track", Type: discordgo.ApplicationCommandOptionString, Value: track}
+}
+
+// useTestAudio serves tracks from memory instead of files or the network
+func useTestAudio(b *Bot, tracks map[string]string) {
+	b.Voice.Source = AudioSourceFunc(func(_ context.Context, location string) (io.ReadCloser, error) {
+		data, ok := tracks[location]
+		if !ok {
+			return nil, ErrUnsupportedLocation
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	})
+}
+
+// editedContent returns the content of every deferred response that was filled in
+func editedContent(session *fakeSession) []string {
+	var contents []string
+	for _, call := range session.Calls("InteractionResponseEdit") {
+		if edit := call.Args[1].(*discordgo.WebhookEdit); edit.Content != nil {
+			contents = append(contents, *edit.Content)
+		}
+	}
+	return contents
+}
+
+func TestPlayCommandStreamsTrack(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	useTestAudio(b, map[string]string{"song.dca": dcaFrames("first", "second")})
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("play", playOptions("song.dca")))
+
+	joins := session.Calls("ChannelVoiceJoin")
+	if len(joins) != 1 || joins[0].Args[1] != testVoiceChannelID {
+		t.Fatalf("expected the bot to join the user's channel, got %v", joins)
+	}
+
+	conn := session.VoiceConn(testGuildID)
+	waitFor(t, func() bool { return conn.Spoke() && len(conn.Packets()) == 2 })
+	if packets := conn.Packets(); string(packets[0]) != "first" || string(packets[1]) != "second" {
+		t.Errorf("unexpected packets: %q", packets)
+	}
+
+	if contents := editedContent(session); len(contents) != 1 || contents[0] != "Playing `song.dca` in <#310>." {
+		t.Errorf("unexpected response: %q", contents)
+	}
+
+	// The "now playing" message loses its controls once the queue ends
+	waitFor(t, func() bool { return len(session.Calls("ChannelMessageEditComplex")) == 1 })
+	messages := sentMessages(session)
+	if len(messages) != 1 || messages[0].Embeds[0].Title != "Now Playing" || len(messages[0].Components) != 1 {
+		t.Fatalf("expected a now playing message with controls, got %v", messages)
+	}
+	edit := session.Calls("ChannelMessageEditComplex")[0].Args[0].(*discordgo.MessageEdit)
+	if len(edit.Components) != 0 || len(edit.Embeds) != 1 {
+		t.Errorf("expected the controls to be removed, got %+v", edit)
+	}
+}
+
+func TestPlayCommandRequiresVoiceChannel(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!play song.dca"), "play", []string{"song.dca"})
+
+	if joins := session.Calls("ChannelVoiceJoin"); len(joins) != 0 {
+		t.Errorf("expected no voice joins, got %v", joins)
+	}
+	if messages := sentMessages(session); len(messages) != 1 || messages[0].Content != "You must be in a voice channel to use this command." {
+		t.Errorf("unexpected reply: %v", messages)
+	}
+}
+
+func TestPlayCommandReportsUnknownTrack(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	useTestAudio(b, nil)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("play", playOptions("missing.dca")))
+
+	waitFor(t, func() bool { return len(session.Calls("ChannelMessageSend")) == 1 })
+	if sent := session.Calls("ChannelMessageSend")[0]; sent.Args[1] != "I couldn't open `missing.dca`: unsupported audio location." {
+		t.Errorf("unexpected notice: %v", sent.Args[1])
+	}
+}
+
+func TestPlayCommandRequiresVoicePermissions(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionVoiceConnect)
+	addTestVoiceChannel(t, session)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("play", playOptions("song.dca")))
+
+	if got := session.Responses()[0].Data.Content; got != "I need the following permissions in <#310> to do that: Speak." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
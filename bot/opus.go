@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OpusFrameReader yields audio as discrete Opus packets, one per 20ms frame,
+// which is what Discord expects on a voice connection. Every frame is a new
+// slice the reader doesn't touch again, so it can be sent while the next
+// one is read.
+type OpusFrameReader interface {
+	// ReadFrame returns the next Opus packet, or io.EOF after the last one
+	ReadFrame() ([]byte, error)
+}
+
+// ErrInvalidAudio is returned for audio streams that can't be parsed
+var ErrInvalidAudio = errors.New("invalid audio stream")
+
+// maxOpusPacketSize is the largest Opus packet accepted from a stream: three
+// frames of the largest size RFC 6716 allows, covering up to 60ms of audio
+const maxOpusPacketSize = 3 * 1275
+
+// NewOpusFrameReader reads Opus frames from a stream, detecting whether it
+// is Ogg/Opus or DCA1. Detection happens on the first read, so creating the
+// reader never blocks. Raw DCA (DCA0) has no header to detect, so it must
+// be read with NewDCAReader.
+func NewOpusFrameReader(r io.Reader) OpusFrameReader {
+	return &sniffingFrameReader{r: bufio.NewReader(r)}
+}
+
+// PCMEncoderFunc turns a stream of 48kHz stereo PCM into Opus frames
+type PCMEncoderFunc func(pcm io.Reader) (OpusFrameReader, error)
+
+// sniffingFrameReader picks the container parser on the first read. With
+// an encoder it also plays WAV files and, if raw is set, raw PCM.
+type sniffingFrameReader struct {
+	r      *bufio.Reader
+	encode PCMEncoderFunc // Encodes WAV audio, if set
+	raw    bool           // The stream is raw PCM, to be encoded without detection
+	dca    bool           // The stream is a DCA file, so it may be headerless DCA0
+	frames OpusFrameReader
+}
+
+// ReadFrame returns the next frame of the detected container
+func (s *sniffingFrameReader) ReadFrame() ([]byte, error) {
+	if s.frames == nil && s.raw {
+		frames, err := s.encode(s.r)
+		if err != nil {
+			return nil, err
+		}
+		s.frames = frames
+	}
+	if s.frames == nil {
+		magic, err := s.r.Peek(4)
+		if err != nil && len(magic) == 0 {
+			return nil, err
+		}
+
+		switch {
+		case string(magic) == "OggS":
+			s.frames = NewOggOpusReader(s.r)
+		case string(magic) == "DCA1" || s.dca:
+			s.frames = NewDCAReader(s.r)
+		case string(magic) == "RIFF" && s.encode != nil:
+			pcm, err := readWAVHeader(s.r)
+			if err != nil {
+				return nil, err
+			}
+			if s.frames, err = s.encode(pcm); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: unsupported audio format", ErrInvalidAudio)
+		}
+	}
+	return s.frames.ReadFrame()
+}
+
+// FadeOut fades out the detected audio, if it can be faded
+func (s *sniffingFrameReader) FadeOut() bool {
+	if f, ok := s.frames.(fader); ok {
+		return f.FadeOut()
+	}
+	return false
+}
+
+// Close releases the detected parser or encoder, if it needs to be
+func (s *sniffingFrameReader) Close() error {
+	if c, ok := s.frames.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// dcaReader reads DCA files: an optional DCA1 header followed by frames,
+// each prefixed with its length as a little-endian int16
+type dcaReader struct {
+	r      io.Reader
+	header bool // The DCA1 header has been checked for
+}
+
+// NewDCAReader reads Opus frames from a DCA0 or DCA1 stream
+func NewDCAReader(r io.Reader) OpusFrameReader {
+	return &dcaReader{r: r}
+}
+
+// ReadFrame returns the next frame
+func (d *dcaReader) ReadFrame() ([]byte, error) {
+	var prefix [4]byte
+
+	if !d.header {
+		d.header = true
+
+		// DCA0 has no header, so the magic may be the first frame's length
+		n, err := io.ReadFull(d.r, prefix[:])
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil && n < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if n == 4 && string(prefix[:]) == "DCA1" {
+			if err := d.skipMetadata(); err != nil {
+				return nil, err
+			}
+		} else {
+			// Put back the bytes after the length of the first frame
+			d.r = io.MultiReader(bytes.NewReader(append([]byte(nil), prefix[2:n]...)), d.r)
+			return d.readFrame(prefix[:2])
+		}
+	}
+
+	if _, err := io.ReadFull(d.r, prefix[:2]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	return d.readFrame(prefix[:2])
+}
+
+// skipMetadata skips the JSON metadata of a DCA1 header
+func (d *dcaReader) skipMetadata() error {
+	var size int32
+	if err := binary.Read(d.r, binary.LittleEndian, &size); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if size < 0 {
+		return fmt.Errorf("%w: negative DCA metadata size", ErrInvalidAudio)
+	}
+	if _, err := io.CopyN(io.Discard, d.r, int64(size)); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// readFrame reads a frame given its length prefix
+func (d *dcaReader) readFrame(prefix []byte) ([]byte, error) {
+	size := int16(binary.LittleEndian.Uint16(prefix))
+	if size <= 0 || size > maxOpusPacketSize {
+		return nil, fmt.Errorf("%w: DCA frame of %d bytes", ErrInvalidAudio, size)
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(d.r, frame); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return frame, nil
+}
+
+// Ogg page header flags
+const (
+	oggContinued = 0x01 // The page starts with the rest of a packet from the previous page
+	oggFirstPage = 0x02 // Beginning of a logical stream
+	oggLastPage  = 0x04 // End of a logical stream
+)
+
+// oggHeaderSize is the size of an Ogg page header without its segment table
+const oggHeaderSize = 27
+
+// oggOpusReader reads the Opus packets of the first logical stream in an
+// Ogg file (RFC 7845), skipping the OpusHead and OpusTags header packets
+type oggOpusReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool     // The first page of the stream has been read
+	ended   bool     // The last page of the stream has been read
+	headers int      // Header packets read so far
+	packets [][]byte // Complete packets from the current page
+	partial []byte   // Packet continuing on the next page
+}
+
+// NewOggOpusReader reads Opus frames from an Ogg/Opus stream
+func NewOggOpusReader(r io.Reader) OpusFrameReader {
+	return &oggOpusReader{r: r}
+}
+
+// ReadFrame returns the next audio packet
+func (o *oggOpusReader) ReadFrame() ([]byte, error) {
+	for {
+		if len(o.packets) > 0 {
+			packet := o.packets[0]
+			o.packets = o.packets[1:]
+
+			if o.headers < 2 {
+				if err := checkOpusHeader(o.headers, packet); err != nil {
+					return nil, err
+				}
+				o.headers++
+				continue
+			}
+			return packet, nil
+		}
+
+		if o.ended {
+			return nil, io.EOF
+		}
+		if err := o.readPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkOpusHeader checks that the first two packets are the Opus headers
+func checkOpusHeader(index int, packet []byte) error {
+	magic := "OpusHead"
+	if index == 1 {
+		magic = "OpusTags"
+	}
+	if !bytes.HasPrefix(packet, []byte(magic)) {
+		return fmt.Errorf("%w: expected %s packet", ErrInvalidAudio, magic)
+	}
+	return nil
+}
+
+// readPage reads the next page of the stream and splits it into packets
+func (o *oggOpusReader) readPage() error {
+	var header [oggHeaderSize]byte
+	if _, err := io.ReadFull(o.r, header[:]); err != nil {
+		if err == io.EOF && o.started && o.partial == nil {
+			// Streams that end without a last page flag still end here
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	}
+
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return fmt.Errorf("%w: bad Ogg page header", ErrInvalidAudio)
+	}
+	flags := header[5]
+	serial := binary.LittleEndian.Uint32(header[14:18])
+	checksum := binary.LittleEndian.Uint32(header[22:26])
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(o.r, segments); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	size := 0
+	for _, segment := range segments {
+		size += int(segment)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(o.r, data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	// The checksum covers the whole page with its own field zeroed
+	binary.LittleEndian.PutUint32(header[22:26], 0)
+	crc := oggCRC(oggCRC(oggCRC(0, header[:]), segments), data)
+	if crc != checksum {
+		return fmt.Errorf("%w: Ogg page checksum mismatch", ErrInvalidAudio)
+	}
+
+	if !o.started {
+		if flags&oggFirstPage == 0 {
+			return fmt.Errorf("%w: Ogg stream doesn't start with a first page", ErrInvalidAudio)
+		}
+		o.started = true
+		o.serial = serial
+	}
+
+	// Pages of other logical streams, such as video, are skipped
+	if serial != o.serial {
+		return nil
+	}
+	if flags&oggLastPage != 0 {
+		o.ended = true
+	}
+
+	// A packet left open by the previous page can only continue here
+	continued := flags&oggContinued != 0
+	if !continued && o.partial != nil {
+		return fmt.Errorf("%w: Ogg packet cut off", ErrInvalidAudio)
+	}
+
+	// Packets are laced into 255-byte segments; a shorter segment ends one
+	offset := 0
+	for _, segment := range segments {
+		o.partial = append(o.partial, data[offset:offset+int(segment)]...)
+		offset += int(segment)
+
+		if segment < 255 {
+			o.packets = append(o.packets, o.partial)
+			o.partial = nil
+		}
+	}
+
+	if o.ended && o.partial != nil {
+		return fmt.Errorf("%w: Ogg packet cut off", ErrInvalidAudio)
+	}
+	return nil
+}
+
+// oggCRCTable is the lookup table of the Ogg checksum, a CRC-32 with
+// polynomial 0x04c11db7 computed without bit reflection
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC adds b to an Ogg checksum
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dcaFrames encodes frames as a DCA0 stream
+func dcaFrames(frames ...string) string {
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+		buf.WriteString(frame)
+	}
+	return buf.String()
+}
+
+// fixtureFrames are the frames stored in every file in testdata. Frame i is
+// filled with the byte i+1, and the sizes cover packets that end exactly
+// on a 255-byte Ogg segment and packets split across Ogg pages.
+var fixtureFrames = func() [][]byte {
+	var frames [][]byte
+	for i, size := range []int{3, 120, 300, 255, 1, 510, 40} {
+		frames = append(frames, bytes.Repeat([]byte{byte(i + 1)}, size))
+	}
+	return frames
+}()
+
+// readAllFrames reads frames until the reader returns an error
+func readAllFrames(frames OpusFrameReader) ([][]byte, error) {
+	var all [][]byte
+	for {
+		frame, err := frames.ReadFrame()
+		if err == io.EOF {
+			return all, nil
+		}
+		if err != nil {
+			return all, err
+		}
+		all = append(all, frame)
+	}
+}
+
+// checkFixtureFrames checks that frames match the frames in testdata
+func checkFixtureFrames(t *testing.T, frames [][]byte) {
+	t.Helper()
+
+	if len(frames) != len(fixtureFrames) {
+		t.Fatalf("expected %d frames, got %d", len(fixtureFrames), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(frames[i], fixtureFrames[i]) {
+			t.Errorf("frame %d: expected %d bytes of %d, got %d bytes starting %v", i, len(fixtureFrames[i]), i+1, len(frames[i]), frames[i][:1])
+		}
+	}
+}
+
+func TestOpusFrameReaderFixtures(t *testing.T) {
+	// frames.opus has the Opus headers on their own pages, OpusTags split
+	// across two pages, a packet continued on the next page and a page of
+	// another logical stream in between
+	for _, name := range []string{"frames.dca", "frames_dca1.dca", "frames.opus"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatalf("reading fixture: %v", err)
+			}
+
+			// DCA0 is only read from files named .dca, as tracks are
+			read := func(data []byte) ([][]byte, error) {
+				return readAllFrames(&sniffingFrameReader{
+					r:   bufio.NewReader(bytes.NewReader(data)),
+					dca: filepath.Ext(name) == ".dca",
+				})
+			}
+
+			frames, err := read(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkFixtureFrames(t, frames)
+
+			// Cutting the file short must not yield a partial frame
+			frames, err = read(data[:len(data)-20])
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("expected a truncated file to fail, got %v", err)
+			}
+			for i, frame := range frames {
+				if !bytes.Equal(frame, fixtureFrames[i]) {
+					t.Errorf("frame %d of the truncated file is wrong", i)
+				}
+			}
+		})
+	}
+}
+
+func TestOggOpusReaderRejectsBadStreams(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "frames.opus"))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[40] ^= 0xFF // Inside the OpusHead packet
+	if _, err := NewOggOpusReader(bytes.NewReader(corrupt)).ReadFrame(); !errors.Is(err, ErrInvalidAudio) {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	if _, err := NewOggOpusReader(bytes.NewReader([]byte("not an ogg file at all, just text"))).ReadFrame(); !errors.Is(err, ErrInvalidAudio) {
+		t.Errorf("expected an invalid header error, got %v", err)
+	}
+}
+
+func TestDCAReaderRejectsBadFrameLength(t *testing.T) {
+	_, err := NewDCAReader(bytes.NewReader([]byte{0x00, 0x80, 1, 2})).ReadFrame()
+	if !errors.Is(err, ErrInvalidAudio) {
+		t.Errorf("expected a negative frame length to fail, got %v", err)
+	}
+
+	_, err = NewDCAReader(bytes.NewReader([]byte(dcaFrames(string(make([]byte, maxOpusPacketSize+1)))))).ReadFrame()
+	if !errors.Is(err, ErrInvalidAudio) {
+		t.Errorf("expected an oversized frame to fail, got %v", err)
+	}
+}
+
+func TestOpusFrameReaderRejectsUnknownFormats(t *testing.T) {
+	for _, data := range []string{"ID3\x04 an MP3 file", "<!DOCTYPE html>", dcaFrames("raw DCA0")} {
+		_, err := NewOpusFrameReader(bytes.NewReader([]byte(data))).ReadFrame()
+		if err == nil || err.Error() != "invalid audio stream: unsupported audio format" {
+			t.Errorf("expected %q to be unsupported, got %v", data, err)
+		}
+	}
+}
+
+func TestPlayAudioSendsWholeFrames(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "frames.opus"))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+
+	conn := newFakeVoiceConn()
+	vc := &VoiceConnection{GuildID: testGuildID, Conn: conn}
+	if err := vc.PlayAudio(NewOpusFrameReader(bytes.NewReader(data))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every frame is sent as its own packet and none is overwritten later
+	waitFor(t, func() bool { return len(conn.Packets()) == len(fixtureFrames) })
+	checkFixtureFrames(t, conn.Packets())
+}
+
+func TestPlayAudioPacesFrames(t *testing.T) {
+	duration := opusFrameDuration
+	opusFrameDuration = 5 * time.Millisecond
+	t.Cleanup(func() { opusFrameDuration = duration })
+
+	frames := make([]string, opusSendAhead+10)
+	for i := range frames {
+		frames[i] = "frame"
+	}
+
+	vc := &VoiceConnection{GuildID: testGuildID, Conn: newFakeVoiceConn()}
+	start := time.Now()
+	if err := vc.PlayAudio(NewDCAReader(bytes.NewReader([]byte(dcaFrames(frames...))))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only the frames sent ahead may go out without waiting
+	if elapsed, want := time.Since(start), 9*opusFrameDuration; elapsed < want {
+		t.Errorf("expected playback to take at least %v, took %v", want, elapsed)
+	}
+}
+
+func TestStopAudioUnblocksStalledConnection(t *testing.T) {
+	// Nobody receives from this connection, like one that dropped
+	conn := &fakeVoiceConn{send: make(chan []byte)}
+	vc := &VoiceConnection{GuildID: testGuildID, Conn: conn}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- vc.PlayAudio(NewDCAReader(bytes.NewReader([]byte(dcaFrames("frame")))))
+	}()
+
+	waitFor(t, vc.IsPlaying)
+	vc.StopAudio()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected stopping to end playback")
+	}
+}
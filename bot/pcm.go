@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// PCM format accepted by the encoding pipeline: 48kHz stereo, 16-bit
+// little-endian samples, interleaved
+const (
+	pcmSampleRate   = 48000
+	pcmChannels     = 2
+	pcmFrameSamples = pcmSampleRate / 50                // Samples per channel in a 20ms frame
+	pcmFrameBytes   = pcmFrameSamples * pcmChannels * 2 // Bytes in a 20ms frame
+)
+
+// pcmFadeFrames is how many frames a fade out lasts
+const pcmFadeFrames = 15
+
+// Volume limits, in percent
+const (
+	DefaultVolume = 100
+	MaxVolume     = 200
+)
+
+// ErrNoEncoder is returned when PCM audio has to be played but no Opus
+// encoder is available
+var ErrNoEncoder = errors.New("no Opus encoder available")
+
+// OpusEncoder encodes 48kHz stereo PCM into Opus packets. Encoders may
+// hold on to a few frames, so Encode returns whatever packets are ready,
+// which can be none, and Flush returns the rest at the end of the stream.
+type OpusEncoder interface {
+	// Encode takes one 20ms frame of interleaved samples
+	Encode(pcm []int16) ([][]byte, error)
+	// Flush ends the stream and returns the packets still being encoded
+	Flush() ([][]byte, error)
+	// Close releases the encoder
+	Close() error
+}
+
+// fader is implemented by frame readers that can fade out instead of
+// stopping abruptly. FadeOut reports whether a fade started; if it did, the
+// reader returns io.EOF once the audio has faded.
+type fader interface {
+	FadeOut() bool
+}
+
+// PCMFrameReader encodes raw PCM into Opus frames, applying a volume. The
+// volume is read for every frame, so changes are heard right away.
+type PCMFrameReader struct {
+	r       io.Reader
+	encoder OpusEncoder
+	volume  func() int // Volume in percent
+
+	mu       sync.Mutex
+	fadeLeft int // Frames left in the fade out, or -1 if not fading
+
+	buf     []byte
+	samples []int16
+	gain    float64  // Gain at the end of the last frame
+	pending [][]byte // Encoded packets not returned yet
+	flushed bool
+}
+
+// NewPCMFrameReader creates a reader that encodes the PCM from r with encoder
+func NewPCMFrameReader(r io.Reader, encoder OpusEncoder, volume func() int) *PCMFrameReader {
+	return &PCMFrameReader{
+		r:        r,
+		encoder:  encoder,
+		volume:   volume,
+		fadeLeft: -1,
+		buf:      make([]byte, pcmFrameBytes),
+		samples:  make([]int16, pcmFrameSamples*pcmChannels),
+		gain:     volumeGain(volume()),
+	}
+}
+
+// volumeGain converts a volume in percent to a gain
+func volumeGain(volume int) float64 {
+	return float64(volume) / 100
+}
+
+// FadeOut fades the audio out over the next pcmFadeFrames frames
+func (p *PCMFrameReader) FadeOut() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fadeLeft < 0 {
+		p.fadeLeft = pcmFadeFrames
+	}
+	return true
+}
+
+// ReadFrame returns the next Opus frame
+func (p *PCMFrameReader) ReadFrame() ([]byte, error) {
+	for len(p.pending) == 0 {
+		if p.flushed {
+			return nil, io.EOF
+		}
+
+		more, err := p.encodeFrame()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			packets, err := p.encoder.Flush()
+			if err != nil {
+				return nil, fmt.Errorf("error encoding audio: %w", err)
+			}
+			p.pending = append(p.pending, packets...)
+			p.flushed = true
+		}
+	}
+
+	frame := p.pending[0]
+	p.pending = p.pending[1:]
+	return frame, nil
+}
+
+// encodeFrame reads, adjusts and encodes the next PCM frame. It reports
+// false once the audio has ended or faded out.
+func (p *PCMFrameReader) encodeFrame() (bool, error) {
+	p.mu.Lock()
+	fadeLeft := p.fadeLeft
+	if fadeLeft > 0 {
+		p.fadeLeft--
+	}
+	p.mu.Unlock()
+
+	if fadeLeft == 0 {
+		return false, nil
+	}
+
+	// A short last frame is padded with silence
+	n, err := io.ReadFull(p.r, p.buf)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("error reading PCM: %w", err)
+	}
+	for i := n; i < len(p.buf); i++ {
+		p.buf[i] = 0
+	}
+	for i := range p.samples {
+		p.samples[i] = int16(binary.LittleEndian.Uint16(p.buf[i*2:]))
+	}
+
+	target := volumeGain(p.volume())
+	if fadeLeft > 0 {
+		target *= float64(fadeLeft-1) / pcmFadeFrames
+	}
+	applyGain(p.samples, p.gain, target)
+	p.gain = target
+
+	packets, err := p.encoder.Encode(p.samples)
+	if err != nil {
+		return false, fmt.Errorf("error encoding audio: %w", err)
+	}
+	p.pending = append(p.pending, packets...)
+
+	// The rest of a short frame is silence, so the audio ends with it
+	return n == len(p.buf), nil
+}
+
+// applyGain scales interleaved stereo samples, ramping the gain from one
+// value to another over the frame so volume changes don't click
+func applyGain(samples []int16, from, to float64) {
+	if from == 1 && to == 1 {
+		return
+	}
+
+	frames := len(samples) / pcmChannels
+	for i := 0; i < frames; i++ {
+		gain := from + (to-from)*float64(i+1)/float64(frames)
+		for c := 0; c < pcmChannels; c++ {
+			s := math.Round(float64(samples[i*pcmChannels+c]) * gain)
+			samples[i*pcmChannels+c] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, s)))
+		}
+	}
+}
+
+// Close releases the encoder
+func (p *PCMFrameReader) Close() error {
+	return p.encoder.Close()
+}
+
+// readWAVHeader reads the header of a WAV file up to its audio data, which
+// must be 48kHz stereo 16-bit PCM, and returns a reader of the data
+func readWAVHeader(r io.Reader) (io.Reader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return nil, fmt.Errorf("%w: bad WAV header", ErrInvalidAudio)
+	}
+
+	format := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("%w: bad WAV format", ErrInvalidAudio)
+			}
+			var fmtChunk [16]byte
+			if _, err := io.ReadFull(r, fmtChunk[:]); err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			codec := binary.LittleEndian.Uint16(fmtChunk[0:])
+			channels := binary.LittleEndian.Uint16(fmtChunk[2:])
+			rate := binary.LittleEndian.Uint32(fmtChunk[4:])
+			bits := binary.LittleEndian.Uint16(fmtChunk[14:])
+
+			// 0xFFFE is WAVE_FORMAT_EXTENSIBLE, which ffmpeg writes for PCM too
+			if (codec != 1 && codec != 0xFFFE) || channels != pcmChannels || rate != pcmSampleRate || bits != 16 {
+				return nil, fmt.Errorf("%w: WAV audio must be 48kHz stereo 16-bit PCM", ErrInvalidAudio)
+			}
+			format = true
+			size -= 16
+
+		case "data":
+			if !format {
+				return nil, fmt.Errorf("%w: WAV data before its format", ErrInvalidAudio)
+			}
+			return io.LimitReader(r, size), nil
+		}
+
+		// Chunks are padded to an even size
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// ffmpegBitrate is the bitrate ffmpeg encodes at, in bits per second
+const ffmpegBitrate = 128000
+
+// FFmpegEncoder encodes PCM with an ffmpeg process using libopus. ffmpeg
+// writes Ogg/Opus, which is split back into packets as it arrives.
+type FFmpegEncoder struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	buf   []byte
+
+	mu      sync.Mutex
+	packets [][]byte
+	err     error
+	done    chan struct{} // Closed once ffmpeg's output has been read
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewFFmpegEncoder starts an ffmpeg process, found at path, to encode a stream
+func NewFFmpegEncoder(path string) (*FFmpegEncoder, error) {
+	cmd := exec.Command(path,
+		"-hide_banner", "-loglevel", "error",
+		"-f", "s16le", "-ar", strconv.Itoa(pcmSampleRate), "-ac", strconv.Itoa(pcmChannels), "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", strconv.Itoa(ffmpegBitrate), "-frame_duration", "20",
+		// One packet per page, written as soon as it is encoded
+		"-page_duration", "20000", "-flush_packets", "1",
+		"-f", "ogg", "pipe:1",
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating ffmpeg input: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: error starting ffmpeg: %v", ErrNoEncoder, err)
+	}
+
+	e := &FFmpegEncoder{
+		cmd:   cmd,
+		stdin: stdin,
+		buf:   make([]byte, pcmFrameBytes),
+		done:  make(chan struct{}),
+	}
+	go e.readPackets(NewOggOpusReader(stdout))
+	return e, nil
+}
+
+// readPackets collects the packets ffmpeg writes until its output ends
+func (e *FFmpegEncoder) readPackets(frames OpusFrameReader) {
+	defer close(e.done)
+
+	for {
+		frame, err := frames.ReadFrame()
+		e.mu.Lock()
+		if err != nil {
+			if err != io.EOF {
+				e.err = err
+			}
+			e.mu.Unlock()
+			return
+		}
+		e.packets = append(e.packets, frame)
+		e.mu.Unlock()
+	}
+}
+
+// take returns the packets read so far
+func (e *FFmpegEncoder) take() ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	packets := e.packets
+	e.packets = nil
+	return packets, e.err
+}
+
+// Encode sends a frame to ffmpeg and returns the packets it has finished
+func (e *FFmpegEncoder) Encode(pcm []int16) ([][]byte, error) {
+	for i, s := range pcm {
+		binary.LittleEndian.PutUint16(e.buf[i*2:], uint16(s))
+	}
+	if _, err := e.stdin.Write(e.buf[:len(pcm)*2]); err != nil {
+		return nil, fmt.Errorf("error writing to ffmpeg: %w", err)
+	}
+	return e.take()
+}
+
+// Flush waits for ffmpeg to encode the rest of the stream
+func (e *FFmpegEncoder) Flush() ([][]byte, error) {
+	e.stdin.Close()
+	<-e.done
+	return e.take()
+}
+
+// Close stops ffmpeg
+func (e *FFmpegEncoder) Close() error {
+	e.closeOnce.Do(func() {
+		e.stdin.Close()
+		_ = e.cmd.Process.Kill()
+		<-e.done
+
+		// Killing ffmpeg makes Wait fail, which is expected
+		if err := e.cmd.Wait(); err != nil && !errors.As(err, new(*exec.ExitError)) {
+			e.closeErr = err
+		}
+	})
+	return e.closeErr
+}
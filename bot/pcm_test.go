@@ -0,0 +1,312 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEncoder records the PCM frames it is given. Each packet holds the
+// index of its frame, and packets are held back for delay frames like a
+// real encoder's lookahead.
+type fakeEncoder struct {
+	delay int
+
+	mu     sync.Mutex
+	frames [][]int16
+	closed bool
+}
+
+func (e *fakeEncoder) Encode(pcm []int16) ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.frames = append(e.frames, append([]int16(nil), pcm...))
+	if n := len(e.frames) - 1 - e.delay; n >= 0 {
+		return [][]byte{{byte(n)}}, nil
+	}
+	return nil, nil
+}
+
+func (e *fakeEncoder) Flush() ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var packets [][]byte
+	for n := len(e.frames) - e.delay; n < len(e.frames); n++ {
+		if n >= 0 {
+			packets = append(packets, []byte{byte(n)})
+		}
+	}
+	return packets, nil
+}
+
+func (e *fakeEncoder) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	return nil
+}
+
+// Frames returns the PCM frames encoded so far
+func (e *fakeEncoder) Frames() [][]int16 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([][]int16(nil), e.frames...)
+}
+
+// constantPCM returns PCM where every sample is value
+func constantPCM(value int16, samples int) []byte {
+	buf := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(value))
+	}
+	return buf
+}
+
+// endlessPCM is a PCM stream that never ends
+type endlessPCM struct{}
+
+func (endlessPCM) Read(p []byte) (int, error) {
+	for i := 0; i+1 < len(p); i += 2 {
+		binary.LittleEndian.PutUint16(p[i:], 1000)
+	}
+	return len(p) &^ 1, nil
+}
+
+func TestPCMFrameReaderAppliesVolume(t *testing.T) {
+	encoder := &fakeEncoder{delay: 2}
+	volume := 50
+
+	// Two whole frames and half of one
+	pcm := constantPCM(1000, pcmFrameSamples*pcmChannels*5/2)
+	frames, err := readAllFrames(NewPCMFrameReader(bytes.NewReader(pcm), encoder, func() int { return volume }))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(frames))
+	}
+	for i, frame := range frames {
+		if frame[0] != byte(i) {
+			t.Errorf("expected frame %d in order, got %d", i, frame[0])
+		}
+	}
+
+	encoded := encoder.Frames()
+	if got := encoded[0][0]; got != 500 {
+		t.Errorf("expected half volume, got sample %d", got)
+	}
+	last := encoded[2]
+	if last[len(last)/2-1] != 500 || last[len(last)/2] != 0 {
+		t.Errorf("expected the short last frame to be padded with silence, got %d then %d", last[len(last)/2-1], last[len(last)/2])
+	}
+}
+
+func TestPCMFrameReaderRampsVolumeChanges(t *testing.T) {
+	encoder := &fakeEncoder{}
+	volume := 100
+	frames := NewPCMFrameReader(endlessPCM{}, encoder, func() int { return volume })
+
+	frames.ReadFrame()
+	volume = 200
+	frames.ReadFrame()
+
+	ramp := encoder.Frames()[1]
+	if first, last := ramp[0], ramp[len(ramp)-1]; first <= 1000 || first >= 1100 || last != 2000 {
+		t.Errorf("expected the gain to ramp up over the frame, got %d to %d", first, last)
+	}
+}
+
+func TestApplyGainClips(t *testing.T) {
+	samples := []int16{30000, -30000}
+	applyGain(samples, 2, 2)
+	if samples[0] != 32767 || samples[1] != -32768 {
+		t.Errorf("expected samples to clip, got %v", samples)
+	}
+}
+
+func TestPCMFrameReaderFadesOut(t *testing.T) {
+	encoder := &fakeEncoder{}
+	frames := NewPCMFrameReader(endlessPCM{}, encoder, func() int { return DefaultVolume })
+
+	frames.ReadFrame()
+	frames.FadeOut()
+	rest, err := readAllFrames(frames)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rest) != pcmFadeFrames {
+		t.Fatalf("expected the fade to take %d frames, got %d", pcmFadeFrames, len(rest))
+	}
+
+	prev := int16(1000)
+	for i, frame := range encoder.Frames()[1:] {
+		if end := frame[len(frame)-1]; end >= prev {
+			t.Fatalf("expected frame %d to be quieter than %d, got %d", i, prev, end)
+		} else {
+			prev = end
+		}
+	}
+	if prev != 0 {
+		t.Errorf("expected the fade to end in silence, got %d", prev)
+	}
+}
+
+func TestStopAudioFadesOutPCM(t *testing.T) {
+	duration := opusFrameDuration
+	opusFrameDuration = time.Millisecond
+	t.Cleanup(func() { opusFrameDuration = duration })
+
+	encoder := &fakeEncoder{}
+	conn := newFakeVoiceConn()
+	vc := &VoiceConnection{GuildID: testGuildID, Conn: conn}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- vc.PlayAudio(NewPCMFrameReader(endlessPCM{}, encoder, func() int { return DefaultVolume }))
+	}()
+
+	waitFor(t, func() bool { return len(conn.Packets()) > 3 })
+	vc.StopAudio()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected playback to end after fading out")
+	}
+
+	encoded := encoder.Frames()
+	if last := encoded[len(encoded)-1]; last[len(last)-1] != 0 {
+		t.Errorf("expected playback to end in silence, got %d", last[len(last)-1])
+	}
+	// The fake connection records the last packet after it is received
+	waitFor(t, func() bool { return len(conn.Packets()) == len(encoded) })
+}
+
+// wavHeader returns the header of a WAV file with the given format
+func wavHeader(rate uint32, channels, bits uint16, dataSize uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []interface{}{
+		uint32(16), uint16(1), channels, rate,
+		rate * uint32(channels) * uint32(bits) / 8, channels * bits / 8, bits,
+	} {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+	// Chunks the reader doesn't know are skipped
+	buf.WriteString("LIST")
+	binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.WriteString("abc\x00")
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataSize)
+	return buf.Bytes()
+}
+
+func TestReadWAVHeader(t *testing.T) {
+	wav := append(wavHeader(pcmSampleRate, 2, 16, 4), "pcm!trailing"...)
+	pcm, err := readWAVHeader(bytes.NewReader(wav))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := io.ReadAll(pcm); string(data) != "pcm!" {
+		t.Errorf("expected only the data chunk, got %q", data)
+	}
+
+	if _, err := readWAVHeader(bytes.NewReader(wavHeader(44100, 2, 16, 4))); !errors.Is(err, ErrInvalidAudio) {
+		t.Errorf("expected 44.1kHz audio to be rejected, got %v", err)
+	}
+}
+
+func TestPlayEncodesWAVTracks(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	encoder := &fakeEncoder{}
+	b.Voice.Encoder = func() (OpusEncoder, error) { return encoder, nil }
+
+	pcm := constantPCM(1000, pcmFrameSamples*pcmChannels*2)
+	useTestAudio(b, map[string]string{
+		"song.wav": string(append(wavHeader(pcmSampleRate, 2, 16, uint32(len(pcm))), pcm...)),
+		"song.pcm": string(pcm),
+	})
+
+	controlCommand(b, session, "play", "song.wav")
+	controlCommand(b, session, "play", "song.pcm")
+
+	conn := session.VoiceConn(testGuildID)
+	waitFor(t, func() bool {
+		_, playing := b.Voice.Queue(testGuildID).Current()
+		return !playing && len(conn.Packets()) == 4
+	})
+	if len(encoder.Frames()) != 4 {
+		t.Errorf("expected both tracks to be encoded, got %d frames", len(encoder.Frames()))
+	}
+	if !encoder.closed {
+		t.Error("expected the encoder to be closed")
+	}
+}
+
+func TestVolumeCommand(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "volume", "50")
+	if got := lastMessage(t, session); got != "Nothing is playing." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+
+	controlCommand(b, session, "play", "first.dca")
+	defer audio.track(t, "first.dca").Close()
+	waitForPlayback(t, b)
+
+	controlCommand(b, session, "volume", "50")
+	if got := lastMessage(t, session); got != "Volume set to 50%." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+	controlCommand(b, session, "volume")
+	if got := lastMessage(t, session); got != "The volume is 50%." {
+		t.Errorf("unexpected reply: %q", got)
+	}
+	if volume := b.Voice.Queue(testGuildID).Volume(); volume != 50 {
+		t.Errorf("expected the queue volume to be 50, got %d", volume)
+	}
+}
+
+func TestFFmpegEncoder(t *testing.T) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+
+	encoder, err := NewFFmpegEncoder(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer encoder.Close()
+
+	pcm := constantPCM(0, pcmFrameSamples*pcmChannels*10)
+	frames, err := readAllFrames(NewPCMFrameReader(bytes.NewReader(pcm), encoder, func() int { return DefaultVolume }))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The encoder may add a frame for its lookahead
+	if len(frames) < 10 {
+		t.Errorf("expected at least 10 frames, got %d", len(frames))
+	}
+}
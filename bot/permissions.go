@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// permissionNames maps permission bits to the names Discord shows for them
+var permissionNames = []struct {
+	bit  int64
+	name string
+}{
+	{discordgo.PermissionCreateInstantInvite, "Create Invite"},
+	{discordgo.PermissionKickMembers, "Kick Members"},
+	{discordgo.PermissionBanMembers, "Ban Members"},
+	{discordgo.PermissionAdministrator, "Administrator"},
+	{discordgo.PermissionManageChannels, "Manage Channels"},
+	{discordgo.PermissionManageServer, "Manage Server"},
+	{discordgo.PermissionAddReactions, "Add Reactions"},
+	{discordgo.PermissionViewAuditLogs, "View Audit Log"},
+	{discordgo.PermissionViewChannel, "View Channels"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+	{discordgo.PermissionManageMessages, "Manage Messages"},
+	{discordgo.PermissionEmbedLinks, "Embed Links"},
+	{discordgo.PermissionAttachFiles, "Attach Files"},
+	{discordgo.PermissionReadMessageHistory, "Read Message History"},
+	{discordgo.PermissionMentionEveryone, "Mention Everyone"},
+	{discordgo.PermissionUseExternalEmojis, "Use External Emojis"},
+	{discordgo.PermissionVoiceConnect, "Connect"},
+	{discordgo.PermissionVoiceSpeak, "Speak"},
+	{discordgo.PermissionVoiceMuteMembers, "Mute Members"},
+	{discordgo.PermissionVoiceDeafenMembers, "Deafen Members"},
+	{discordgo.PermissionVoiceMoveMembers, "Move Members"},
+	{discordgo.PermissionChangeNickname, "Change Nickname"},
+	{discordgo.PermissionManageNicknames, "Manage Nicknames"},
+	{discordgo.PermissionManageRoles, "Manage Roles"},
+	{discordgo.PermissionManageWebhooks, "Manage Webhooks"},
+	{discordgo.PermissionManageEmojis, "Manage Emojis and Stickers"},
+	{discordgo.PermissionManageThreads, "Manage Threads"},
+	{discordgo.PermissionModerateMembers, "Timeout Members"},
+}
+
+// describePermissions lists the names of the permissions set in perms
+func describePermissions(perms int64) string {
+	var names []string
+	for _, p := range permissionNames {
+		if perms&p.bit != 0 {
+			names = append(names, p.name)
+			perms &^= p.bit
+		}
+	}
+	if perms != 0 {
+		names = append(names, fmt.Sprintf("0x%x", perms))
+	}
+	return strings.Join(names, ", ")
+}
+
+// BotPermissionMiddleware rejects guild invocations when the bot lacks the
+// permissions the command needs in the channel
+func BotPermissionMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			if ctx.GuildID == "" || ctx.BotPermissions == 0 {
+				return next(ctx)
+			}
+
+			perms, err := ctx.Session.UserChannelPermissions(ctx.Session.BotUserID(), ctx.ChannelID)
+			if err != nil {
+				logrus.Errorf("Error checking bot permissions: %v", err)
+				return ctx.replyEphemeral("An error occurred while checking permissions.")
+			}
+
+			if missing := ctx.BotPermissions &^ perms; missing != 0 {
+				return ctx.replyEphemeral(fmt.Sprintf("I need the following permissions to do that: %s.", describePermissions(missing)))
+			}
+
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxPrefixLength is the longest command prefix a guild can configure
+const MaxPrefixLength = 10
+
+// PrefixStore persists per-guild command prefixes
+type PrefixStore interface {
+	GetGuildPrefix(guildID string) (string, error)
+	SetGuildPrefix(guildID, prefix string) error
+}
+
+// PrefixCache caches per-guild command prefixes in front of a PrefixStore
+type PrefixCache struct {
+	store         PrefixStore
+	defaultPrefix string
+	prefixes      map[string]string
+	mu            sync.RWMutex
+}
+
+// NewPrefixCache creates a prefix cache that falls back to defaultPrefix
+func NewPrefixCache(store PrefixStore, defaultPrefix string) *PrefixCache {
+	return &PrefixCache{
+		store:         store,
+		defaultPrefix: defaultPrefix,
+		prefixes:      make(map[string]string),
+	}
+}
+
+// Default returns the prefix used outside guilds and by guilds without their own
+func (c *PrefixCache) Default() string {
+	return c.defaultPrefix
+}
+
+// Get returns the command prefix for a guild
+func (c *PrefixCache) Get(guildID string) string {
+	if guildID == "" {
+		return c.defaultPrefix
+	}
+
+	c.mu.RLock()
+	prefix, ok := c.prefixes[guildID]
+	c.mu.RUnlock()
+
+	if !ok {
+		var err error
+		prefix, err = c.store.GetGuildPrefix(guildID)
+		if err != nil {
+			// Don't cache failures so the lookup is retried
+			logrus.Errorf("Error loading prefix for guild %s: %v", guildID, err)
+			return c.defaultPrefix
+		}
+
+		c.mu.Lock()
+		c.prefixes[guildID] = prefix
+		c.mu.Unlock()
+	}
+
+	if prefix == "" {
+		return c.defaultPrefix
+	}
+	return prefix
+}
+
+// Set stores a new command prefix for a guild. An empty prefix restores the default.
+func (c *PrefixCache) Set(guildID, prefix string) error {
+	if err := c.store.SetGuildPrefix(guildID, prefix); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.prefixes[guildID] = prefix
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Forget drops a guild from the cache, e.g. when the bot leaves it
+func (c *PrefixCache) Forget(guildID string) {
+	c.mu.Lock()
+	delete(c.prefixes, guildID)
+	c.mu.Unlock()
+}
+
+// matchPrefix returns the message content after the guild prefix or a
+// mention of the bot, and whether the message was addressed to the bot
+func (b *Bot) matchPrefix(guildID, content string) (string, bool) {
+	if prefix := b.Prefixes.Get(guildID); strings.HasPrefix(content, prefix) {
+		return strings.TrimPrefix(content, prefix), true
+	}
+
+	botID := b.Session.BotUserID()
+	for _, mention := range []string{"<@" + botID + ">", "<@!" + botID + ">"} {
+		if strings.HasPrefix(content, mention) {
+			return strings.TrimPrefix(content, mention), true
+		}
+	}
+
+	return "", false
+}
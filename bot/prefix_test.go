@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestOnMessageCreateUsesGuildPrefix(t *testing.T) {
+	b, session := newTestBot()
+	if err := b.Prefixes.Set(testGuildID, "?"); err != nil {
+		t.Fatalf("setting prefix: %v", err)
+	}
+
+	b.onMessageCreate(nil, newTestMessage("!info"))
+	if got := len(sentMessages(session)); got != 0 {
+		t.Fatalf("default prefix should be ignored once a guild prefix is set, got %d replies", got)
+	}
+
+	b.onMessageCreate(nil, newTestMessage("?info"))
+	if got := len(sentMessages(session)); got != 1 {
+		t.Fatalf("expected the guild prefix to run the command, got %d replies", got)
+	}
+}
+
+func TestOnMessageCreateAcceptsMentionPrefix(t *testing.T) {
+	b, session := newTestBot()
+
+	b.onMessageCreate(nil, newTestMessage("<@!"+session.BotUserID()+"> info"))
+	b.onMessageCreate(nil, newTestMessage("<@"+session.BotUserID()+">   info"))
+
+	if got := len(sentMessages(session)); got != 2 {
+		t.Fatalf("expected both mentions to run the command, got %d replies", got)
+	}
+}
+
+func TestPrefixCacheFallsBackToDefault(t *testing.T) {
+	cache := NewPrefixCache(memoryPrefixStore{}, "!")
+
+	if got := cache.Get(testGuildID); got != "!" {
+		t.Errorf("expected default prefix, got %q", got)
+	}
+
+	cache.Set(testGuildID, "$")
+	if got := cache.Get(testGuildID); got != "$" {
+		t.Errorf("expected guild prefix, got %q", got)
+	}
+
+	cache.Set(testGuildID, "")
+	if got := cache.Get(testGuildID); got != "!" {
+		t.Errorf("expected reset to default prefix, got %q", got)
+	}
+}
+
+func TestOnMessageCreateIgnoresUnknownCommands(t *testing.T) {
+	b, session := newTestBot()
+	if err := b.Prefixes.Set(testGuildID, "."); err != nil {
+		t.Fatalf("setting prefix: %v", err)
+	}
+
+	b.onMessageCreate(nil, newTestMessage(`...don't "stop`))
+	if calls := len(session.Calls("ChannelMessageSend")) + len(sentMessages(session)); calls != 0 {
+		t.Fatalf("expected chat that names no command to be ignored, got %d replies", calls)
+	}
+
+	b.onMessageCreate(nil, newTestMessage(`.help "ping`))
+	notices := sentNotices(session)
+	if len(notices) != 1 || notices[0] != "unterminated quote\nUsage: `.help [command]`" {
+		t.Errorf("expected a usage reply, got %q", notices)
+	}
+}
+
+func TestSettingsPrefixCountsCharacters(t *testing.T) {
+	b, session := newTestBot()
+	newManagedTestGuild(t, session)
+
+	// Ten characters, but far more than ten bytes
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("prefix",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "prefix", Type: discordgo.ApplicationCommandOptionString, Value: "🎵🎵🎵ñññ日本語"},
+	)))
+	if got := session.Responses()[0].Data.Content; got != "Command prefix set to `🎵🎵🎵ñññ日本語`." {
+		t.Errorf("unexpected response: %q", got)
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("prefix",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "prefix", Type: discordgo.ApplicationCommandOptionString, Value: "🎵🎵🎵🎵🎵🎵🎵🎵🎵🎵🎵"},
+	)))
+	if got := session.Responses()[1].Data.Content; got != "Prefixes must be at most 10 characters with no spaces or backticks." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
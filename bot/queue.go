@@ -0,0 +1,434 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// LoopMode controls what plays when a track ends
+type LoopMode string
+
+// Loop modes
+const (
+	LoopOff   LoopMode = "off"   // Play the queue once
+	LoopTrack LoopMode = "track" // Repeat the current track until it is skipped
+	LoopQueue LoopMode = "queue" // Move finished tracks to the end of the queue
+)
+
+// Track is a queued piece of audio
+type Track struct {
+	Location      string // URL or file name passed to the AudioSource
+	RequestedBy   string // ID of the user who queued the track
+	TextChannelID string // Channel the track was queued from, where "now playing" is posted
+}
+
+// MusicQueue is the track queue of a guild. The track that is playing is
+// kept apart from the tracks still to come.
+type MusicQueue struct {
+	GuildID string
+
+	mu         sync.Mutex
+	current    *Track
+	tracks     []Track
+	loop       LoopMode
+	volume     int                // Volume of PCM tracks, in percent
+	skipped    bool               // The current track was skipped, so it isn't repeated
+	running    bool               // A player is working through the queue
+	nowPlaying *discordgo.Message // "Now playing" message of the current track
+}
+
+// NewMusicQueue creates an empty queue
+func NewMusicQueue(guildID string) *MusicQueue {
+	return &MusicQueue{GuildID: guildID, loop: LoopOff, volume: DefaultVolume}
+}
+
+// Enqueue adds a track to the end of the queue and returns its position,
+// counting from 1 for the next track
+func (q *MusicQueue) Enqueue(track Track) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tracks = append(q.tracks, track)
+	return len(q.tracks)
+}
+
+// Current returns the track that is playing
+func (q *MusicQueue) Current() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current == nil {
+		return Track{}, false
+	}
+	return *q.current, true
+}
+
+// Tracks returns the tracks still to come, in order
+func (q *MusicQueue) Tracks() []Track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]Track(nil), q.tracks...)
+}
+
+// Remove removes the track at a position, counting from 1 for the next track
+func (q *MusicQueue) Remove(position int) (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if position < 1 || position > len(q.tracks) {
+		return Track{}, false
+	}
+	track := q.tracks[position-1]
+	q.tracks = append(q.tracks[:position-1], q.tracks[position:]...)
+	return track, true
+}
+
+// Clear removes every track still to come and returns how many there were.
+// The current track keeps playing.
+func (q *MusicQueue) Clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := len(q.tracks)
+	q.tracks = nil
+	return n
+}
+
+// Shuffle puts the tracks still to come in a random order
+func (q *MusicQueue) Shuffle() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rand.Shuffle(len(q.tracks), func(i, j int) {
+		q.tracks[i], q.tracks[j] = q.tracks[j], q.tracks[i]
+	})
+}
+
+// Loop returns the loop mode
+func (q *MusicQueue) Loop() LoopMode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.loop
+}
+
+// SetLoop changes the loop mode
+func (q *MusicQueue) SetLoop(mode LoopMode) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.loop = mode
+}
+
+// Volume returns the playback volume in percent
+func (q *MusicQueue) Volume() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.volume
+}
+
+// SetVolume changes the playback volume, in percent. It applies to the
+// track that is playing right away.
+func (q *MusicQueue) SetVolume(volume int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.volume = volume
+}
+
+// skip marks the current track as skipped, so looping doesn't repeat it
+func (q *MusicQueue) skip() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.skipped = true
+}
+
+// start marks the queue as having a player. It reports false if one is
+// already running.
+func (q *MusicQueue) start() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.running {
+		return false
+	}
+	q.running = true
+	return true
+}
+
+// next moves on to the track that should play after the current one,
+// following the loop mode. When the queue is empty it stops the player and
+// reports false.
+func (q *MusicQueue) next() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	skipped := q.skipped
+	q.skipped = false
+
+	if q.current != nil && !skipped {
+		switch q.loop {
+		case LoopTrack:
+			return *q.current, true
+		case LoopQueue:
+			q.tracks = append(q.tracks, *q.current)
+		}
+	}
+
+	if len(q.tracks) == 0 {
+		q.current = nil
+		q.running = false
+		return Track{}, false
+	}
+
+	track := q.tracks[0]
+	q.tracks = q.tracks[1:]
+	q.current = &track
+	return track, true
+}
+
+// drop forgets the current track without looping it, e.g. when it can't be played
+func (q *MusicQueue) drop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.current = nil
+}
+
+// stop empties the queue and stops the player
+func (q *MusicQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.current = nil
+	q.tracks = nil
+	q.running = false
+}
+
+// setNowPlaying records the "now playing" message of the current track and
+// returns the previous one
+func (q *MusicQueue) setNowPlaying(msg *discordgo.Message) *discordgo.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	prev := q.nowPlaying
+	q.nowPlaying = msg
+	return prev
+}
+
+// Queue returns the track queue of a guild, creating it if needed
+func (vm *VoiceManager) Queue(guildID string) *MusicQueue {
+	vm.Mu.Lock()
+	defer vm.Mu.Unlock()
+
+	q, ok := vm.Queues[guildID]
+	if !ok {
+		q = NewMusicQueue(guildID)
+		vm.Queues[guildID] = q
+	}
+	return q
+}
+
+// Enqueue adds a track to a guild's queue and starts playing the queue if
+// it isn't already. It returns the track's position, or 0 if nothing was
+// playing and the track starts right away.
+func (vm *VoiceManager) Enqueue(guildID string, track Track) int {
+	q := vm.Queue(guildID)
+	position := q.Enqueue(track)
+
+	if q.start() {
+		go vm.playQueue(q)
+		return 0
+	}
+	return position
+}
+
+// Skip stops the current track of a guild so the next one plays. It
+// returns the skipped track.
+func (vm *VoiceManager) Skip(guildID string) (Track, bool) {
+	q := vm.Queue(guildID)
+	track, ok := q.Current()
+	if !ok {
+		return Track{}, false
+	}
+
+	q.skip()
+	if vc, ok := vm.Connection(guildID); ok {
+		vc.StopAudio()
+	}
+	return track, true
+}
+
+// playQueue plays a guild's queue until it runs out or the bot leaves the
+// voice channel
+func (vm *VoiceManager) playQueue(q *MusicQueue) {
+	for {
+		track, ok := q.next()
+		if !ok {
+			vm.finishNowPlaying(q)
+			return
+		}
+
+		vc, ok := vm.Connection(q.GuildID)
+		if !ok {
+			q.stop()
+			vm.finishNowPlaying(q)
+			return
+		}
+
+		// The stream lives as long as playback
+		stream, err := vm.Source.Open(context.Background(), track.Location)
+		if err != nil {
+			logrus.Warnf("Error opening audio %q: %v", track.Location, err)
+			q.drop()
+			vm.notify(track.TextChannelID, fmt.Sprintf("I couldn't open `%s`: %v.", track.Location, err))
+			continue
+		}
+
+		vm.postNowPlaying(q, track, vc)
+		frames := vm.trackFrames(q, track, stream)
+		err = vc.PlayAudio(frames)
+		if err := frames.Close(); err != nil {
+			logrus.Warnf("Error closing audio encoder: %v", err)
+		}
+		stream.Close()
+
+		// A track that can't be played isn't looped
+		if err != nil {
+			logrus.Errorf("Error playing audio in guild %s: %v", q.GuildID, err)
+			q.drop()
+			switch {
+			case errors.Is(err, ErrInvalidAudio):
+				vm.notify(track.TextChannelID, fmt.Sprintf("I couldn't play `%s`: it isn't Opus audio in a DCA or Ogg file, or 48kHz stereo PCM.", track.Location))
+			case errors.Is(err, ErrNoEncoder):
+				vm.notify(track.TextChannelID, fmt.Sprintf("I couldn't play `%s`: PCM audio can't be encoded right now.", track.Location))
+			}
+		}
+	}
+}
+
+// trackFrames returns the Opus frames of a track's stream. Opus files are
+// sent as they are, while WAV and raw .pcm files are encoded at the guild's
+// volume. Only .dca files may be headerless DCA0.
+func (vm *VoiceManager) trackFrames(q *MusicQueue, track Track, stream io.Reader) *sniffingFrameReader {
+	encode := func(pcm io.Reader) (OpusFrameReader, error) {
+		if vm.Encoder == nil {
+			return nil, ErrNoEncoder
+		}
+		encoder, err := vm.Encoder()
+		if err != nil {
+			return nil, err
+		}
+		return NewPCMFrameReader(pcm, encoder, q.Volume), nil
+	}
+
+	return &sniffingFrameReader{
+		r:      bufio.NewReader(stream),
+		encode: encode,
+		raw:    strings.EqualFold(path.Ext(track.Location), ".pcm"),
+		dca:    strings.EqualFold(path.Ext(track.Location), ".dca"),
+	}
+}
+
+// notify posts a message about playback in a text channel
+func (vm *VoiceManager) notify(channelID, content string) {
+	if channelID == "" {
+		return
+	}
+	if _, err := vm.Bot.Session.ChannelMessageSend(channelID, content); err != nil {
+		logrus.Warnf("Error sending playback notice: %v", err)
+	}
+}
+
+// postNowPlaying posts the "now playing" message of a track, taking the
+// controls off the previous one
+func (vm *VoiceManager) postNowPlaying(q *MusicQueue, track Track, vc *VoiceConnection) {
+	vm.finishNowPlaying(q)
+	if track.TextChannelID == "" {
+		return
+	}
+
+	msg, err := vm.Bot.Session.ChannelMessageSendComplex(track.TextChannelID, &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{nowPlayingEmbed(track, q.Loop(), len(q.Tracks()))},
+		Components:      nowPlayingButtons(vc.IsPaused(), q.Loop()),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		logrus.Warnf("Error sending now playing message: %v", err)
+		return
+	}
+	q.setNowPlaying(msg)
+}
+
+// finishNowPlaying takes the controls off the current "now playing" message
+func (vm *VoiceManager) finishNowPlaying(q *MusicQueue) {
+	msg := q.setNowPlaying(nil)
+	if msg == nil {
+		return
+	}
+
+	edit := discordgo.NewMessageEdit(msg.ChannelID, msg.ID)
+	edit.Embeds = msg.Embeds
+	edit.Components = []discordgo.MessageComponent{}
+	if _, err := vm.Bot.Session.ChannelMessageEditComplex(edit); err != nil {
+		logrus.Warnf("Error updating now playing message: %v", err)
+	}
+}
+
+// nowPlayingEmbed describes the track that is playing
+func nowPlayingEmbed(track Track, loop LoopMode, upcoming int) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title:       "Now Playing",
+		Description: fmt.Sprintf("`%s`", track.Location),
+		Color:       0x00AAFF,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Requested by", Value: fmt.Sprintf("<@%s>", track.RequestedBy), Inline: true},
+			{Name: "Loop", Value: string(loop), Inline: true},
+			{Name: "Up next", Value: fmt.Sprintf("%d tracks", upcoming), Inline: true},
+		},
+	}
+}
+
+// nowPlayingButtons returns the playback controls on a "now playing" message
+func nowPlayingButtons(paused bool, loop LoopMode) []discordgo.MessageComponent {
+	pause := discordgo.Button{Label: "Pause", Style: discordgo.SecondaryButton, CustomID: "music:pause"}
+	if paused {
+		pause = discordgo.Button{Label: "Resume", Style: discordgo.SuccessButton, CustomID: "music:resume"}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				pause,
+				discordgo.Button{Label: "Skip", Style: discordgo.SecondaryButton, CustomID: "music:skip"},
+				discordgo.Button{Label: "Loop: " + string(loop), Style: discordgo.SecondaryButton, CustomID: "music:loop"},
+				discordgo.Button{Label: "Shuffle", Style: discordgo.SecondaryButton, CustomID: "music:shuffle"},
+			},
+		},
+	}
+}
+
+// nextLoopMode cycles through the loop modes for the loop button
+func nextLoopMode(mode LoopMode) LoopMode {
+	switch mode {
+	case LoopOff:
+		return LoopTrack
+	case LoopTrack:
+		return LoopQueue
+	default:
+		return LoopOff
+	}
+}
@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMusicQueueLoopModes(t *testing.T) {
+	tests := []struct {
+		loop LoopMode
+		want []string // Tracks played, in order
+	}{
+		{loop: LoopOff, want: []string{"a", "b"}},
+		{loop: LoopTrack, want: []string{"a", "a", "a", "a"}},
+		{loop: LoopQueue, want: []string{"a", "b", "a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.loop), func(t *testing.T) {
+			q := NewMusicQueue(testGuildID)
+			q.Enqueue(Track{Location: "a"})
+			q.Enqueue(Track{Location: "b"})
+			q.SetLoop(tt.loop)
+
+			var played []string
+			for len(played) < 4 {
+				track, ok := q.next()
+				if !ok {
+					break
+				}
+				played = append(played, track.Location)
+			}
+
+			if len(played) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, played)
+			}
+			for i := range played {
+				if played[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, played)
+				}
+			}
+		})
+	}
+}
+
+func TestMusicQueueSkipLeavesTrackLoop(t *testing.T) {
+	q := NewMusicQueue(testGuildID)
+	q.Enqueue(Track{Location: "a"})
+	q.Enqueue(Track{Location: "b"})
+	q.SetLoop(LoopTrack)
+
+	q.next()
+	q.skip()
+	if track, _ := q.next(); track.Location != "b" {
+		t.Errorf("expected skipping to move on, got %q", track.Location)
+	}
+	if track, _ := q.next(); track.Location != "b" {
+		t.Errorf("expected the new track to loop, got %q", track.Location)
+	}
+}
+
+func TestMusicQueueRemoveAndClear(t *testing.T) {
+	q := NewMusicQueue(testGuildID)
+	for _, location := range []string{"a", "b", "c"} {
+		q.Enqueue(Track{Location: location})
+	}
+
+	if _, ok := q.Remove(4); ok {
+		t.Error("expected removing past the end to fail")
+	}
+	if track, ok := q.Remove(2); !ok || track.Location != "b" {
+		t.Errorf("expected to remove b, got %q", track.Location)
+	}
+	if tracks := q.Tracks(); len(tracks) != 2 || tracks[1].Location != "c" {
+		t.Errorf("unexpected tracks after removal: %v", tracks)
+	}
+	if n := q.Clear(); n != 2 || len(q.Tracks()) != 0 {
+		t.Errorf("expected 2 tracks to be cleared, got %d", n)
+	}
+}
+
+// pipeAudio serves every track from a pipe, so tests control when a track
+// produces audio and when it ends
+type pipeAudio struct {
+	mu      sync.Mutex
+	writers map[string]*io.PipeWriter
+}
+
+// usePipeAudio makes a bot play tracks from pipes
+func usePipeAudio(b *Bot) *pipeAudio {
+	audio := &pipeAudio{writers: make(map[string]*io.PipeWriter)}
+	b.Voice.Source = AudioSourceFunc(func(_ context.Context, location string) (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		audio.mu.Lock()
+		audio.writers[location] = w
+		audio.mu.Unlock()
+		return r, nil
+	})
+	return audio
+}
+
+// track waits for a track to be opened and returns the writer feeding it
+func (a *pipeAudio) track(t *testing.T, location string) *io.PipeWriter {
+	t.Helper()
+
+	var w *io.PipeWriter
+	waitFor(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		w = a.writers[location]
+		return w != nil
+	})
+	return w
+}
+
+// controlCommand runs a prefix command as the test user
+func controlCommand(b *Bot, session *fakeSession, name string, args ...string) {
+	// Each call stands for a separate use, well apart from the last
+	resetCooldowns(b)
+	b.Commands.HandlePrefixCommand(session, newTestMessage("!"+name), name, args)
+}
+
+// lastMessage returns the content of the last reply, skipping "now playing" embeds
+func lastMessage(t *testing.T, session *fakeSession) string {
+	t.Helper()
+
+	messages := sentMessages(session)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Content != "" {
+			return messages[i].Content
+		}
+	}
+	t.Fatal("expected a reply to be sent")
+	return ""
+}
+
+// waitForPlayback waits until the bot is playing audio in the test guild
+func waitForPlayback(t *testing.T, b *Bot) {
+	t.Helper()
+
+	waitFor(t, func() bool {
+		vc, ok := b.Voice.Connection(testGuildID)
+		return ok && vc.IsPlaying()
+	})
+}
+
+func TestPlayQueuesSkipsAndPauses(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "play", "first.dca")
+	first := audio.track(t, "first.dca")
+	waitForPlayback(t, b)
+
+	controlCommand(b, session, "play", "second.dca")
+	if got := lastMessage(t, session); got != "Queued `second.dca` at position 1." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+
+	controlCommand(b, session, "pause")
+	if got := lastMessage(t, session); got != "Paused playback. Use `/resume` to continue." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+	io.WriteString(first, dcaFrames("frame")) // Playback waits after this frame
+
+	controlCommand(b, session, "resume")
+	if got := lastMessage(t, session); got != "Resumed playback." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+
+	controlCommand(b, session, "skip")
+	if got := lastMessage(t, session); got != "Skipped `first.dca`." {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+	// Playback notices the skip once it has the next frame, unless it
+	// already did when it resumed
+	io.WriteString(first, dcaFrames("frame"))
+
+	second := audio.track(t, "second.dca")
+	second.Close()
+
+	waitFor(t, func() bool {
+		_, playing := b.Voice.Queue(testGuildID).Current()
+		return !playing
+	})
+}
+
+func TestPlaybackControlsRequireListener(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "play", "first.dca")
+	defer audio.track(t, "first.dca").Close()
+	waitForPlayback(t, b)
+
+	msg := newTestMessage("!skip")
+	msg.Author.ID = "401"
+	b.Commands.HandlePrefixCommand(session, msg, "skip", nil)
+
+	if got := lastMessage(t, session); got != "You must be in <#310> to control playback." {
+		t.Errorf("unexpected reply: %q", got)
+	}
+	if _, playing := b.Voice.Queue(testGuildID).Current(); !playing {
+		t.Error("expected the track to keep playing")
+	}
+}
+
+func TestQueueSlashCommand(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "play", "first.dca")
+	defer audio.track(t, "first.dca").Close()
+	waitForPlayback(t, b)
+	controlCommand(b, session, "play", "second.dca")
+	controlCommand(b, session, "play", "third.dca")
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("queue", settingsOptions("view")))
+	embed := session.Responses()[0].Data.Embeds[0]
+	if embed.Description != "**Now playing:** `first.dca` (<@400>)\n\n1. `second.dca` (<@400>)\n2. `third.dca` (<@400>)" {
+		t.Errorf("unexpected queue: %q", embed.Description)
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("queue", settingsOptions("remove",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "position", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(1)},
+	)))
+	if got := session.Responses()[1].Data.Content; got != "Removed `second.dca` from the queue." {
+		t.Errorf("unexpected response: %q", got)
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("queue", settingsOptions("clear")))
+	if got := session.Responses()[2].Data.Content; got != "Removed 1 tracks from the queue." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestMusicLoopButton(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, voicePermissions)
+	addTestVoiceChannel(t, session)
+	audio := usePipeAudio(b)
+
+	controlCommand(b, session, "play", "first.dca")
+	defer audio.track(t, "first.dca").Close()
+	waitForPlayback(t, b)
+
+	b.Components.Dispatch(session, newTestComponentInteraction("music:loop", &discordgo.Message{ID: testMessageID, ChannelID: testChannelID}))
+
+	if loop := b.Voice.Queue(testGuildID).Loop(); loop != LoopTrack {
+		t.Errorf("expected the loop button to loop the track, got %q", loop)
+	}
+	resp := session.Responses()[0]
+	if resp.Type != discordgo.InteractionResponseUpdateMessage {
+		t.Fatalf("expected the message to be updated, got %+v", resp)
+	}
+	button := resp.Data.Components[0].(discordgo.ActionsRow).Components[2].(discordgo.Button)
+	if button.Label != "Loop: track" {
+		t.Errorf("unexpected loop button: %+v", button)
+	}
+}
@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// genericErrorMessage is shown to users when a handler fails
+const genericErrorMessage = "Something went wrong while running this command. The error has been logged."
+
+// CommandErrorRecorder persists command and event handler failures
+type CommandErrorRecorder interface {
+	LogCommandError(guildID, channelID, userID, commandName, commandType, errorMessage, stack string) error
+}
+
+// PanicError is returned by RecoveryMiddleware when a handler panics
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// RecoveryMiddleware converts a panic in a command handler into a *PanicError
+func RecoveryMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: string(debug.Stack())}
+				}
+			}()
+
+			return next(ctx)
+		}
+	}
+}
+
+// ErrorMiddleware logs and records failed commands and tells the user
+// something went wrong if the handler didn't reply itself
+func ErrorMiddleware(recorder CommandErrorRecorder) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx *CommandContext) error {
+			err := next(ctx)
+			if err == nil {
+				return nil
+			}
+
+			stack := ""
+			if panicErr, ok := err.(*PanicError); ok {
+				stack = panicErr.Stack
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"command":      ctx.Name,
+				"command_type": ctx.Type,
+				"guild_id":     ctx.GuildID,
+				"channel_id":   ctx.ChannelID,
+				"user_id":      ctx.UserID,
+				"invocation":   ctx.InvocationID(),
+			}).Errorf("Command failed: %v\n%s", err, stack)
+
+			if recordErr := recorder.LogCommandError(ctx.GuildID, ctx.ChannelID, ctx.UserID, ctx.Name, ctx.Type, err.Error(), stack); recordErr != nil {
+				logrus.Errorf("Error recording command failure: %v", recordErr)
+			}
+
+			// Let the user know, unless they already got a reply
+			var replyErr error
+			if _, responded := ctx.responseState(); !responded {
+				// Respond fills in a deferred response
+				replyErr = ctx.replyEphemeral(genericErrorMessage)
+			}
+			if replyErr != nil {
+				logrus.Warnf("Error sending failure reply: %v", replyErr)
+			}
+
+			// The failure has been handled
+			return nil
+		}
+	}
+}
+
+// safeHandler wraps a gateway event handler so a panic is logged instead
+// of crashing the bot
+func safeHandler[T any](b *Bot, name string, handler func(*discordgo.Session, T)) func(*discordgo.Session, T) {
+	return func(s *discordgo.Session, event T) {
+		defer b.recoverEvent(name, event)
+		handler(s, event)
+	}
+}
+
+// recoverEvent recovers from a panic in an event handler. It must be deferred.
+func (b *Bot) recoverEvent(name string, event interface{}) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := string(debug.Stack())
+	fields := eventFields(event)
+	logrus.WithFields(fields).Errorf("Panic in %s handler: %v\n%s", name, r, stack)
+
+	i, ok := event.(*discordgo.InteractionCreate)
+	if !ok {
+		return
+	}
+
+	guildID, _ := fields["guild_id"].(string)
+	channelID, _ := fields["channel_id"].(string)
+	userID, _ := fields["user_id"].(string)
+	commandName, _ := fields["command"].(string)
+	if commandName == "" {
+		commandName = name
+	}
+
+	if err := b.ErrorLog.LogCommandError(guildID, channelID, userID, commandName, "event", fmt.Sprint(r), stack); err != nil {
+		logrus.Errorf("Error recording handler failure: %v", err)
+	}
+
+	// This fails harmlessly if the interaction was already answered
+	err := b.Session.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: genericErrorMessage,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.Debugf("Could not send failure reply: %v", err)
+	}
+}
+
+// eventFields extracts log context from a gateway event
+func eventFields(event interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+
+	switch e := event.(type) {
+	case *discordgo.InteractionCreate:
+		fields["interaction_id"] = e.ID
+		fields["interaction_type"] = e.Type.String()
+		fields["guild_id"] = e.GuildID
+		fields["channel_id"] = e.ChannelID
+		fields["user_id"] = interactionUserID(e.Interaction)
+		if data, ok := e.Data.(discordgo.ApplicationCommandInteractionData); ok {
+			fields["command"] = data.Name
+		}
+	case *discordgo.MessageCreate:
+		fields["message_id"] = e.ID
+		fields["guild_id"] = e.GuildID
+		fields["channel_id"] = e.ChannelID
+		if e.Author != nil {
+			fields["user_id"] = e.Author.ID
+		}
+	case *discordgo.GuildCreate:
+		fields["guild_id"] = e.ID
+	case *discordgo.GuildDelete:
+		fields["guild_id"] = e.ID
+	}
+
+	return fields
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestErrorMiddlewareRepliesAndRecords(t *testing.T) {
+	b, session := newTestBot()
+	b.Commands.SlashCommands["explode"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{Name: "explode", Description: "Panics"},
+		Run: func(ctx *CommandContext) error {
+			var m map[string]string
+			m["boom"] = "boom"
+			return nil
+		},
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("explode"))
+
+	resp := session.Responses()
+	if len(resp) != 1 || resp[0].Data.Content != genericErrorMessage || resp[0].Data.Flags != discordgo.MessageFlagsEphemeral {
+		t.Fatalf("expected a generic ephemeral error, got %v", resp)
+	}
+
+	errorLog := b.ErrorLog.(*memoryErrorLog)
+	if len(errorLog.errors) != 1 {
+		t.Fatalf("expected the failure to be recorded, got %v", errorLog.errors)
+	}
+}
+
+func TestSafeHandlerRecoversEventPanics(t *testing.T) {
+	b, session := newTestBot()
+
+	handler := safeHandler(b, "interaction create", func(_ *discordgo.Session, i *discordgo.InteractionCreate) {
+		panic("handler bug")
+	})
+	handler(nil, newTestInteraction("info"))
+
+	resp := session.Responses()
+	if len(resp) != 1 || resp[0].Data.Content != genericErrorMessage {
+		t.Fatalf("expected a generic error reply, got %v", resp)
+	}
+	if got := b.ErrorLog.(*memoryErrorLog).errors; len(got) != 1 || got[0] != "info: handler bug" {
+		t.Errorf("unexpected recorded errors: %v", got)
+	}
+}
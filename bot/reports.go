@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reportColor is the color of message report embeds
+const reportColor = 0xE74C3C
+
+// maxReportExcerpt is how much of a reported message's content is quoted
+// in the report, in characters
+const maxReportExcerpt = 1000
+
+// ReportChannelStore persists the channel each guild's message reports are
+// posted in
+type ReportChannelStore interface {
+	GetReportChannel(guildID string) (string, error)
+	SaveReportChannel(guildID, channelID string) error
+}
+
+// describeReportChannel describes where message reports go in a sentence
+func describeReportChannel(channelID string) string {
+	if channelID == "" {
+		return "Reported messages aren't posted anywhere. Pick a channel with `/settings reports channel:`."
+	}
+	return fmt.Sprintf("Reported messages are posted in <#%s>.", channelID)
+}
+
+// messageLink returns the URL that jumps to a message
+func messageLink(guildID, channelID, messageID string) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// reportExcerpt returns the start of a reported message's content
+func reportExcerpt(msg *discordgo.Message) string {
+	content := strings.TrimSpace(msg.Content)
+	if utf8.RuneCountInString(content) > maxReportExcerpt {
+		content = string([]rune(content)[:maxReportExcerpt]) + "…"
+	}
+	if content == "" {
+		content = "*No text content*"
+	}
+	if len(msg.Attachments) > 0 {
+		content += fmt.Sprintf("\n\n%d attachment(s)", len(msg.Attachments))
+	}
+	return content
+}
+
+// reportEmbed describes a reported message for the moderators
+func reportEmbed(guildID, reporterID string, msg *discordgo.Message) *discordgo.MessageEmbed {
+	author := "Unknown"
+	if msg.Author != nil {
+		author = fmt.Sprintf("<@%s>", msg.Author.ID)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Message reported",
+		URL:         messageLink(guildID, msg.ChannelID, msg.ID),
+		Description: reportExcerpt(msg),
+		Color:       reportColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Author", Value: author, Inline: true},
+			{Name: "Reported by", Value: fmt.Sprintf("<@%s>", reporterID), Inline: true},
+			{Name: "Channel", Value: fmt.Sprintf("<#%s>", msg.ChannelID), Inline: true},
+		},
+	}
+}
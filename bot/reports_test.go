@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestReportMessageWithoutReportChannel(t *testing.T) {
+	b, session := newTestBot()
+
+	b.Commands.HandleSlashCommand(session, newTestContextMenu("Report Message", "800", &discordgo.ApplicationCommandInteractionDataResolved{
+		Messages: map[string]*discordgo.Message{"800": {ID: "800", Content: "spam", Author: &discordgo.User{ID: "700"}}},
+	}))
+
+	if reports := session.Calls("ChannelMessageSendEmbed"); len(reports) != 0 {
+		t.Errorf("expected no report to be posted, got %v", reports)
+	}
+	if got := session.Responses()[0].Data.Content; !strings.HasPrefix(got, "This server has no channel for reports, so the moderators weren't notified.") {
+		t.Errorf("unexpected response %q", got)
+	}
+}
+
+func TestReportExcerptIsShortened(t *testing.T) {
+	excerpt := reportExcerpt(&discordgo.Message{Content: strings.Repeat("é", maxReportExcerpt+1)})
+	if excerpt != strings.Repeat("é", maxReportExcerpt)+"…" {
+		t.Errorf("expected the excerpt to be cut at %d characters, got %d", maxReportExcerpt, len([]rune(excerpt)))
+	}
+
+	if excerpt := reportExcerpt(&discordgo.Message{Attachments: []*discordgo.MessageAttachment{{}}}); excerpt != "*No text content*\n\n1 attachment(s)" {
+		t.Errorf("unexpected excerpt %q", excerpt)
+	}
+}
+
+func TestSettingsReports(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionSendMessages|discordgo.PermissionEmbedLinks)
+	guild, _ := session.State.Guild(testGuildID)
+	guild.OwnerID = testUserID
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("reports",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: testChannelID},
+	)))
+	if got := session.Responses()[0].Data.Content; got != "Reported messages are posted in <#300>." {
+		t.Errorf("unexpected response: %q", got)
+	}
+	if channelID, _ := b.Reports.GetReportChannel(testGuildID); channelID != testChannelID {
+		t.Errorf("expected the report channel to be saved, got %q", channelID)
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("reports",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "off", Type: discordgo.ApplicationCommandOptionBoolean, Value: true},
+	)))
+	if channelID, _ := b.Reports.GetReportChannel(testGuildID); channelID != "" {
+		t.Errorf("expected reports to be turned off, got %q", channelID)
+	}
+}
@@ -0,0 +1,273 @@
+package bot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrAlreadyResponded is returned when a command is responded to twice
+var ErrAlreadyResponded = errors.New("command has already been responded to, use FollowUp to send more messages")
+
+// FollowUp sends an additional message after the command was responded to
+// or deferred. For prefix commands it sends another channel message.
+func (ctx *CommandContext) FollowUp(resp *CommandResponse) (*discordgo.Message, error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.Interaction == nil {
+		msg, err := ctx.session().ChannelMessageSendComplex(ctx.ChannelID, &discordgo.MessageSend{
+			Content:    resp.Content,
+			Embeds:     resp.Embeds,
+			Components: resp.Components,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ctx.responded = true
+		return msg, nil
+	}
+
+	var flags discordgo.MessageFlags
+	if resp.Ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	return ctx.followUpLocked(&discordgo.WebhookParams{
+		Content:    resp.Content,
+		Embeds:     resp.Embeds,
+		Components: resp.Components,
+		Flags:      flags,
+	})
+}
+
+// DeleteResponse deletes the reply that was already sent
+func (ctx *CommandContext) DeleteResponse() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.Interaction == nil {
+		if ctx.reply == nil {
+			return ErrNoResponse
+		}
+		if err := ctx.session().ChannelMessageDelete(ctx.reply.ChannelID, ctx.reply.ID); err != nil {
+			return err
+		}
+		ctx.reply = nil
+		return nil
+	}
+
+	return ctx.deleteInteractionLocked()
+}
+
+// responseState reports whether the command was deferred and whether it was responded to
+func (ctx *CommandContext) responseState() (deferred, responded bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.deferred, ctx.responded
+}
+
+// session returns the session responses are sent through, bypassing the
+// tracking wrapper installed by trackResponses
+func (ctx *CommandContext) session() Session {
+	if ctx.api != nil {
+		return ctx.api
+	}
+	return ctx.Session
+}
+
+// trackResponses wraps ctx.Session so responses that handlers send directly
+// through the session, as legacy handlers do, update the context too
+func (ctx *CommandContext) trackResponses() {
+	if ctx.api != nil {
+		return
+	}
+	ctx.api = ctx.Session
+	ctx.Session = &responderSession{Session: ctx.api, ctx: ctx}
+}
+
+// deferAfter defers the response if the handler hasn't replied once budget
+// has passed, so slow handlers don't fail with "interaction failed". The
+// returned function cancels the timer.
+func (ctx *CommandContext) deferAfter(budget time.Duration) func() bool {
+	timer := time.AfterFunc(budget, func() {
+		if err := ctx.Defer(false); err != nil {
+			logrus.Warnf("Error deferring response to %s: %v", ctx.Name, err)
+		}
+	})
+	return timer.Stop
+}
+
+// respondInteraction sends the initial response to the interaction. Once
+// the interaction has been deferred, a message response fills in the
+// deferred response instead.
+func (ctx *CommandContext) respondInteraction(resp *discordgo.InteractionResponse) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.responded {
+		return ErrAlreadyResponded
+	}
+
+	switch resp.Type {
+	case discordgo.InteractionResponseDeferredChannelMessageWithSource, discordgo.InteractionResponseDeferredMessageUpdate:
+		if ctx.deferred {
+			return nil
+		}
+		if err := ctx.session().InteractionRespond(ctx.Interaction.Interaction, resp); err != nil {
+			return err
+		}
+		ctx.deferred = true
+		ctx.publicWait = resp.Type == discordgo.InteractionResponseDeferredChannelMessageWithSource && !isEphemeral(resp.Data)
+		return nil
+
+	case discordgo.InteractionResponseChannelMessageWithSource, discordgo.InteractionResponseUpdateMessage:
+		if ctx.publicWait && isEphemeral(resp.Data) {
+			return ctx.replaceDeferredLocked(resp.Data)
+		}
+		if ctx.deferred {
+			edit := &discordgo.WebhookEdit{}
+			if resp.Data != nil {
+				edit.Content = &resp.Data.Content
+				if resp.Data.Embeds != nil {
+					edit.Embeds = &resp.Data.Embeds
+				}
+				if resp.Data.Components != nil {
+					edit.Components = &resp.Data.Components
+				}
+			}
+			_, err := ctx.editInteractionLocked(edit)
+			return err
+		}
+
+	case discordgo.InteractionResponseModal:
+		if ctx.deferred {
+			return ErrModalNotFirst
+		}
+	}
+
+	if err := ctx.session().InteractionRespond(ctx.Interaction.Interaction, resp); err != nil {
+		return err
+	}
+	ctx.responded = true
+	if resp.Data != nil && resp.Type != discordgo.InteractionResponseModal {
+		ctx.components = resp.Data.Components
+	}
+	return nil
+}
+
+// replaceDeferredLocked sends an ephemeral reply to a command whose response
+// was deferred publicly. Editing the deferred response would show the reply
+// to the whole channel, so it is sent as an ephemeral follow-up and the
+// deferred response is deleted. ctx.mu must be held.
+func (ctx *CommandContext) replaceDeferredLocked(data *discordgo.InteractionResponseData) error {
+	if _, err := ctx.followUpLocked(&discordgo.WebhookParams{
+		Content:    data.Content,
+		Embeds:     data.Embeds,
+		Components: data.Components,
+		Flags:      discordgo.MessageFlagsEphemeral,
+	}); err != nil {
+		return err
+	}
+
+	if err := ctx.deleteInteractionLocked(); err != nil {
+		logrus.Warnf("Error deleting deferred response to %s: %v", ctx.Name, err)
+	}
+	ctx.publicWait = false
+	return nil
+}
+
+// isEphemeral reports whether response data is only shown to the invoker
+func isEphemeral(data *discordgo.InteractionResponseData) bool {
+	return data != nil && data.Flags&discordgo.MessageFlagsEphemeral != 0
+}
+
+// editInteractionLocked edits the original interaction response. ctx.mu must be held.
+func (ctx *CommandContext) editInteractionLocked(edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	if !ctx.deferred && !ctx.responded {
+		return nil, ErrNoResponse
+	}
+
+	msg, err := ctx.session().InteractionResponseEdit(ctx.Interaction.Interaction, edit)
+	if err != nil {
+		return nil, err
+	}
+	ctx.responded = true
+	if edit.Components != nil {
+		ctx.components = *edit.Components
+	}
+	return msg, nil
+}
+
+// followUpLocked sends a follow-up message to the interaction. ctx.mu must be held.
+func (ctx *CommandContext) followUpLocked(params *discordgo.WebhookParams) (*discordgo.Message, error) {
+	if !ctx.deferred && !ctx.responded {
+		return nil, ErrNoResponse
+	}
+
+	msg, err := ctx.session().FollowupMessageCreate(ctx.Interaction.Interaction, true, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx.responded = true
+	return msg, nil
+}
+
+// deleteInteractionLocked deletes the original interaction response. ctx.mu must be held.
+func (ctx *CommandContext) deleteInteractionLocked() error {
+	if !ctx.deferred && !ctx.responded {
+		return ErrNoResponse
+	}
+	return ctx.session().InteractionResponseDelete(ctx.Interaction.Interaction)
+}
+
+// responderSession routes responses to the context's own interaction
+// through the context, so its response state stays accurate
+type responderSession struct {
+	Session
+	ctx *CommandContext
+}
+
+// ownsInteraction reports whether an interaction is the one the context is handling
+func (s *responderSession) ownsInteraction(interaction *discordgo.Interaction) bool {
+	return s.ctx.Interaction != nil && interaction != nil && interaction.ID == s.ctx.Interaction.ID
+}
+
+func (s *responderSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	if !s.ownsInteraction(interaction) {
+		return s.Session.InteractionRespond(interaction, resp, options...)
+	}
+	return s.ctx.respondInteraction(resp)
+}
+
+func (s *responderSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if !s.ownsInteraction(interaction) {
+		return s.Session.InteractionResponseEdit(interaction, newresp, options...)
+	}
+
+	s.ctx.mu.Lock()
+	defer s.ctx.mu.Unlock()
+	return s.ctx.editInteractionLocked(newresp)
+}
+
+func (s *responderSession) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	if !s.ownsInteraction(interaction) {
+		return s.Session.InteractionResponseDelete(interaction, options...)
+	}
+
+	s.ctx.mu.Lock()
+	defer s.ctx.mu.Unlock()
+	return s.ctx.deleteInteractionLocked()
+}
+
+func (s *responderSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if !s.ownsInteraction(interaction) {
+		return s.Session.FollowupMessageCreate(interaction, wait, data, options...)
+	}
+
+	s.ctx.mu.Lock()
+	defer s.ctx.mu.Unlock()
+	return s.ctx.followUpLocked(data)
+}
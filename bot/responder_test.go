@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newTestSlashContext builds a tracked context for a slash command invocation
+func newTestSlashContext(session Session) *CommandContext {
+	ctx := &CommandContext{Session: session, Name: "test", Interaction: newTestInteraction("test")}
+	ctx.trackResponses()
+	return ctx
+}
+
+func TestRespondTwiceIsAnError(t *testing.T) {
+	_, session := newTestBot()
+	ctx := newTestSlashContext(session)
+
+	if err := ctx.Reply("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Reply("second"); !errors.Is(err, ErrAlreadyResponded) {
+		t.Errorf("expected ErrAlreadyResponded, got %v", err)
+	}
+
+	// Legacy handlers responding through the session are tracked too
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+	})
+	if !errors.Is(err, ErrAlreadyResponded) {
+		t.Errorf("expected ErrAlreadyResponded from the session, got %v", err)
+	}
+
+	if responses := session.Responses(); len(responses) != 1 {
+		t.Errorf("expected 1 response to reach Discord, got %d", len(responses))
+	}
+}
+
+func TestSlowSlashCommandIsDeferred(t *testing.T) {
+	b, session := newTestBot()
+	b.Config.DeferAfter = 10 * time.Millisecond
+
+	b.Commands.SlashCommands["slow"] = SlashCommand{
+		Command: &discordgo.ApplicationCommand{Name: "slow", Description: "Takes a while"},
+		Handler: func(s Session, i *discordgo.InteractionCreate) {
+			time.Sleep(50 * time.Millisecond)
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{Content: "Done"},
+			})
+		},
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("slow"))
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("expected a single deferred response, got %+v", responses)
+	}
+
+	edits := session.Calls("InteractionResponseEdit")
+	if len(edits) != 1 || *edits[0].Args[1].(*discordgo.WebhookEdit).Content != "Done" {
+		t.Errorf("expected the deferred response to be filled in, got %+v", edits)
+	}
+}
+
+func TestEphemeralReplyAfterDeferStaysEphemeral(t *testing.T) {
+	b, session := newTestBot()
+	b.Config.DeferAfter = 10 * time.Millisecond
+
+	b.Commands.RegisterCommand(Command{
+		Name:        "slow",
+		Description: "Takes a while",
+		Handler: func(ctx *CommandContext) error {
+			time.Sleep(50 * time.Millisecond)
+			return ctx.replyEphemeral("Only for you")
+		},
+	})
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("slow"))
+
+	if edits := session.Calls("InteractionResponseEdit"); len(edits) != 0 {
+		t.Errorf("expected the public deferred response not to be filled in, got %+v", edits)
+	}
+	followUps := session.Calls("FollowupMessageCreate")
+	if len(followUps) != 1 {
+		t.Fatalf("expected the reply as a follow-up, got %d", len(followUps))
+	}
+	if params := followUps[0].Args[1].(*discordgo.WebhookParams); params.Flags != discordgo.MessageFlagsEphemeral || params.Content != "Only for you" {
+		t.Errorf("expected an ephemeral follow-up, got %+v", params)
+	}
+	if deletes := session.Calls("InteractionResponseDelete"); len(deletes) != 1 {
+		t.Errorf("expected the deferred response to be deleted, got %d deletes", len(deletes))
+	}
+}
+
+func TestFastSlashCommandIsNotDeferred(t *testing.T) {
+	b, session := newTestBot()
+	b.Config.DeferAfter = time.Second
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("info"))
+	time.Sleep(10 * time.Millisecond)
+
+	responses := session.Responses()
+	if len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseChannelMessageWithSource {
+		t.Errorf("expected a direct response, got %+v", responses)
+	}
+}
+
+func TestFollowUp(t *testing.T) {
+	_, session := newTestBot()
+	ctx := newTestSlashContext(session)
+
+	if _, err := ctx.FollowUp(&CommandResponse{Content: "early"}); !errors.Is(err, ErrNoResponse) {
+		t.Errorf("expected ErrNoResponse before responding, got %v", err)
+	}
+
+	if err := ctx.Defer(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.FollowUp(&CommandResponse{Content: "more", Ephemeral: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	followUps := session.Calls("FollowupMessageCreate")
+	if len(followUps) != 1 {
+		t.Fatalf("expected 1 follow-up, got %d", len(followUps))
+	}
+	if params := followUps[0].Args[1].(*discordgo.WebhookParams); params.Flags != discordgo.MessageFlagsEphemeral {
+		t.Errorf("expected an ephemeral follow-up, got %+v", params)
+	}
+}
+
+func TestDeleteResponse(t *testing.T) {
+	_, session := newTestBot()
+
+	prefixCtx := &CommandContext{Session: session, ChannelID: testChannelID, Message: newTestMessage("!test")}
+	if err := prefixCtx.DeleteResponse(); !errors.Is(err, ErrNoResponse) {
+		t.Errorf("expected ErrNoResponse, got %v", err)
+	}
+	if err := prefixCtx.Reply("bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefixCtx.DeleteResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	slashCtx := newTestSlashContext(session)
+	if err := slashCtx.Reply("bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := slashCtx.DeleteResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	if deletes := session.Calls("ChannelMessageDelete"); len(deletes) != 1 {
+		t.Errorf("expected the prefix reply to be deleted, got %d deletes", len(deletes))
+	}
+	if deletes := session.Calls("InteractionResponseDelete"); len(deletes) != 1 {
+		t.Errorf("expected the slash response to be deleted, got %d deletes", len(deletes))
+	}
+}
@@ -0,0 +1,324 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Role change actions recorded in the role audit log
+const (
+	RoleActionAdd    = "add"
+	RoleActionRemove = "remove"
+	RoleActionExpire = "expire" // A temporary role was removed by the bot
+)
+
+// RoleAuditRecorder persists role changes made through the bot
+type RoleAuditRecorder interface {
+	LogRoleChange(guildID, actorID, userID, roleID, action string) error
+}
+
+// TempRoleStore persists temporary roles so they are removed on time, even
+// if the bot restarts in between
+type TempRoleStore interface {
+	AddTempRole(guildID, userID, roleID string, expiresAt time.Time) error
+	DeleteTempRole(guildID, userID, roleID string) error
+	GetExpiredTempRoles(now time.Time, limit int) ([]database.TempRole, error)
+}
+
+const (
+	// tempRolePollInterval is how often expired temporary roles are removed
+	tempRolePollInterval = 30 * time.Second
+
+	// tempRoleBatchLimit caps the temporary roles removed per poll
+	tempRoleBatchLimit = 100
+
+	// Bounds for temporary role durations
+	minTempRoleDuration = time.Minute
+	maxTempRoleDuration = 365 * 24 * time.Hour
+)
+
+// roleDurationPattern matches durations such as 30m, 12h, 7d or 1w2d
+var roleDurationPattern = regexp.MustCompile(`^(\d+[smhdw])+$`)
+
+// roleDurationUnits maps duration suffixes to their length
+var roleDurationUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseRoleDuration parses how long a temporary role is kept. Besides the
+// units time.ParseDuration knows, it accepts days (d) and weeks (w).
+func parseRoleDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	if !roleDurationPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var total time.Duration
+	start := 0
+	for i := 0; i < len(value); i++ {
+		unit, ok := roleDurationUnits[value[i]]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value[start:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		total += time.Duration(n) * unit
+		start = i + 1
+
+		if total > maxTempRoleDuration {
+			return 0, fmt.Errorf("duration %q is longer than %d days", value, maxTempRoleDuration/(24*time.Hour))
+		}
+	}
+
+	if total < minTempRoleDuration {
+		return 0, fmt.Errorf("duration %q is shorter than a minute", value)
+	}
+	return total, nil
+}
+
+// tempRoleExpirer periodically removes temporary roles that have expired
+func (b *Bot) tempRoleExpirer() {
+	// Catch up on roles that expired while the bot was offline
+	b.expireTempRoles(time.Now())
+
+	ticker := time.NewTicker(tempRolePollInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		b.expireTempRoles(now)
+	}
+}
+
+// expireTempRoles removes temporary roles that expired by now. Roles that
+// can't be removed yet are retried on the next poll.
+func (b *Bot) expireTempRoles(now time.Time) {
+	roles, err := b.TempRoles.GetExpiredTempRoles(now, tempRoleBatchLimit)
+	if err != nil {
+		logrus.Errorf("Error getting expired temporary roles: %v", err)
+		return
+	}
+
+	for _, role := range roles {
+		removeErr := b.Session.GuildMemberRoleRemove(role.GuildID, role.UserID, role.RoleID)
+		if removeErr != nil && !isUnknownEntity(removeErr) {
+			logrus.Warnf("Error removing expired role %s from %s in guild %s: %v", role.RoleID, role.UserID, role.GuildID, removeErr)
+			continue
+		}
+
+		// The member, role or guild may be gone already, in which case there
+		// is nothing left to remove
+		if err := b.TempRoles.DeleteTempRole(role.GuildID, role.UserID, role.RoleID); err != nil {
+			logrus.Errorf("Error deleting expired temporary role: %v", err)
+		}
+		if removeErr != nil {
+			continue
+		}
+
+		if err := b.RoleAudit.LogRoleChange(role.GuildID, b.Session.BotUserID(), role.UserID, role.RoleID, RoleActionExpire); err != nil {
+			logrus.Errorf("Error recording role change: %v", err)
+		}
+	}
+}
+
+// isUnknownEntity reports whether a Discord API error means the member,
+// role or guild no longer exists
+func isUnknownEntity(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+
+	switch restErr.Message.Code {
+	case discordgo.ErrCodeUnknownMember, discordgo.ErrCodeUnknownRole, discordgo.ErrCodeUnknownGuild, discordgo.ErrCodeUnknownUser:
+		return true
+	}
+	return false
+}
+
+// highestRolePosition returns the position of a member's highest role.
+// Members without roles are at the position of @everyone, which is 0.
+func highestRolePosition(s Session, guildID string, member *discordgo.Member) int {
+	highest := 0
+	for _, roleID := range member.Roles {
+		role, err := s.StateRole(guildID, roleID)
+		if err != nil {
+			continue
+		}
+		if role.Position > highest {
+			highest = role.Position
+		}
+	}
+	return highest
+}
+
+// roleAssignError explains why a role can't be given to or taken from
+// members by actor through the bot. It returns an empty string if it can.
+func roleAssignError(s Session, guildID string, actor *discordgo.Member, role *discordgo.Role) (string, error) {
+	if role.ID == guildID {
+		return "The @everyone role can't be assigned.", nil
+	}
+	if role.Managed {
+		return fmt.Sprintf("<@&%s> is managed by an integration and can't be assigned manually.", role.ID), nil
+	}
+
+	guild, err := s.StateGuild(guildID)
+	if err != nil {
+		return "", fmt.Errorf("error getting guild: %w", err)
+	}
+
+	// The server owner can manage every role
+	if actor.User.ID != guild.OwnerID {
+		if highestRolePosition(s, guildID, actor) <= role.Position {
+			return fmt.Sprintf("You can only manage roles below your highest role, and <@&%s> isn't.", role.ID), nil
+		}
+	}
+
+	bot, err := s.StateMember(guildID, s.BotUserID())
+	if err != nil {
+		return "", fmt.Errorf("error getting bot member: %w", err)
+	}
+	if highestRolePosition(s, guildID, bot) <= role.Position {
+		return fmt.Sprintf("I can only manage roles below my highest role. Move my role above <@&%s> and try again.", role.ID), nil
+	}
+
+	return "", nil
+}
+
+// Mass role member filters
+const (
+	MassRoleAllMembers = "all"
+	MassRoleHumans     = "humans"
+	MassRoleBots       = "bots"
+)
+
+const (
+	// massRoleBatchSize is how many members are updated between progress reports
+	massRoleBatchSize = 10
+
+	// guildMembersPageSize is the most members the API lists per request
+	guildMembersPageSize = 1000
+)
+
+// massRoleBatchDelay spaces out batches of a mass role update, so it
+// leaves room in the rate limit for the bot's other requests
+var massRoleBatchDelay = time.Second
+
+// massRoleHandoffAfter is how long a mass role update reports progress in
+// its interaction response. The token for it expires after 15 minutes, so
+// longer runs move to a channel message well before then.
+var massRoleHandoffAfter = 10 * time.Minute
+
+// massRoleReporter reports the progress of a mass role update
+type massRoleReporter struct {
+	ctx     *CommandContext
+	started time.Time
+	message *discordgo.Message // Channel message progress moved to, if any
+}
+
+// report shows the progress or the result of the update
+func (r *massRoleReporter) report(content string) error {
+	if r.message != nil {
+		_, err := r.ctx.Session.ChannelMessageEdit(r.message.ChannelID, r.message.ID, content)
+		return err
+	}
+	if time.Since(r.started) < massRoleHandoffAfter {
+		return r.ctx.Edit(&CommandResponse{Content: content})
+	}
+
+	msg, err := r.ctx.Session.ChannelMessageSend(r.ctx.ChannelID, content)
+	if err != nil {
+		return err
+	}
+	r.message = msg
+
+	// The token still works, so point the response at the new message
+	if err := r.ctx.Edit(&CommandResponse{Content: "This is taking a while, so progress continues below."}); err != nil {
+		logrus.Warnf("Error updating mass role response: %v", err)
+	}
+	return nil
+}
+
+// massRoleFilter selects the members a mass role update applies to
+type massRoleFilter struct {
+	HasRole     string    // Only members with this role, if set
+	JoinedAfter time.Time // Only members who joined after this, if set
+	Members     string    // MassRoleAllMembers, MassRoleHumans or MassRoleBots
+}
+
+// matches reports whether a member passes the filter
+func (f massRoleFilter) matches(member *discordgo.Member) bool {
+	switch f.Members {
+	case MassRoleHumans:
+		if member.User.Bot {
+			return false
+		}
+	case MassRoleBots:
+		if !member.User.Bot {
+			return false
+		}
+	}
+
+	if f.HasRole != "" && !memberHasRole(member, f.HasRole) {
+		return false
+	}
+	if !f.JoinedAfter.IsZero() && !member.JoinedAt.After(f.JoinedAfter) {
+		return false
+	}
+	return true
+}
+
+// memberHasRole reports whether a member has a role
+func memberHasRole(member *discordgo.Member, roleID string) bool {
+	for _, id := range member.Roles {
+		if id == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchGuildMembers lists every member of a guild, a page at a time. It
+// needs the Server Members intent to be enabled for the bot.
+func fetchGuildMembers(s Session, guildID string) ([]*discordgo.Member, error) {
+	var members []*discordgo.Member
+	after := ""
+	for {
+		page, err := s.GuildMembers(guildID, after, guildMembersPageSize)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+
+		if len(page) < guildMembersPageSize {
+			return members, nil
+		}
+		after = page[len(page)-1].User.ID
+	}
+}
+
+// retryRateLimited calls fn, waiting out a rate limit and trying once more
+// if it hits one
+func retryRateLimited(fn func() error) error {
+	err := fn()
+
+	var rateLimit *discordgo.RateLimitError
+	if errors.As(err, &rateLimit) {
+		time.Sleep(rateLimit.RetryAfter)
+		err = fn()
+	}
+	return err
+}
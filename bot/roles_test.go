@@ -0,0 +1,297 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseRoleDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "30m", want: 30 * time.Minute},
+		{value: "12h", want: 12 * time.Hour},
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "1w 2d", want: 9 * 24 * time.Hour},
+		{value: "1H30M", want: 90 * time.Minute},
+		{value: "30s", err: true},
+		{value: "366d", err: true},
+		{value: "soon", err: true},
+		{value: "5", err: true},
+		{value: "", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRoleDuration(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseRoleDuration(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseRoleDuration(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestRoleSlashCommandAddsTemporaryRole(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	options := roleOptions("add")
+	options.Options = append(options.Options, &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "duration", Type: discordgo.ApplicationCommandOptionString, Value: "2h",
+	})
+
+	before := time.Now()
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", options))
+
+	roles := b.TempRoles.(*memoryTempRoles).roles
+	if len(roles) != 1 {
+		t.Fatalf("expected 1 temporary role, got %v", roles)
+	}
+	if expires := roles[0].ExpiresAt.Sub(before); expires < 2*time.Hour || expires > 2*time.Hour+time.Minute {
+		t.Errorf("expected the role to expire in 2 hours, expires in %v", expires)
+	}
+
+	want := fmt.Sprintf("Added role <@&500> to <@400> until <t:%d:f>", roles[0].ExpiresAt.Unix())
+	if got := session.Responses()[0].Data.Content; got != want {
+		t.Errorf("unexpected response: %q", got)
+	}
+
+	// Adding the role again without a duration makes it permanent
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", roleOptions("add")))
+	if roles := b.TempRoles.(*memoryTempRoles).roles; len(roles) != 0 {
+		t.Errorf("expected the expiry to be cancelled, got %v", roles)
+	}
+}
+
+func TestRoleSlashCommandRejectsInvalidDuration(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	options := roleOptions("add")
+	options.Options = append(options.Options, &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "duration", Type: discordgo.ApplicationCommandOptionString, Value: "forever",
+	})
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", options))
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 0 {
+		t.Fatalf("expected no role changes, got %v", adds)
+	}
+	if got := session.Responses()[0].Data.Content; !strings.HasPrefix(got, "Durations look like") {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestExpireTempRoles(t *testing.T) {
+	b, session := newTestBot()
+	store := b.TempRoles.(*memoryTempRoles)
+
+	now := time.Now()
+	store.AddTempRole(testGuildID, testUserID, testRoleID, now.Add(-time.Minute))
+	store.AddTempRole(testGuildID, "401", testRoleID, now.Add(time.Hour))
+
+	b.expireTempRoles(now)
+
+	removes := session.Calls("GuildMemberRoleRemove")
+	if len(removes) != 1 || removes[0].Args[1] != testUserID {
+		t.Fatalf("expected the expired role to be removed, got %v", removes)
+	}
+	if len(store.roles) != 1 || store.roles[0].UserID != "401" {
+		t.Errorf("expected only the unexpired role to remain, got %v", store.roles)
+	}
+	if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 1 || changes[0] != "100 expire 500 400" {
+		t.Errorf("expected the expiry to be audited, got %v", changes)
+	}
+}
+
+func TestExpireTempRolesFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		remain int
+	}{
+		{
+			name:   "member left",
+			err:    &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownMember}},
+			remain: 0,
+		},
+		{
+			name:   "temporary error",
+			err:    errors.New("gateway timeout"),
+			remain: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, session := newTestBot()
+			session.Errors["GuildMemberRoleRemove"] = tt.err
+			store := b.TempRoles.(*memoryTempRoles)
+
+			now := time.Now()
+			store.AddTempRole(testGuildID, testUserID, testRoleID, now.Add(-time.Minute))
+
+			b.expireTempRoles(now)
+
+			if len(store.roles) != tt.remain {
+				t.Errorf("expected %d temporary roles to remain, got %v", tt.remain, store.roles)
+			}
+			if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 0 {
+				t.Errorf("expected nothing to be audited, got %v", changes)
+			}
+		})
+	}
+}
+
+// massRoleOptions builds the options of a /role mass command
+func massRoleOptions(action string, filters ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "mass",
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+		Options: append([]*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "action", Type: discordgo.ApplicationCommandOptionString, Value: action},
+			{Name: "role", Type: discordgo.ApplicationCommandOptionRole, Value: testRoleID},
+		}, filters...),
+	}
+}
+
+// addTestMembers adds members with IDs starting at 1000 to the fake state
+func addTestMembers(t *testing.T, session *fakeSession, count int, bot bool, joinedAt time.Time, roles ...string) {
+	t.Helper()
+
+	guild, err := session.State.Guild(testGuildID)
+	if err != nil {
+		t.Fatalf("getting guild from state: %v", err)
+	}
+
+	for i := 0; i < count; i++ {
+		member := &discordgo.Member{
+			GuildID:  testGuildID,
+			User:     &discordgo.User{ID: fmt.Sprint(1000 + len(guild.Members)), Bot: bot},
+			JoinedAt: joinedAt,
+			Roles:    roles,
+		}
+		if err := session.State.MemberAdd(member); err != nil {
+			t.Fatalf("adding member to state: %v", err)
+		}
+	}
+}
+
+func TestMassRoleSlashCommandAddsRoleToMatchingMembers(t *testing.T) {
+	delay := massRoleBatchDelay
+	massRoleBatchDelay = 0
+	t.Cleanup(func() { massRoleBatchDelay = delay })
+
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	recent := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	addTestMembers(t, session, 23, false, recent)
+	addTestMembers(t, session, 2, false, recent, testRoleID) // Already have the role
+	addTestMembers(t, session, 3, true, recent)
+	addTestMembers(t, session, 4, false, old)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", massRoleOptions(RoleActionAdd,
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "members", Type: discordgo.ApplicationCommandOptionString, Value: MassRoleHumans},
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "joined_after", Type: discordgo.ApplicationCommandOptionString, Value: "2024-01-01"},
+	)))
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 23 {
+		t.Fatalf("expected 23 role adds, got %d", len(adds))
+	}
+	if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 23 {
+		t.Errorf("expected 23 audited changes, got %d", len(changes))
+	}
+
+	if responses := session.Responses(); len(responses) != 1 || responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("expected the response to be deferred, got %v", responses)
+	}
+
+	var contents []string
+	for _, call := range session.Calls("InteractionResponseEdit") {
+		contents = append(contents, *call.Args[1].(*discordgo.WebhookEdit).Content)
+	}
+	want := []string{
+		"Updating <@&500>: 10/23 members...",
+		"Updating <@&500>: 20/23 members...",
+		"Added <@&500> to 23 members.",
+	}
+	if strings.Join(contents, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected progress updates: %q", contents)
+	}
+}
+
+func TestMassRoleSlashCommandReportsFailures(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	addTestMembers(t, session, 2, false, time.Now(), testRoleID)
+	session.Errors["GuildMemberRoleRemove"] = errors.New("missing access")
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", massRoleOptions(RoleActionRemove)))
+
+	edits := session.Calls("InteractionResponseEdit")
+	if len(edits) != 1 {
+		t.Fatalf("expected 1 edit, got %d", len(edits))
+	}
+	if got := *edits[0].Args[1].(*discordgo.WebhookEdit).Content; got != "Removed <@&500> from 0 members. 2 members couldn't be updated." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestMassRoleSlashCommandMovesLongRunsToChannel(t *testing.T) {
+	delay, handoff := massRoleBatchDelay, massRoleHandoffAfter
+	massRoleBatchDelay, massRoleHandoffAfter = 0, 0
+	t.Cleanup(func() { massRoleBatchDelay, massRoleHandoffAfter = delay, handoff })
+
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	addTestMembers(t, session, 12, false, time.Now(), testRoleID)
+	session.Errors["GuildMemberRoleRemove"] = errors.New("missing access")
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", massRoleOptions(RoleActionRemove)))
+
+	edits := session.Calls("InteractionResponseEdit")
+	if len(edits) != 1 || *edits[0].Args[1].(*discordgo.WebhookEdit).Content != "This is taking a while, so progress continues below." {
+		t.Fatalf("expected the response to point at the channel message, got %v", edits)
+	}
+	if notices := sentNotices(session); len(notices) != 1 || notices[0] != "Updating <@&500>: 10/12 members..." {
+		t.Fatalf("expected progress in a channel message, got %q", notices)
+	}
+	channelEdits := session.Calls("ChannelMessageEdit")
+	if len(channelEdits) != 1 || channelEdits[0].Args[2] != "Removed <@&500> from 0 members. 12 members couldn't be updated." {
+		t.Errorf("expected the result in the channel message, got %v", channelEdits)
+	}
+}
+
+func TestMassRoleSlashCommandRunsOncePerGuild(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	b.Commands.massRoleGuilds.Store(testGuildID, true)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("role", massRoleOptions(RoleActionAdd)))
+
+	if calls := session.Calls("GuildMembers"); len(calls) != 0 {
+		t.Fatalf("expected members not to be listed, got %v", calls)
+	}
+	if got := session.Responses()[0].Data.Content; got != "A mass role update is already running in this server." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
@@ -0,0 +1,396 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of self-assignable role bindings
+const (
+	RoleBindingReaction = "reaction"
+	RoleBindingButton   = "button"
+)
+
+// Self-assignable role modes, shared by every binding on a message
+const (
+	// RoleModeToggle grants the role on react or click and takes it away
+	// when the reaction is removed or the button clicked again
+	RoleModeToggle = "toggle"
+
+	// RoleModeUnique lets members hold only one of the message's roles
+	RoleModeUnique = "unique"
+
+	// RoleModeVerify only ever grants the role
+	RoleModeVerify = "verify"
+)
+
+// maxRoleButtons is the most buttons a message can hold
+const maxRoleButtons = 25
+
+// customEmojiPattern matches a custom emoji as typed in a message, e.g. <:name:123>
+var customEmojiPattern = regexp.MustCompile(`^<(a?):(\w+):(\d+)>$`)
+
+// RoleBindingStore persists self-assignable role bindings
+type RoleBindingStore interface {
+	SaveRoleBinding(binding database.RoleBinding) error
+	DeleteRoleBinding(messageID, roleID string) (bool, error)
+	GetGuildRoleBindings(guildID string) ([]database.RoleBinding, error)
+}
+
+// RoleBindingCache caches role bindings per guild in front of a
+// RoleBindingStore, so reactions don't each cost a database query
+type RoleBindingCache struct {
+	store  RoleBindingStore
+	guilds map[string][]database.RoleBinding
+	mu     sync.RWMutex
+}
+
+// NewRoleBindingCache creates a role binding cache
+func NewRoleBindingCache(store RoleBindingStore) *RoleBindingCache {
+	return &RoleBindingCache{
+		store:  store,
+		guilds: make(map[string][]database.RoleBinding),
+	}
+}
+
+// Guild returns every role binding in a guild
+func (c *RoleBindingCache) Guild(guildID string) ([]database.RoleBinding, error) {
+	c.mu.RLock()
+	bindings, ok := c.guilds[guildID]
+	c.mu.RUnlock()
+
+	if ok {
+		return bindings, nil
+	}
+
+	bindings, err := c.store.GetGuildRoleBindings(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.guilds[guildID] = bindings
+	c.mu.Unlock()
+
+	return bindings, nil
+}
+
+// Message returns the role bindings on a message
+func (c *RoleBindingCache) Message(guildID, messageID string) []database.RoleBinding {
+	bindings, err := c.Guild(guildID)
+	if err != nil {
+		// Don't cache failures so the lookup is retried
+		logrus.Errorf("Error loading role bindings for guild %s: %v", guildID, err)
+		return nil
+	}
+
+	var matched []database.RoleBinding
+	for _, binding := range bindings {
+		if binding.MessageID == messageID {
+			matched = append(matched, binding)
+		}
+	}
+	return matched
+}
+
+// Save stores a role binding
+func (c *RoleBindingCache) Save(binding database.RoleBinding) error {
+	if err := c.store.SaveRoleBinding(binding); err != nil {
+		return err
+	}
+
+	// Saving can change the mode of other bindings, so reload the guild
+	c.Forget(binding.GuildID)
+	return nil
+}
+
+// Delete removes the binding of a role on a message and reports whether there was one
+func (c *RoleBindingCache) Delete(guildID, messageID, roleID string) (bool, error) {
+	deleted, err := c.store.DeleteRoleBinding(messageID, roleID)
+	if err != nil {
+		return false, err
+	}
+
+	c.Forget(guildID)
+	return deleted, nil
+}
+
+// Forget drops a guild from the cache, e.g. when the bot leaves it
+func (c *RoleBindingCache) Forget(guildID string) {
+	c.mu.Lock()
+	delete(c.guilds, guildID)
+	c.mu.Unlock()
+}
+
+// parseEmoji converts an emoji typed in a slash command option to the form
+// the reaction API uses: the emoji itself, or "name:id" for custom emoji
+func parseEmoji(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if match := customEmojiPattern.FindStringSubmatch(value); match != nil {
+		return match[2] + ":" + match[3], true
+	}
+	if value == "" || strings.ContainsAny(value, " <>:") {
+		return "", false
+	}
+	return value, true
+}
+
+// componentEmoji converts an emoji in reaction API form to a button emoji
+func componentEmoji(emoji string) discordgo.ComponentEmoji {
+	if name, id, ok := strings.Cut(emoji, ":"); ok {
+		return discordgo.ComponentEmoji{Name: name, ID: id}
+	}
+	return discordgo.ComponentEmoji{Name: emoji}
+}
+
+// roleButtonCustomID returns the custom ID of the button for a role
+func roleButtonCustomID(roleID string) string {
+	return "selfrole:" + roleID
+}
+
+// roleButtonRows lays out the buttons of a message's bindings, five per row
+func roleButtonRows(bindings []database.RoleBinding) []discordgo.MessageComponent {
+	// An empty list rather than nil, so edits clear buttons that were removed
+	rows := []discordgo.MessageComponent{}
+	var row discordgo.ActionsRow
+	for _, binding := range bindings {
+		if binding.Kind != RoleBindingButton {
+			continue
+		}
+
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    binding.Label,
+			Style:    discordgo.SecondaryButton,
+			CustomID: roleButtonCustomID(binding.RoleID),
+			Emoji:    componentEmoji(binding.Emoji),
+		})
+		if len(row.Components) == 5 {
+			rows = append(rows, row)
+			row = discordgo.ActionsRow{}
+		}
+	}
+	if len(row.Components) > 0 {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// refreshRoleButtons redraws the role buttons on a message from its bindings
+func (b *Bot) refreshRoleButtons(guildID, channelID, messageID string) error {
+	msg, err := b.Session.ChannelMessage(channelID, messageID)
+	if err != nil {
+		return fmt.Errorf("error getting message: %w", err)
+	}
+
+	edit := discordgo.NewMessageEdit(channelID, messageID)
+	edit.Embeds = msg.Embeds
+	edit.Components = roleButtonRows(b.RoleBindings.Message(guildID, messageID))
+
+	if _, err := b.Session.ChannelMessageEditComplex(edit); err != nil {
+		return fmt.Errorf("error editing message: %w", err)
+	}
+	return nil
+}
+
+// findRoleBinding returns the binding of the given kind matching match
+func findRoleBinding(bindings []database.RoleBinding, kind string, match func(database.RoleBinding) bool) (database.RoleBinding, bool) {
+	for _, binding := range bindings {
+		if binding.Kind == kind && match(binding) {
+			return binding, true
+		}
+	}
+	return database.RoleBinding{}, false
+}
+
+// changeSelfRole gives a role to or takes it from a member who chose it
+// themselves, recording the change
+func (b *Bot) changeSelfRole(guildID, userID, roleID string, add bool) error {
+	action := RoleActionRemove
+	var err error
+	if add {
+		action = RoleActionAdd
+		err = b.Session.GuildMemberRoleAdd(guildID, userID, roleID)
+	} else {
+		err = b.Session.GuildMemberRoleRemove(guildID, userID, roleID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.RoleAudit.LogRoleChange(guildID, userID, userID, roleID, action); err != nil {
+		logrus.Errorf("Error recording role change: %v", err)
+	}
+	return nil
+}
+
+// removeOtherSelfRoles takes away the roles of a unique message other than
+// keepRoleID from a member. It returns the roles it took away.
+func (b *Bot) removeOtherSelfRoles(bindings []database.RoleBinding, member *discordgo.Member, keepRoleID string) map[string]bool {
+	removed := make(map[string]bool)
+	for _, binding := range bindings {
+		if binding.RoleID == keepRoleID || !memberHasRole(member, binding.RoleID) {
+			continue
+		}
+		if err := b.changeSelfRole(binding.GuildID, member.User.ID, binding.RoleID, false); err != nil {
+			logrus.Warnf("Error removing role %s from %s: %v", binding.RoleID, member.User.ID, err)
+			continue
+		}
+		removed[binding.RoleID] = true
+	}
+	return removed
+}
+
+// reactionSwitchTimeout is how long the remove event of a reaction taken
+// off in a unique role switch is waited for
+const reactionSwitchTimeout = time.Minute
+
+// reactionSwitches remembers reactions the bot took off while switching a
+// member's unique role, after it already took their roles away. Their
+// remove events are skipped, so the roles aren't removed a second time, or
+// removed again after the member quickly switched back.
+type reactionSwitches struct {
+	mu      sync.Mutex
+	removed map[string]time.Time // When each reaction was taken off, by key
+}
+
+// reactionSwitchKey identifies a member's reaction on a message
+func reactionSwitchKey(messageID, userID, emoji string) string {
+	return messageID + ":" + userID + ":" + emoji
+}
+
+// add records that the bot took a reaction off
+func (s *reactionSwitches) add(key string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.removed == nil {
+		s.removed = make(map[string]time.Time)
+	}
+	for k, at := range s.removed {
+		if now.Sub(at) > reactionSwitchTimeout {
+			delete(s.removed, k)
+		}
+	}
+	s.removed[key] = now
+}
+
+// take reports whether the bot recently took a reaction off, forgetting it
+func (s *reactionSwitches) take(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.removed[key]
+	delete(s.removed, key)
+	return ok && now.Sub(at) <= reactionSwitchTimeout
+}
+
+// onMessageReactionAdd grants the role bound to a reaction
+func (b *Bot) onMessageReactionAdd(_ *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.GuildID == "" || r.UserID == b.Session.BotUserID() || (r.Member != nil && r.Member.User != nil && r.Member.User.Bot) {
+		return
+	}
+
+	bindings := b.RoleBindings.Message(r.GuildID, r.MessageID)
+	emoji := r.Emoji.APIName()
+	binding, ok := findRoleBinding(bindings, RoleBindingReaction, func(binding database.RoleBinding) bool {
+		return binding.Emoji == emoji
+	})
+	if !ok {
+		return
+	}
+
+	if err := b.changeSelfRole(r.GuildID, r.UserID, binding.RoleID, true); err != nil {
+		logrus.Warnf("Error adding reaction role %s to %s: %v", binding.RoleID, r.UserID, err)
+		return
+	}
+
+	if binding.Mode != RoleModeUnique || r.Member == nil {
+		return
+	}
+
+	// Only one role of a unique message can be held, so clear the member's
+	// other roles and reactions
+	member := &discordgo.Member{User: &discordgo.User{ID: r.UserID}, Roles: r.Member.Roles}
+	removed := b.removeOtherSelfRoles(bindings, member, binding.RoleID)
+	for _, other := range bindings {
+		if other.Kind != RoleBindingReaction || other.RoleID == binding.RoleID {
+			continue
+		}
+
+		// The role is already gone, so the reaction's remove event is skipped
+		key := reactionSwitchKey(r.MessageID, r.UserID, other.Emoji)
+		if removed[other.RoleID] {
+			b.reactionSwitches.add(key, time.Now())
+		}
+		if err := b.Session.MessageReactionRemove(r.ChannelID, r.MessageID, other.Emoji, r.UserID); err != nil {
+			b.reactionSwitches.take(key, time.Now())
+			logrus.Warnf("Error removing reaction %s from %s: %v", other.Emoji, r.UserID, err)
+		}
+	}
+}
+
+// onMessageReactionRemove takes away the role bound to a reaction, unless
+// the message only grants roles
+func (b *Bot) onMessageReactionRemove(_ *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	if r.GuildID == "" || r.UserID == b.Session.BotUserID() {
+		return
+	}
+
+	emoji := r.Emoji.APIName()
+	binding, ok := findRoleBinding(b.RoleBindings.Message(r.GuildID, r.MessageID), RoleBindingReaction, func(binding database.RoleBinding) bool {
+		return binding.Emoji == emoji
+	})
+	if !ok || binding.Mode == RoleModeVerify {
+		return
+	}
+	if b.reactionSwitches.take(reactionSwitchKey(r.MessageID, r.UserID, emoji), time.Now()) {
+		return
+	}
+
+	if err := b.changeSelfRole(r.GuildID, r.UserID, binding.RoleID, false); err != nil {
+		logrus.Warnf("Error removing reaction role %s from %s: %v", binding.RoleID, r.UserID, err)
+	}
+}
+
+// selfRoleButton grants or takes away the role bound to a button
+func (b *Bot) selfRoleButton(ctx *ComponentContext) error {
+	i := ctx.Interaction
+	if i.Member == nil || i.Message == nil {
+		return ctx.replyEphemeral("Roles can only be picked in a server.")
+	}
+
+	roleID := ctx.Param("roleID")
+	bindings := b.RoleBindings.Message(ctx.GuildID, i.Message.ID)
+	binding, ok := findRoleBinding(bindings, RoleBindingButton, func(binding database.RoleBinding) bool {
+		return binding.RoleID == roleID
+	})
+	if !ok {
+		return ctx.replyEphemeral("This role is no longer available.")
+	}
+
+	has := memberHasRole(i.Member, roleID)
+	if has && binding.Mode == RoleModeVerify {
+		return ctx.replyEphemeral(fmt.Sprintf("You already have <@&%s>.", roleID))
+	}
+
+	if err := b.changeSelfRole(ctx.GuildID, ctx.UserID, roleID, !has); err != nil {
+		logrus.Warnf("Error changing button role %s for %s: %v", roleID, ctx.UserID, err)
+		return ctx.replyEphemeral("I couldn't change your roles. Ask a moderator to check my permissions.")
+	}
+
+	if has {
+		return ctx.replyEphemeral(fmt.Sprintf("Removed <@&%s>.", roleID))
+	}
+
+	if binding.Mode == RoleModeUnique {
+		b.removeOtherSelfRoles(bindings, i.Member, roleID)
+	}
+	return ctx.replyEphemeral(fmt.Sprintf("Added <@&%s>.", roleID))
+}
@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+)
+
+const testMessageID = "800"
+
+func TestParseEmoji(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{value: "👍", want: "👍", ok: true},
+		{value: " 🎮 ", want: "🎮", ok: true},
+		{value: "<:gopher:123456>", want: "gopher:123456", ok: true},
+		{value: "<a:dance:42>", want: "dance:42", ok: true},
+		{value: "", ok: false},
+		{value: "not an emoji", ok: false},
+		{value: "<:broken>", ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseEmoji(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseEmoji(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// selfRoleOptions builds the options of a /selfrole subcommand
+func selfRoleOptions(subcommand string, options map[string]interface{}) *discordgo.ApplicationCommandInteractionDataOption {
+	opt := &discordgo.ApplicationCommandInteractionDataOption{Name: subcommand, Type: discordgo.ApplicationCommandOptionSubCommand}
+	for name, value := range options {
+		optType := discordgo.ApplicationCommandOptionString
+		if name == "role" {
+			optType = discordgo.ApplicationCommandOptionRole
+		}
+		opt.Options = append(opt.Options, &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: optType, Value: value})
+	}
+	return opt
+}
+
+// addTestBinding binds a role to the test message
+func addTestBinding(t *testing.T, b *Bot, binding database.RoleBinding) {
+	t.Helper()
+
+	binding.GuildID, binding.ChannelID, binding.MessageID = testGuildID, testChannelID, testMessageID
+	if err := b.RoleBindings.Save(binding); err != nil {
+		t.Fatalf("saving role binding: %v", err)
+	}
+}
+
+// newTestReaction creates a reaction by the test user on the test message
+func newTestReaction(emoji string) *discordgo.MessageReaction {
+	return &discordgo.MessageReaction{
+		UserID:    testUserID,
+		MessageID: testMessageID,
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Emoji:     discordgo.Emoji{Name: emoji},
+	}
+}
+
+func TestSelfRoleReactionCommandBindsEmoji(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("selfrole", selfRoleOptions("reaction", map[string]interface{}{
+		"message_id": testMessageID,
+		"emoji":      "👍",
+		"role":       testRoleID,
+	})))
+
+	reactions := session.Calls("MessageReactionAdd")
+	if len(reactions) != 1 || reactions[0].Args[1] != testMessageID || reactions[0].Args[2] != "👍" {
+		t.Fatalf("expected the bot to react to the message, got %v", reactions)
+	}
+
+	bindings := b.RoleBindings.Message(testGuildID, testMessageID)
+	if len(bindings) != 1 || bindings[0].Kind != RoleBindingReaction || bindings[0].Mode != RoleModeToggle {
+		t.Fatalf("unexpected bindings: %+v", bindings)
+	}
+	if got := session.Responses()[0].Data.Content; got != "Reacting to that message now gives <@&500> (toggle mode)." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestReactionRoleModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		removed int // Role removals after the reaction is removed
+	}{
+		{mode: RoleModeToggle, removed: 1},
+		{mode: RoleModeUnique, removed: 1},
+		{mode: RoleModeVerify, removed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			b, session := newTestBot()
+			addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "👍", RoleID: testRoleID, Mode: tt.mode})
+
+			b.onMessageReactionAdd(nil, &discordgo.MessageReactionAdd{MessageReaction: newTestReaction("👍")})
+			if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 1 || adds[0].Args[2] != testRoleID {
+				t.Fatalf("expected the role to be added, got %v", adds)
+			}
+
+			b.onMessageReactionRemove(nil, &discordgo.MessageReactionRemove{MessageReaction: newTestReaction("👍")})
+			if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != tt.removed {
+				t.Errorf("expected %d role removals, got %v", tt.removed, removes)
+			}
+		})
+	}
+}
+
+func TestReactionRoleIgnoresUnboundEmoji(t *testing.T) {
+	b, session := newTestBot()
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "👍", RoleID: testRoleID, Mode: RoleModeToggle})
+
+	b.onMessageReactionAdd(nil, &discordgo.MessageReactionAdd{MessageReaction: newTestReaction("👎")})
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 0 {
+		t.Errorf("expected no roles to be added, got %v", adds)
+	}
+}
+
+func TestUniqueReactionRoleReplacesOtherRoles(t *testing.T) {
+	b, session := newTestBot()
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "🔴", RoleID: "501", Mode: RoleModeUnique})
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "🔵", RoleID: "502", Mode: RoleModeUnique})
+
+	b.onMessageReactionAdd(nil, &discordgo.MessageReactionAdd{
+		MessageReaction: newTestReaction("🔵"),
+		Member:          &discordgo.Member{User: &discordgo.User{ID: testUserID}, Roles: []string{"501"}},
+	})
+
+	if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != 1 || adds[0].Args[2] != "502" {
+		t.Fatalf("expected the new role to be added, got %v", adds)
+	}
+	if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != 1 || removes[0].Args[2] != "501" {
+		t.Errorf("expected the old role to be removed, got %v", removes)
+	}
+	if reactions := session.Calls("MessageReactionRemove"); len(reactions) != 1 || reactions[0].Args[2] != "🔴" {
+		t.Errorf("expected the old reaction to be removed, got %v", reactions)
+	}
+}
+
+func TestUniqueReactionSwitchRemovesRolesOnce(t *testing.T) {
+	b, session := newTestBot()
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "🔴", RoleID: "501", Mode: RoleModeUnique})
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "🔵", RoleID: "502", Mode: RoleModeUnique})
+
+	b.onMessageReactionAdd(nil, &discordgo.MessageReactionAdd{
+		MessageReaction: newTestReaction("🔵"),
+		Member:          &discordgo.Member{User: &discordgo.User{ID: testUserID}, Roles: []string{"501"}},
+	})
+	// The gateway reports the reaction the bot took off
+	b.onMessageReactionRemove(nil, &discordgo.MessageReactionRemove{MessageReaction: newTestReaction("🔴")})
+
+	if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != 1 {
+		t.Errorf("expected the old role to be removed once, got %v", removes)
+	}
+	if changes := b.RoleAudit.(*memoryRoleAudit).changes; len(changes) != 2 {
+		t.Errorf("expected one add and one removal to be audited, got %q", changes)
+	}
+
+	// Taking a reaction off later still removes its role
+	b.onMessageReactionRemove(nil, &discordgo.MessageReactionRemove{MessageReaction: newTestReaction("🔴")})
+	if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != 2 {
+		t.Errorf("expected a later removal to take the role, got %v", removes)
+	}
+}
+
+func TestSelfRoleButtonCommandAddsButton(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	session.Messages[testMessageID] = &discordgo.Message{
+		ID:        testMessageID,
+		ChannelID: testChannelID,
+		Author:    &discordgo.User{ID: session.BotUserID()},
+		Embeds:    []*discordgo.MessageEmbed{{Title: "Pick your roles"}},
+	}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("selfrole", selfRoleOptions("button", map[string]interface{}{
+		"message_id": testMessageID,
+		"role":       testRoleID,
+		"label":      "Member",
+		"mode":       RoleModeVerify,
+	})))
+
+	edits := session.Calls("ChannelMessageEditComplex")
+	if len(edits) != 1 {
+		t.Fatalf("expected the message to be edited, got %d edits", len(edits))
+	}
+	edit := edits[0].Args[0].(*discordgo.MessageEdit)
+	if len(edit.Embeds) != 1 || edit.Embeds[0].Title != "Pick your roles" {
+		t.Errorf("expected the embed to be kept, got %v", edit.Embeds)
+	}
+	button := edit.Components[0].(discordgo.ActionsRow).Components[0].(discordgo.Button)
+	if button.Label != "Member" || button.CustomID != "selfrole:500" {
+		t.Errorf("unexpected button: %+v", button)
+	}
+	if got := session.Responses()[0].Data.Content; got != "Added a button for <@&500> to that message (verify mode)." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestSelfRoleButtonCommandRequiresBotMessage(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	session.Messages[testMessageID] = &discordgo.Message{ID: testMessageID, ChannelID: testChannelID, Author: &discordgo.User{ID: testUserID}}
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("selfrole", selfRoleOptions("button", map[string]interface{}{
+		"message_id": testMessageID,
+		"role":       testRoleID,
+		"label":      "Member",
+	})))
+
+	if bindings := b.RoleBindings.Message(testGuildID, testMessageID); len(bindings) != 0 {
+		t.Errorf("expected no bindings, got %+v", bindings)
+	}
+	if got := session.Responses()[0].Data.Content; got != "I can only add buttons to my own messages. Create one with `/selfrole panel`." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
+
+func TestSelfRoleButtonClicks(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		roles   []string
+		add     int
+		remove  int
+		message string
+	}{
+		{name: "toggle on", mode: RoleModeToggle, add: 1, message: "Added <@&500>."},
+		{name: "toggle off", mode: RoleModeToggle, roles: []string{testRoleID}, remove: 1, message: "Removed <@&500>."},
+		{name: "verify again", mode: RoleModeVerify, roles: []string{testRoleID}, message: "You already have <@&500>."},
+		{name: "unique switch", mode: RoleModeUnique, roles: []string{"501"}, add: 1, remove: 1, message: "Added <@&500>."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, session := newTestBot()
+			addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingButton, Label: "Member", RoleID: testRoleID, Mode: tt.mode})
+			addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingButton, Label: "Other", RoleID: "501", Mode: tt.mode})
+
+			i := newTestComponentInteraction("selfrole:500", &discordgo.Message{ID: testMessageID, ChannelID: testChannelID})
+			i.Member.Roles = tt.roles
+			b.Components.Dispatch(session, i)
+
+			if adds := session.Calls("GuildMemberRoleAdd"); len(adds) != tt.add {
+				t.Errorf("expected %d role adds, got %v", tt.add, adds)
+			}
+			if removes := session.Calls("GuildMemberRoleRemove"); len(removes) != tt.remove {
+				t.Errorf("expected %d role removals, got %v", tt.remove, removes)
+			}
+			resp := session.Responses()[0]
+			if resp.Data.Content != tt.message || resp.Data.Flags != discordgo.MessageFlagsEphemeral {
+				t.Errorf("unexpected response: %+v", resp.Data)
+			}
+		})
+	}
+}
+
+func TestSelfRoleRemoveUnbindsRole(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionManageRoles)
+	addTestModerator(t, session, 3)
+	addTestBinding(t, b, database.RoleBinding{Kind: RoleBindingReaction, Emoji: "👍", RoleID: testRoleID, Mode: RoleModeToggle})
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("selfrole", selfRoleOptions("remove", map[string]interface{}{
+		"message_id": testMessageID,
+		"role":       testRoleID,
+	})))
+
+	if bindings := b.RoleBindings.Message(testGuildID, testMessageID); len(bindings) != 0 {
+		t.Errorf("expected the binding to be deleted, got %+v", bindings)
+	}
+	if reactions := session.Calls("MessageReactionRemove"); len(reactions) != 1 || reactions[0].Args[3] != "@me" {
+		t.Errorf("expected the bot's reaction to be removed, got %v", reactions)
+	}
+}
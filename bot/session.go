@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is the subset of the Discord API and state used by the bot.
+// It is satisfied by the real gateway session (see NewSession) and by
+// in-memory fakes in tests, so bot logic can run without a live token.
+type Session interface {
+	// Messages
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error
+
+	// Interactions
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+
+	// Roles
+	GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+	GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+
+	// Users
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+
+	// Members
+	GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	GuildMembers(guildID string, after string, limit int, options ...discordgo.RequestOption) ([]*discordgo.Member, error)
+
+	// Application commands
+	ApplicationCommands(appID, guildID string, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	ApplicationCommandDelete(appID, guildID, cmdID string, options ...discordgo.RequestOption) error
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+
+	// Gateway
+	AddHandler(handler interface{}) func()
+	UpdateGameStatus(idle int, name string) error
+	ChannelVoiceJoin(guildID, channelID string, mute, deaf bool) (VoiceConn, error)
+
+	// State
+	BotUserID() string
+	StateGuild(guildID string) (*discordgo.Guild, error)
+	StateMember(guildID, userID string) (*discordgo.Member, error)
+	StateRole(guildID, roleID string) (*discordgo.Role, error)
+	StateChannel(channelID string) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string) (int64, error)
+}
+
+// discordSession adapts a *discordgo.Session to the Session interface
+type discordSession struct {
+	*discordgo.Session
+}
+
+// NewSession wraps a discordgo session so it can be used as a Session
+func NewSession(s *discordgo.Session) Session {
+	return &discordSession{Session: s}
+}
+
+// BotUserID returns the ID of the bot user from the session state
+func (s *discordSession) BotUserID() string {
+	if s.State == nil || s.State.User == nil {
+		return ""
+	}
+	return s.State.User.ID
+}
+
+// ChannelVoiceJoin joins a voice channel and returns the connection as a VoiceConn
+func (s *discordSession) ChannelVoiceJoin(guildID, channelID string, mute, deaf bool) (VoiceConn, error) {
+	conn, err := s.Session.ChannelVoiceJoin(guildID, channelID, mute, deaf)
+	if err != nil {
+		return nil, err
+	}
+	return NewVoiceConn(conn), nil
+}
+
+// InteractionRespond responds to an interaction. discordgo leaves empty
+// autocomplete choices out of the request, but Discord requires the field,
+// so those results are sent with an explicit empty list.
+func (s *discordSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	if resp.Type != discordgo.InteractionApplicationCommandAutocompleteResult || resp.Data == nil || len(resp.Data.Choices) > 0 {
+		return s.Session.InteractionRespond(interaction, resp, options...)
+	}
+
+	body := map[string]interface{}{
+		"type": resp.Type,
+		"data": map[string]interface{}{"choices": []*discordgo.ApplicationCommandOptionChoice{}},
+	}
+	endpoint := discordgo.EndpointInteractionResponse(interaction.ID, interaction.Token)
+	_, err := s.RequestWithBucketID("POST", endpoint, body, endpoint, options...)
+	return err
+}
+
+// StateGuild returns a guild from the session state cache
+func (s *discordSession) StateGuild(guildID string) (*discordgo.Guild, error) {
+	return s.State.Guild(guildID)
+}
+
+// StateMember returns a guild member from the session state cache
+func (s *discordSession) StateMember(guildID, userID string) (*discordgo.Member, error) {
+	return s.State.Member(guildID, userID)
+}
+
+// StateRole returns a guild role from the session state cache
+func (s *discordSession) StateRole(guildID, roleID string) (*discordgo.Role, error) {
+	return s.State.Role(guildID, roleID)
+}
+
+// StateChannel returns a channel from the session state cache
+func (s *discordSession) StateChannel(channelID string) (*discordgo.Channel, error) {
+	return s.State.Channel(channelID)
+}
+
+// UserChannelPermissions returns the permissions of a user in a channel from the session state cache
+func (s *discordSession) UserChannelPermissions(userID, channelID string) (int64, error) {
+	return s.State.UserChannelPermissions(userID, channelID)
+}
@@ -0,0 +1,717 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/config"
+	"github.com/kalanakt/go.discord-bot/database"
+)
+
+// fakeCall records a single call made against a fakeSession
+type fakeCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// fakeSession is an in-memory Session that records every call made to it.
+// State lookups are served from a real discordgo.State so permission
+// calculations behave like they do against the gateway cache.
+type fakeSession struct {
+	State *discordgo.State
+
+	// Commands holds the registered application commands by guild ID
+	Commands map[string][]*discordgo.ApplicationCommand
+
+	// Errors maps a method name to the error it should return
+	Errors map[string]error
+
+	// Messages holds the messages returned by ChannelMessage by ID
+	Messages map[string]*discordgo.Message
+
+	mu       sync.Mutex
+	calls    []fakeCall
+	nextID   int
+	handlers []*fakeHandler
+	voice    map[string]*fakeVoiceConn
+}
+
+// fakeHandler is an event handler added with AddHandler
+type fakeHandler struct {
+	fn interface{}
+}
+
+var _ Session = (*fakeSession)(nil)
+
+// newFakeSession creates a fake session whose bot user has the given ID
+func newFakeSession(botID string) *fakeSession {
+	state := discordgo.NewState()
+	state.User = &discordgo.User{ID: botID, Username: "bot"}
+
+	return &fakeSession{
+		State:    state,
+		Commands: make(map[string][]*discordgo.ApplicationCommand),
+		Errors:   make(map[string]error),
+		Messages: make(map[string]*discordgo.Message),
+		nextID:   1000,
+		voice:    make(map[string]*fakeVoiceConn),
+	}
+}
+
+// record stores a call and returns the configured error for the method, if any
+func (f *fakeSession) record(method string, args ...interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, fakeCall{Method: method, Args: args})
+	return f.Errors[method]
+}
+
+// newMessage returns a message with a fresh ID
+func (f *fakeSession) newMessage(channelID, content string) *discordgo.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	return &discordgo.Message{ID: strconv.Itoa(f.nextID), ChannelID: channelID, Content: content}
+}
+
+// Calls returns every recorded call for a method, or all calls if method is empty
+func (f *fakeSession) Calls(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Responses returns every interaction response sent through the session
+func (f *fakeSession) Responses() []*discordgo.InteractionResponse {
+	var responses []*discordgo.InteractionResponse
+	for _, call := range f.Calls("InteractionRespond") {
+		responses = append(responses, call.Args[1].(*discordgo.InteractionResponse))
+	}
+	return responses
+}
+
+func (f *fakeSession) ChannelMessageSend(channelID string, content string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessageSend", channelID, content); err != nil {
+		return nil, err
+	}
+	return f.newMessage(channelID, content), nil
+}
+
+func (f *fakeSession) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessageSendEmbed", channelID, embed); err != nil {
+		return nil, err
+	}
+	msg := f.newMessage(channelID, "")
+	msg.Embeds = []*discordgo.MessageEmbed{embed}
+	return msg, nil
+}
+
+func (f *fakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessageSendComplex", channelID, data); err != nil {
+		return nil, err
+	}
+	msg := f.newMessage(channelID, data.Content)
+	msg.Embeds = data.Embeds
+	msg.Components = data.Components
+	return msg, nil
+}
+
+func (f *fakeSession) ChannelMessageEditComplex(m *discordgo.MessageEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessageEditComplex", m); err != nil {
+		return nil, err
+	}
+	msg := &discordgo.Message{ID: m.ID, ChannelID: m.Channel, Embeds: m.Embeds, Components: m.Components}
+	if m.Content != nil {
+		msg.Content = *m.Content
+	}
+	return msg, nil
+}
+
+func (f *fakeSession) ChannelMessageEdit(channelID, messageID, content string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessageEdit", channelID, messageID, content); err != nil {
+		return nil, err
+	}
+	return &discordgo.Message{ID: messageID, ChannelID: channelID, Content: content}, nil
+}
+
+func (f *fakeSession) ChannelMessageDelete(channelID, messageID string, _ ...discordgo.RequestOption) error {
+	return f.record("ChannelMessageDelete", channelID, messageID)
+}
+
+func (f *fakeSession) ChannelMessage(channelID, messageID string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("ChannelMessage", channelID, messageID); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg, ok := f.Messages[messageID]
+	if !ok || msg.ChannelID != channelID {
+		return nil, errors.New("unknown message")
+	}
+	return msg, nil
+}
+
+func (f *fakeSession) MessageReactionRemove(channelID, messageID, emojiID, userID string, _ ...discordgo.RequestOption) error {
+	return f.record("MessageReactionRemove", channelID, messageID, emojiID, userID)
+}
+
+func (f *fakeSession) MessageReactionAdd(channelID, messageID, emojiID string, _ ...discordgo.RequestOption) error {
+	return f.record("MessageReactionAdd", channelID, messageID, emojiID)
+}
+
+func (f *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, _ ...discordgo.RequestOption) error {
+	return f.record("InteractionRespond", interaction, resp)
+}
+
+func (f *fakeSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("InteractionResponseEdit", interaction, newresp); err != nil {
+		return nil, err
+	}
+	content := ""
+	if newresp.Content != nil {
+		content = *newresp.Content
+	}
+	return f.newMessage(interaction.ChannelID, content), nil
+}
+
+func (f *fakeSession) InteractionResponseDelete(interaction *discordgo.Interaction, _ ...discordgo.RequestOption) error {
+	return f.record("InteractionResponseDelete", interaction)
+}
+
+func (f *fakeSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := f.record("FollowupMessageCreate", interaction, data); err != nil {
+		return nil, err
+	}
+	return f.newMessage(interaction.ChannelID, data.Content), nil
+}
+
+func (f *fakeSession) GuildMemberRoleAdd(guildID, userID, roleID string, _ ...discordgo.RequestOption) error {
+	return f.record("GuildMemberRoleAdd", guildID, userID, roleID)
+}
+
+func (f *fakeSession) GuildMemberRoleRemove(guildID, userID, roleID string, _ ...discordgo.RequestOption) error {
+	return f.record("GuildMemberRoleRemove", guildID, userID, roleID)
+}
+
+func (f *fakeSession) UserChannelCreate(recipientID string, _ ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if err := f.record("UserChannelCreate", recipientID); err != nil {
+		return nil, err
+	}
+	return &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (f *fakeSession) GuildMember(guildID, userID string, _ ...discordgo.RequestOption) (*discordgo.Member, error) {
+	if err := f.record("GuildMember", guildID, userID); err != nil {
+		return nil, err
+	}
+	return f.State.Member(guildID, userID)
+}
+
+func (f *fakeSession) GuildMembers(guildID string, after string, limit int, _ ...discordgo.RequestOption) ([]*discordgo.Member, error) {
+	if err := f.record("GuildMembers", guildID, after, limit); err != nil {
+		return nil, err
+	}
+
+	guild, err := f.State.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Members are listed in ID order, like the API does
+	members := append([]*discordgo.Member(nil), guild.Members...)
+	sort.Slice(members, func(i, j int) bool {
+		return snowflakeLess(members[i].User.ID, members[j].User.ID)
+	})
+
+	var page []*discordgo.Member
+	for _, member := range members {
+		if after != "" && !snowflakeLess(after, member.User.ID) {
+			continue
+		}
+		if len(page) == limit {
+			break
+		}
+		page = append(page, member)
+	}
+	return page, nil
+}
+
+// snowflakeLess compares two IDs numerically
+func snowflakeLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func (f *fakeSession) ApplicationCommands(appID, guildID string, _ ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	if err := f.record("ApplicationCommands", appID, guildID); err != nil {
+		return nil, err
+	}
+	return f.Commands[guildID], nil
+}
+
+func (f *fakeSession) ApplicationCommandDelete(appID, guildID, cmdID string, _ ...discordgo.RequestOption) error {
+	return f.record("ApplicationCommandDelete", appID, guildID, cmdID)
+}
+
+func (f *fakeSession) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, _ ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	if err := f.record("ApplicationCommandBulkOverwrite", appID, guildID, commands); err != nil {
+		return nil, err
+	}
+	f.Commands[guildID] = commands
+	return commands, nil
+}
+
+func (f *fakeSession) AddHandler(handler interface{}) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := &fakeHandler{fn: handler}
+	f.handlers = append(f.handlers, h)
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		for i, other := range f.handlers {
+			if other == h {
+				f.handlers = append(f.handlers[:i], f.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Emit calls every handler added for the event's type, like the gateway does
+func (f *fakeSession) Emit(event interface{}) {
+	f.mu.Lock()
+	handlers := append([]*fakeHandler(nil), f.handlers...)
+	f.mu.Unlock()
+
+	for _, h := range handlers {
+		fn := reflect.ValueOf(h.fn)
+		if fn.Type().NumIn() == 2 && fn.Type().In(1) == reflect.TypeOf(event) {
+			fn.Call([]reflect.Value{reflect.Zero(fn.Type().In(0)), reflect.ValueOf(event)})
+		}
+	}
+}
+
+// HandlerCount returns the number of event handlers currently added
+func (f *fakeSession) HandlerCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.handlers)
+}
+
+func (f *fakeSession) UpdateGameStatus(idle int, name string) error {
+	return f.record("UpdateGameStatus", idle, name)
+}
+
+func (f *fakeSession) ChannelVoiceJoin(guildID, channelID string, mute, deaf bool) (VoiceConn, error) {
+	if err := f.record("ChannelVoiceJoin", guildID, channelID, mute, deaf); err != nil {
+		return nil, err
+	}
+
+	conn := newFakeVoiceConn()
+	f.mu.Lock()
+	f.voice[guildID] = conn
+	f.mu.Unlock()
+	return conn, nil
+}
+
+// VoiceConn returns the latest voice connection joined in a guild
+func (f *fakeSession) VoiceConn(guildID string) *fakeVoiceConn {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.voice[guildID]
+}
+
+// fakeVoiceConn is an in-memory VoiceConn that records the packets sent to it
+type fakeVoiceConn struct {
+	send chan []byte
+
+	mu           sync.Mutex
+	packets      [][]byte
+	speaking     []bool
+	disconnected bool
+}
+
+// newFakeVoiceConn creates a fake voice connection that accepts packets as
+// fast as they are sent
+func newFakeVoiceConn() *fakeVoiceConn {
+	conn := &fakeVoiceConn{send: make(chan []byte)}
+	go func() {
+		for packet := range conn.send {
+			conn.mu.Lock()
+			conn.packets = append(conn.packets, packet)
+			conn.mu.Unlock()
+		}
+	}()
+	return conn
+}
+
+func (c *fakeVoiceConn) Speaking(speaking bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.speaking = append(c.speaking, speaking)
+	return nil
+}
+
+func (c *fakeVoiceConn) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.disconnected = true
+	return nil
+}
+
+func (c *fakeVoiceConn) OpusSender() chan<- []byte {
+	return c.send
+}
+
+// Packets returns the packets sent so far
+func (c *fakeVoiceConn) Packets() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([][]byte(nil), c.packets...)
+}
+
+// Spoke reports whether the connection started and then stopped speaking
+func (c *fakeVoiceConn) Spoke() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.speaking) >= 2 && c.speaking[0] && !c.speaking[len(c.speaking)-1]
+}
+
+// Disconnected reports whether the connection was closed
+func (c *fakeVoiceConn) Disconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.disconnected
+}
+
+func (f *fakeSession) BotUserID() string {
+	return f.State.User.ID
+}
+
+func (f *fakeSession) StateGuild(guildID string) (*discordgo.Guild, error) {
+	return f.State.Guild(guildID)
+}
+
+func (f *fakeSession) StateMember(guildID, userID string) (*discordgo.Member, error) {
+	return f.State.Member(guildID, userID)
+}
+
+func (f *fakeSession) StateRole(guildID, roleID string) (*discordgo.Role, error) {
+	return f.State.Role(guildID, roleID)
+}
+
+func (f *fakeSession) StateChannel(channelID string) (*discordgo.Channel, error) {
+	return f.State.Channel(channelID)
+}
+
+func (f *fakeSession) UserChannelPermissions(userID, channelID string) (int64, error) {
+	return f.State.UserChannelPermissions(userID, channelID)
+}
+
+// memoryPrefixStore is an in-memory PrefixStore
+type memoryPrefixStore map[string]string
+
+func (s memoryPrefixStore) GetGuildPrefix(guildID string) (string, error) {
+	return s[guildID], nil
+}
+
+func (s memoryPrefixStore) SetGuildPrefix(guildID, prefix string) error {
+	s[guildID] = prefix
+	return nil
+}
+
+// memoryCommandLog records command invocations in memory
+type memoryCommandLog struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (l *memoryCommandLog) LogCommand(guildID, channelID, userID, commandName, commandType string, arguments map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.commands = append(l.commands, commandType+" "+commandName)
+	return nil
+}
+
+// memoryErrorLog is an in-memory CommandErrorRecorder
+type memoryErrorLog struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *memoryErrorLog) LogCommandError(guildID, channelID, userID, commandName, commandType, errorMessage, stack string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, commandName+": "+errorMessage)
+	return nil
+}
+
+// memoryRoleAudit records role changes in memory
+type memoryRoleAudit struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (a *memoryRoleAudit) LogRoleChange(guildID, actorID, userID, roleID, action string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.changes = append(a.changes, actorID+" "+action+" "+roleID+" "+userID)
+	return nil
+}
+
+// memoryTempRoles is an in-memory TempRoleStore
+type memoryTempRoles struct {
+	mu    sync.Mutex
+	roles []database.TempRole
+}
+
+func (m *memoryTempRoles) AddTempRole(guildID, userID, roleID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, role := range m.roles {
+		if role.GuildID == guildID && role.UserID == userID && role.RoleID == roleID {
+			m.roles[i].ExpiresAt = expiresAt
+			return nil
+		}
+	}
+	m.roles = append(m.roles, database.TempRole{ID: int64(len(m.roles) + 1), GuildID: guildID, UserID: userID, RoleID: roleID, ExpiresAt: expiresAt})
+	return nil
+}
+
+func (m *memoryTempRoles) DeleteTempRole(guildID, userID, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, role := range m.roles {
+		if role.GuildID == guildID && role.UserID == userID && role.RoleID == roleID {
+			m.roles = append(m.roles[:i], m.roles[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryTempRoles) GetExpiredTempRoles(now time.Time, limit int) ([]database.TempRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []database.TempRole
+	for _, role := range m.roles {
+		if !role.ExpiresAt.After(now) && len(expired) < limit {
+			expired = append(expired, role)
+		}
+	}
+	return expired, nil
+}
+
+// memoryRoleBindings is an in-memory RoleBindingStore
+type memoryRoleBindings struct {
+	mu       sync.Mutex
+	bindings []database.RoleBinding
+}
+
+func (m *memoryRoleBindings) SaveRoleBinding(binding database.RoleBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := false
+	for i, existing := range m.bindings {
+		if existing.MessageID != binding.MessageID {
+			continue
+		}
+		if existing.RoleID == binding.RoleID {
+			binding.ID = existing.ID
+			m.bindings[i] = binding
+			saved = true
+		}
+		m.bindings[i].Mode = binding.Mode
+	}
+	if !saved {
+		binding.ID = int64(len(m.bindings) + 1)
+		m.bindings = append(m.bindings, binding)
+	}
+	return nil
+}
+
+func (m *memoryRoleBindings) DeleteRoleBinding(messageID, roleID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, binding := range m.bindings {
+		if binding.MessageID == messageID && binding.RoleID == roleID {
+			m.bindings = append(m.bindings[:i], m.bindings[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRoleBindings) GetGuildRoleBindings(guildID string) ([]database.RoleBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var bindings []database.RoleBinding
+	for _, binding := range m.bindings {
+		if binding.GuildID == guildID {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+// memoryJoinedGuilds is an in-memory JoinedGuildStore
+type memoryJoinedGuilds struct {
+	mu     sync.Mutex
+	guilds map[string]bool
+}
+
+func (m *memoryJoinedGuilds) GetJoinedGuilds() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var guildIDs []string
+	for guildID := range m.guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	return guildIDs, nil
+}
+
+func (m *memoryJoinedGuilds) AddJoinedGuild(guildID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.guilds == nil {
+		m.guilds = make(map[string]bool)
+	}
+	m.guilds[guildID] = true
+	return nil
+}
+
+func (m *memoryJoinedGuilds) RemoveJoinedGuild(guildID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.guilds, guildID)
+	return nil
+}
+
+// memoryWelcomes is an in-memory WelcomeStore
+type memoryWelcomes map[string]database.WelcomeSettings
+
+func (m memoryWelcomes) GetWelcomeSettings(guildID string) (*database.WelcomeSettings, error) {
+	settings, ok := m[guildID]
+	if !ok {
+		settings = database.WelcomeSettings{GuildID: guildID}
+	}
+	return &settings, nil
+}
+
+func (m memoryWelcomes) SaveWelcomeSettings(settings *database.WelcomeSettings) error {
+	m[settings.GuildID] = *settings
+	return nil
+}
+
+// memoryVoiceSettings is an in-memory VoiceSettingsStore
+type memoryVoiceSettings map[string]database.VoiceSettings
+
+func (m memoryVoiceSettings) GetVoiceSettings(guildID string) (*database.VoiceSettings, error) {
+	settings, ok := m[guildID]
+	if !ok {
+		settings = database.VoiceSettings{
+			GuildID:      guildID,
+			EmptyTimeout: database.DefaultVoiceEmptyTimeout,
+			IdleTimeout:  database.DefaultVoiceIdleTimeout,
+		}
+	}
+	return &settings, nil
+}
+
+func (m memoryVoiceSettings) SaveVoiceSettings(settings *database.VoiceSettings) error {
+	m[settings.GuildID] = *settings
+	return nil
+}
+
+// resetCooldowns forgets every command use recorded by the test bot
+func resetCooldowns(b *Bot) {
+	store := b.Cooldowns.(*MemoryCooldownStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.windows = make(map[string]*cooldownWindow)
+}
+
+// memoryReportChannels is an in-memory ReportChannelStore
+type memoryReportChannels map[string]string
+
+func (m memoryReportChannels) GetReportChannel(guildID string) (string, error) {
+	return m[guildID], nil
+}
+
+func (m memoryReportChannels) SaveReportChannel(guildID, channelID string) error {
+	m[guildID] = channelID
+	return nil
+}
+
+// newTestBot creates a bot wired to a fake session with no database
+func newTestBot() (*Bot, *fakeSession) {
+	session := newFakeSession("100")
+	b := &Bot{
+		Config:        &config.Config{CommandPrefix: "!"},
+		Session:       session,
+		Prefixes:      NewPrefixCache(memoryPrefixStore{}, "!"),
+		Cooldowns:     NewMemoryCooldownStore(),
+		CommandLog:    &memoryCommandLog{},
+		ErrorLog:      &memoryErrorLog{},
+		RoleAudit:     &memoryRoleAudit{},
+		TempRoles:     &memoryTempRoles{},
+		RoleBindings:  NewRoleBindingCache(&memoryRoleBindings{}),
+		JoinedGuilds:  NewJoinedGuilds(&memoryJoinedGuilds{}),
+		Welcomes:      memoryWelcomes{},
+		VoiceSettings: memoryVoiceSettings{},
+		Reports:       memoryReportChannels{},
+		Components:    NewComponentRouter(),
+		Guilds:        make(map[string]*discordgo.Guild),
+	}
+	b.Voice = NewVoiceManager(b, AudioSourceFunc(func(_ context.Context, location string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("no test audio named %q", location)
+	}))
+	b.Components.Use(ErrorMiddleware(b.ErrorLog), RecoveryMiddleware())
+	b.Commands = NewCommandHandler(b)
+
+	return b, session
+}
@@ -2,40 +2,89 @@ package bot
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sirupsen/logrus"
 )
 
+// VoiceConn is the subset of a discordgo voice connection used for playback.
+// It is satisfied by the real connection (see NewVoiceConn) and by fakes in
+// tests, so playback can run without joining a channel.
+type VoiceConn interface {
+	Speaking(speaking bool) error
+	Disconnect() error
+	OpusSender() chan<- []byte
+}
+
+// discordVoiceConn adapts a *discordgo.VoiceConnection to the VoiceConn interface
+type discordVoiceConn struct {
+	*discordgo.VoiceConnection
+}
+
+// NewVoiceConn wraps a discordgo voice connection so it can be used as a VoiceConn
+func NewVoiceConn(conn *discordgo.VoiceConnection) VoiceConn {
+	return &discordVoiceConn{VoiceConnection: conn}
+}
+
+// OpusSender returns the channel Opus packets are sent to Discord through
+func (c *discordVoiceConn) OpusSender() chan<- []byte {
+	return c.OpusSend
+}
+
 // VoiceConnection represents a voice connection to a Discord guild
 type VoiceConnection struct {
-	GuildID   string
-	ChannelID string
-	Conn      *discordgo.VoiceConnection
-	Playing   bool
-	Stopping  bool
-	Mu        sync.Mutex
+	GuildID       string
+	ChannelID     string
+	TextChannelID string // Channel the bot was asked to join from, where notices about the connection go
+	Conn          VoiceConn
+	Playing       bool
+	Stopping      bool
+	Paused        bool
+	Closed        bool // Set once the bot has left the channel
+	Mu            sync.Mutex
+
+	resume     chan struct{} // Closed to resume paused playback
+	stop       chan struct{} // Closed to stop the audio that is playing
+	aloneSince time.Time     // When everyone else left the channel, if they have
+	idleSince  time.Time     // When playback last stopped or paused, if it has
 }
 
 // VoiceManager manages voice connections across guilds
 type VoiceManager struct {
 	Bot         *Bot
+	Source      AudioSource                 // Opens the tracks requested with /play
+	Encoder     func() (OpusEncoder, error) // Creates an encoder for each PCM track, if PCM is supported
 	Connections map[string]*VoiceConnection // Map of guild ID to voice connection
+	Queues      map[string]*MusicQueue      // Map of guild ID to track queue
 	Mu          sync.Mutex
 }
 
 // NewVoiceManager creates a new voice manager
-func NewVoiceManager(bot *Bot) *VoiceManager {
+func NewVoiceManager(bot *Bot, source AudioSource) *VoiceManager {
 	return &VoiceManager{
 		Bot:         bot,
+		Source:      source,
 		Connections: make(map[string]*VoiceConnection),
+		Queues:      make(map[string]*MusicQueue),
 	}
 }
 
-// JoinVoiceChannel joins a voice channel
-func (vm *VoiceManager) JoinVoiceChannel(guildID, channelID string) (*VoiceConnection, error) {
+// Connection returns the voice connection in a guild, if there is one
+func (vm *VoiceManager) Connection(guildID string) (*VoiceConnection, bool) {
+	vm.Mu.Lock()
+	defer vm.Mu.Unlock()
+
+	vc, ok := vm.Connections[guildID]
+	return vc, ok
+}
+
+// JoinVoiceChannel joins a voice channel. Notices about the connection,
+// such as leaving it automatically, are posted in textChannelID.
+func (vm *VoiceManager) JoinVoiceChannel(guildID, channelID, textChannelID string) (*VoiceConnection, error) {
 	vm.Mu.Lock()
 	defer vm.Mu.Unlock()
 
@@ -47,6 +96,7 @@ func (vm *VoiceManager) JoinVoiceChannel(guildID, channelID string) (*VoiceConne
 		}
 
 		// Otherwise, disconnect from the current channel
+		vc.close()
 		if err := vc.Conn.Disconnect(); err != nil {
 			logrus.Warnf("Error disconnecting from voice channel: %v", err)
 		}
@@ -61,11 +111,12 @@ func (vm *VoiceManager) JoinVoiceChannel(guildID, channelID string) (*VoiceConne
 
 	// Create and store the voice connection
 	vc := &VoiceConnection{
-		GuildID:   guildID,
-		ChannelID: channelID,
-		Conn:      conn,
-		Playing:   false,
-		Stopping:  false,
+		GuildID:       guildID,
+		ChannelID:     channelID,
+		TextChannelID: textChannelID,
+		Conn:          conn,
+		Playing:       false,
+		Stopping:      false,
 	}
 	vm.Connections[guildID] = vc
 
@@ -84,9 +135,7 @@ func (vm *VoiceManager) LeaveVoiceChannel(guildID string) error {
 	}
 
 	// Stop any playing audio
-	vc.Mu.Lock()
-	vc.Stopping = true
-	vc.Mu.Unlock()
+	vc.close()
 
 	// Disconnect from the voice channel
 	if err := vc.Conn.Disconnect(); err != nil {
@@ -99,58 +148,129 @@ func (vm *VoiceManager) LeaveVoiceChannel(guildID string) error {
 	return nil
 }
 
-// PlayAudio plays audio from a reader
-func (vc *VoiceConnection) PlayAudio(reader io.Reader) error {
+// opusFrameDuration is how much audio an Opus frame holds
+var opusFrameDuration = 20 * time.Millisecond
+
+// opusSendAhead is how many frames playback may run ahead of real time. It
+// rides out scheduling jitter without buffering so much audio that skips
+// and pauses are heard late.
+const opusSendAhead = 5
+
+// fadeOutTimeout is how long a stopped track may take to fade out before
+// it is cut off, in case the encoder or the connection stalls
+const fadeOutTimeout = 2 * time.Second
+
+// PlayAudio sends Opus frames to the voice channel until the reader runs
+// out or playback is stopped. Frames are paced to real time, and a
+// connection that stops accepting frames can't block a stop. Readers that
+// can fade out are given the chance to when playback is stopped.
+func (vc *VoiceConnection) PlayAudio(frames OpusFrameReader) error {
 	vc.Mu.Lock()
+	if vc.Closed {
+		vc.Mu.Unlock()
+		return errors.New("voice connection is closed")
+	}
 	if vc.Playing {
 		vc.Mu.Unlock()
 		return errors.New("already playing audio")
 	}
 	vc.Playing = true
 	vc.Stopping = false
+	stop := make(chan struct{})
+	vc.stop = stop
 	vc.Mu.Unlock()
 
-	// Make sure we're speaking
-	if err := vc.Conn.Speaking(true); err != nil {
-		return err
-	}
-
-	// When we're done, stop speaking and set playing to false
+	// When we're done, set playing to false
 	defer func() {
-		_ = vc.Conn.Speaking(false)
 		vc.Mu.Lock()
 		vc.Playing = false
 		vc.Stopping = false
+		vc.stop = nil
 		vc.Mu.Unlock()
 	}()
 
-	// Create a buffer for audio data
-	buf := make([]byte, 16*1024) // 16KB buffer
-	for {
-		// Check if we should stop
-		vc.Mu.Lock()
-		if vc.Stopping {
-			vc.Mu.Unlock()
-			break
+	// Make sure we're speaking, and stop speaking when we're done
+	if err := vc.Conn.Speaking(true); err != nil {
+		return err
+	}
+	defer func() {
+		_ = vc.Conn.Speaking(false)
+	}()
+
+	start := time.Now()
+	var fading <-chan time.Time // Cuts off a fade out that takes too long
+
+	// startFade fades out the rest of the audio once playback is stopped.
+	// It reports false if the audio can't fade and has to end right away.
+	startFade := func() bool {
+		f, ok := frames.(fader)
+		if !ok || !f.FadeOut() {
+			return false
 		}
+		fading = time.After(fadeOutTimeout)
+		stop = nil
+		return true
+	}
+
+	for sent := 0; ; sent++ {
+		// Check if we should stop, or wait while paused
+		vc.Mu.Lock()
+		stopping, closed, resume := vc.Stopping, vc.Closed, vc.resume
 		vc.Mu.Unlock()
 
-		// Read from the audio source
-		n, err := reader.Read(buf)
+		if closed {
+			return nil
+		}
+		if stopping && fading == nil && !startFade() {
+			return nil
+		}
+		if resume != nil {
+			pausedAt := time.Now()
+			<-resume
+			// Don't make up for the pause with a burst of frames
+			start = start.Add(time.Since(pausedAt))
+			sent--
+			continue
+		}
+
+		frame, err := frames.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			if err != io.EOF {
-				logrus.Errorf("Error reading audio: %v", err)
+			return fmt.Errorf("error reading audio: %w", err)
+		}
+
+		// Wait until the frame is due, less the frames we may send ahead
+		due := start.Add(time.Duration(sent-opusSendAhead) * opusFrameDuration)
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				if !startFade() {
+					return nil
+				}
+			case <-fading:
+				timer.Stop()
+				return nil
 			}
-			break
 		}
 
-		// Send the audio data to Discord
-		if n > 0 {
-			vc.Conn.OpusSend <- buf[:n]
+		for sending := true; sending; {
+			select {
+			case vc.Conn.OpusSender() <- frame:
+				sending = false
+			case <-stop:
+				if !startFade() {
+					return nil
+				}
+			case <-fading:
+				return nil
+			}
 		}
 	}
-
-	return nil
 }
 
 // StopAudio stops playing audio
@@ -158,7 +278,70 @@ func (vc *VoiceConnection) StopAudio() {
 	vc.Mu.Lock()
 	defer vc.Mu.Unlock()
 
+	vc.stopLocked()
+}
+
+// close stops playback for good when the bot leaves the channel
+func (vc *VoiceConnection) close() {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	vc.Closed = true
+	vc.stopLocked()
+}
+
+// stopLocked stops playback, including a send that is waiting on the
+// connection. vc.Mu must be held.
+func (vc *VoiceConnection) stopLocked() {
 	vc.Stopping = true
+	if vc.stop != nil {
+		close(vc.stop)
+		vc.stop = nil
+	}
+	vc.unpauseLocked()
+}
+
+// Pause pauses the audio that is playing. It reports whether playback was
+// paused, which it isn't if nothing is playing or it was already paused.
+func (vc *VoiceConnection) Pause() bool {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	if !vc.Playing || vc.Paused || vc.Stopping {
+		return false
+	}
+	vc.Paused = true
+	vc.resume = make(chan struct{})
+	return true
+}
+
+// Resume resumes paused audio. It reports whether playback was paused.
+func (vc *VoiceConnection) Resume() bool {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	if !vc.Paused {
+		return false
+	}
+	vc.unpauseLocked()
+	return true
+}
+
+// unpauseLocked releases paused playback. vc.Mu must be held.
+func (vc *VoiceConnection) unpauseLocked() {
+	if vc.resume != nil {
+		close(vc.resume)
+		vc.resume = nil
+	}
+	vc.Paused = false
+}
+
+// IsPaused returns whether audio is paused
+func (vc *VoiceConnection) IsPaused() bool {
+	vc.Mu.Lock()
+	defer vc.Mu.Unlock()
+
+	return vc.Paused
 }
 
 // IsPlaying returns whether audio is currently playing
@@ -0,0 +1,273 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxWelcomeLength is the longest welcome or goodbye template a guild can configure
+const MaxWelcomeLength = 1000
+
+// welcomeColor is the color of welcome and goodbye embeds
+const welcomeColor = 0x00AAFF
+
+// JoinedGuildStore persists the guilds the bot has joined
+type JoinedGuildStore interface {
+	GetJoinedGuilds() ([]string, error)
+	AddJoinedGuild(guildID string) error
+	RemoveJoinedGuild(guildID string) error
+}
+
+// JoinedGuilds tells genuine guild joins apart from the guild create events
+// Discord sends on every connect and when a guild recovers from an outage
+type JoinedGuilds struct {
+	store  JoinedGuildStore
+	guilds map[string]bool
+
+	// seededBefore is set when there was no joined set to load, e.g. on the
+	// first run. Guilds the bot joined before then are recorded silently.
+	seededBefore time.Time
+
+	mu sync.Mutex
+}
+
+// NewJoinedGuilds creates an empty joined guild set
+func NewJoinedGuilds(store JoinedGuildStore) *JoinedGuilds {
+	return &JoinedGuilds{
+		store:  store,
+		guilds: make(map[string]bool),
+	}
+}
+
+// Load reads the joined set from the store. It must be called before the
+// gateway connection is opened.
+func (j *JoinedGuilds) Load(now time.Time) error {
+	guildIDs, err := j.store.GetJoinedGuilds()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err != nil || len(guildIDs) == 0 {
+		// Without a joined set every guild looks new, so only welcome
+		// guilds joined from now on
+		j.seededBefore = now
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, guildID := range guildIDs {
+		j.guilds[guildID] = true
+	}
+	return nil
+}
+
+// Join records a guild create event and reports whether it is a genuine
+// join, rather than a reconnect or a guild coming back from an outage
+func (j *JoinedGuilds) Join(g *discordgo.Guild) bool {
+	if g.Unavailable {
+		return false
+	}
+
+	j.mu.Lock()
+	if j.guilds[g.ID] {
+		j.mu.Unlock()
+		return false
+	}
+	j.guilds[g.ID] = true
+	seeding := !j.seededBefore.IsZero() && !g.JoinedAt.IsZero() && g.JoinedAt.Before(j.seededBefore)
+	j.mu.Unlock()
+
+	if err := j.store.AddJoinedGuild(g.ID); err != nil {
+		logrus.Errorf("Error recording joined guild %s: %v", g.ID, err)
+	}
+
+	return !seeding
+}
+
+// Leave forgets a guild the bot was removed from, so rejoining it counts
+// as a genuine join
+func (j *JoinedGuilds) Leave(guildID string) {
+	j.mu.Lock()
+	delete(j.guilds, guildID)
+	j.mu.Unlock()
+
+	if err := j.store.RemoveJoinedGuild(guildID); err != nil {
+		logrus.Errorf("Error removing joined guild %s: %v", guildID, err)
+	}
+}
+
+// WelcomeStore persists the member welcome and goodbye settings of guilds
+type WelcomeStore interface {
+	GetWelcomeSettings(guildID string) (*database.WelcomeSettings, error)
+	SaveWelcomeSettings(settings *database.WelcomeSettings) error
+}
+
+// sendJoinWelcome thanks a guild for adding the bot in the first text
+// channel the bot can send messages in
+func (b *Bot) sendJoinWelcome(g *discordgo.Guild) {
+	for _, channel := range g.Channels {
+		if channel.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+
+		// Check if we have permission to send messages in this channel
+		perms, err := b.Session.UserChannelPermissions(b.Session.BotUserID(), channel.ID)
+		if err != nil {
+			logrus.Warnf("Error checking permissions: %v", err)
+			continue
+		}
+
+		if perms&discordgo.PermissionSendMessages == 0 {
+			continue
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Title:       "Thanks for adding me!",
+			Description: "Use `/help` to see available commands, and `/settings welcome` to greet new members.",
+			Color:       welcomeColor,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: "Discord Bot Template",
+			},
+		}
+
+		if _, err := b.Session.ChannelMessageSendEmbed(channel.ID, embed); err != nil {
+			logrus.Warnf("Error sending welcome message: %v", err)
+		}
+		return
+	}
+}
+
+// renderWelcome fills in the placeholders of a welcome or goodbye template:
+// {user} mentions the member, {username} is their name, {guild} is the
+// server name and {memberCount} the number of members
+func renderWelcome(template string, user *discordgo.User, guild *discordgo.Guild) string {
+	return strings.NewReplacer(
+		"{user}", user.Mention(),
+		"{username}", user.Username,
+		"{guild}", guild.Name,
+		"{memberCount}", strconv.Itoa(guild.MemberCount),
+	).Replace(template)
+}
+
+// welcomeMessage builds a welcome or goodbye message from a rendered
+// template. Only the member can be pinged, and only when they are welcomed.
+func welcomeMessage(settings *database.WelcomeSettings, text string, user *discordgo.User, ping bool) *discordgo.MessageSend {
+	msg := &discordgo.MessageSend{
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if ping {
+		msg.AllowedMentions.Users = []string{user.ID}
+	}
+
+	if !settings.Embed {
+		msg.Content = text
+		return msg
+	}
+
+	msg.Embeds = []*discordgo.MessageEmbed{{
+		Description: text,
+		Color:       welcomeColor,
+		Thumbnail:   &discordgo.MessageEmbedThumbnail{URL: user.AvatarURL("")},
+	}}
+	return msg
+}
+
+// memberGuild returns a guild from the state, falling back to one with
+// only its ID so templates still render
+func (b *Bot) memberGuild(guildID string) *discordgo.Guild {
+	guild, err := b.Session.StateGuild(guildID)
+	if err != nil {
+		return &discordgo.Guild{ID: guildID}
+	}
+	return guild
+}
+
+// onGuildMemberAdd welcomes a new member in the guild's welcome channel or
+// by direct message
+func (b *Bot) onGuildMemberAdd(_ *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.User == nil || m.User.Bot {
+		return
+	}
+
+	settings, err := b.Welcomes.GetWelcomeSettings(m.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading welcome settings for guild %s: %v", m.GuildID, err)
+		return
+	}
+	if settings.WelcomeMessage == "" {
+		return
+	}
+
+	channelID := settings.ChannelID
+	if settings.DM {
+		channel, err := b.Session.UserChannelCreate(m.User.ID)
+		if err != nil {
+			logrus.Warnf("Error opening DM channel with %s: %v", m.User.ID, err)
+			return
+		}
+		channelID = channel.ID
+	}
+	if channelID == "" {
+		return
+	}
+
+	text := renderWelcome(settings.WelcomeMessage, m.User, b.memberGuild(m.GuildID))
+	if _, err := b.Session.ChannelMessageSendComplex(channelID, welcomeMessage(settings, text, m.User, true)); err != nil {
+		// Members often have DMs from server members turned off
+		logrus.Warnf("Error welcoming %s in guild %s: %v", m.User.ID, m.GuildID, err)
+	}
+}
+
+// onGuildMemberRemove says goodbye to a member in the guild's welcome channel
+func (b *Bot) onGuildMemberRemove(_ *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if m.User == nil || m.User.Bot || m.User.ID == b.Session.BotUserID() {
+		return
+	}
+
+	settings, err := b.Welcomes.GetWelcomeSettings(m.GuildID)
+	if err != nil {
+		logrus.Errorf("Error loading welcome settings for guild %s: %v", m.GuildID, err)
+		return
+	}
+	if settings.GoodbyeMessage == "" || settings.ChannelID == "" {
+		return
+	}
+
+	text := renderWelcome(settings.GoodbyeMessage, m.User, b.memberGuild(m.GuildID))
+	if _, err := b.Session.ChannelMessageSendComplex(settings.ChannelID, welcomeMessage(settings, text, m.User, false)); err != nil {
+		logrus.Warnf("Error saying goodbye to %s in guild %s: %v", m.User.ID, m.GuildID, err)
+	}
+}
+
+// describeWelcome summarizes a guild's welcome and goodbye settings
+func describeWelcome(settings *database.WelcomeSettings) string {
+	var sb strings.Builder
+
+	switch {
+	case settings.WelcomeMessage == "":
+		sb.WriteString("Welcome messages are off.")
+	case settings.DM:
+		sb.WriteString("New members are welcomed by direct message.")
+	default:
+		fmt.Fprintf(&sb, "New members are welcomed in <#%s>.", settings.ChannelID)
+	}
+
+	if settings.GoodbyeMessage == "" {
+		sb.WriteString(" Goodbye messages are off.")
+	} else {
+		fmt.Fprintf(&sb, " Goodbyes are posted in <#%s>.", settings.ChannelID)
+	}
+
+	if settings.Embed {
+		sb.WriteString(" Messages are sent as embeds.")
+	}
+	return sb.String()
+}
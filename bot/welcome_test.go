@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kalanakt/go.discord-bot/database"
+)
+
+func TestJoinedGuildsJoin(t *testing.T) {
+	store := &memoryJoinedGuilds{guilds: map[string]bool{"1": true}}
+	joined := NewJoinedGuilds(store)
+	if err := joined.Load(time.Now()); err != nil {
+		t.Fatalf("loading joined guilds: %v", err)
+	}
+
+	if joined.Join(&discordgo.Guild{ID: "1"}) {
+		t.Error("expected a known guild not to be a join")
+	}
+	if joined.Join(&discordgo.Guild{ID: "2", Unavailable: true}) {
+		t.Error("expected an unavailable guild not to be a join")
+	}
+	if !joined.Join(&discordgo.Guild{ID: "2"}) {
+		t.Error("expected a new guild to be a join")
+	}
+	if joined.Join(&discordgo.Guild{ID: "2"}) {
+		t.Error("expected a reconnect not to be a join")
+	}
+	if !store.guilds["2"] {
+		t.Error("expected the join to be persisted")
+	}
+
+	joined.Leave("2")
+	if store.guilds["2"] {
+		t.Error("expected the leave to be persisted")
+	}
+	if !joined.Join(&discordgo.Guild{ID: "2"}) {
+		t.Error("expected rejoining to be a join")
+	}
+}
+
+func TestJoinedGuildsSeedsFirstRun(t *testing.T) {
+	now := time.Now()
+	joined := NewJoinedGuilds(&memoryJoinedGuilds{})
+	if err := joined.Load(now); err != nil {
+		t.Fatalf("loading joined guilds: %v", err)
+	}
+
+	if joined.Join(&discordgo.Guild{ID: "1", JoinedAt: now.Add(-24 * time.Hour)}) {
+		t.Error("expected a guild joined before the first run not to be welcomed")
+	}
+	if !joined.Join(&discordgo.Guild{ID: "2", JoinedAt: now.Add(time.Minute)}) {
+		t.Error("expected a guild joined after the first run to be welcomed")
+	}
+}
+
+func TestGuildCreateWelcomesOnlyGenuineJoins(t *testing.T) {
+	b, session := newTestBot()
+	addTestGuild(t, session, discordgo.PermissionSendMessages)
+	guild, _ := session.State.Guild(testGuildID)
+
+	if !b.JoinedGuilds.Join(guild) {
+		t.Fatal("expected the first guild create to be a join")
+	}
+	b.sendJoinWelcome(guild)
+
+	embeds := session.Calls("ChannelMessageSendEmbed")
+	if len(embeds) != 1 || embeds[0].Args[0] != testChannelID {
+		t.Fatalf("expected the welcome in the test channel, got %v", embeds)
+	}
+
+	// Reconnecting sends another guild create for the same guild
+	if b.JoinedGuilds.Join(guild) {
+		t.Error("expected a reconnect not to be a join")
+	}
+}
+
+func TestGuildDeleteIgnoresOutages(t *testing.T) {
+	b, _ := newTestBot()
+	b.Guilds[testGuildID] = &discordgo.Guild{ID: testGuildID}
+	b.JoinedGuilds.Join(b.Guilds[testGuildID])
+
+	b.onGuildDelete(nil, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: testGuildID, Unavailable: true}})
+
+	if _, ok := b.GetGuilds()[testGuildID]; !ok {
+		t.Error("expected the guild to be kept during an outage")
+	}
+	if b.JoinedGuilds.Join(&discordgo.Guild{ID: testGuildID}) {
+		t.Error("expected the guild coming back not to be a join")
+	}
+}
+
+// addWelcomeGuild adds the test guild with welcome settings and the given member count
+func addWelcomeGuild(t *testing.T, b *Bot, session *fakeSession, settings database.WelcomeSettings) {
+	t.Helper()
+
+	addTestGuild(t, session, discordgo.PermissionSendMessages)
+	guild, _ := session.State.Guild(testGuildID)
+	guild.MemberCount = 42
+
+	settings.GuildID = testGuildID
+	if err := b.Welcomes.SaveWelcomeSettings(&settings); err != nil {
+		t.Fatalf("saving welcome settings: %v", err)
+	}
+}
+
+// newTestMember creates a member of the test guild
+func newTestMember(userID string, bot bool) *discordgo.Member {
+	return &discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: userID, Username: "gopher", Bot: bot}}
+}
+
+func TestGuildMemberAddWelcomes(t *testing.T) {
+	b, session := newTestBot()
+	addWelcomeGuild(t, b, session, database.WelcomeSettings{
+		ChannelID:      testChannelID,
+		WelcomeMessage: "Welcome {user} to {guild}, you are member #{memberCount}!",
+	})
+
+	b.onGuildMemberAdd(nil, &discordgo.GuildMemberAdd{Member: newTestMember("401", true)})
+	b.onGuildMemberAdd(nil, &discordgo.GuildMemberAdd{Member: newTestMember(testUserID, false)})
+
+	calls := session.Calls("ChannelMessageSendComplex")
+	if len(calls) != 1 || calls[0].Args[0] != testChannelID {
+		t.Fatalf("expected one welcome in the test channel, got %v", calls)
+	}
+	msg := calls[0].Args[1].(*discordgo.MessageSend)
+	if msg.Content != "Welcome <@400> to Test Guild, you are member #42!" {
+		t.Errorf("unexpected welcome: %q", msg.Content)
+	}
+	if users := msg.AllowedMentions.Users; len(users) != 1 || users[0] != testUserID {
+		t.Errorf("expected only the member to be pinged, got %v", users)
+	}
+}
+
+func TestGuildMemberAddWelcomesByDM(t *testing.T) {
+	b, session := newTestBot()
+	addWelcomeGuild(t, b, session, database.WelcomeSettings{
+		WelcomeMessage: "Welcome to {guild}, {username}!",
+		Embed:          true,
+		DM:             true,
+	})
+
+	b.onGuildMemberAdd(nil, &discordgo.GuildMemberAdd{Member: newTestMember(testUserID, false)})
+
+	calls := session.Calls("ChannelMessageSendComplex")
+	if len(calls) != 1 || calls[0].Args[0] != "dm-400" {
+		t.Fatalf("expected the welcome to be sent by DM, got %v", calls)
+	}
+	msg := calls[0].Args[1].(*discordgo.MessageSend)
+	if len(msg.Embeds) != 1 || msg.Embeds[0].Description != "Welcome to Test Guild, gopher!" {
+		t.Errorf("unexpected welcome: %+v", msg)
+	}
+}
+
+func TestGuildMemberRemoveSaysGoodbye(t *testing.T) {
+	b, session := newTestBot()
+	addWelcomeGuild(t, b, session, database.WelcomeSettings{
+		ChannelID:      testChannelID,
+		GoodbyeMessage: "{username} left, {memberCount} members remain.",
+	})
+
+	b.onGuildMemberAdd(nil, &discordgo.GuildMemberAdd{Member: newTestMember(testUserID, false)})
+	b.onGuildMemberRemove(nil, &discordgo.GuildMemberRemove{Member: newTestMember(testUserID, false)})
+
+	messages := sentMessages(session)
+	if len(messages) != 1 || messages[0].Content != "gopher left, 42 members remain." {
+		t.Fatalf("expected only the goodbye, got %v", messages)
+	}
+	if len(messages[0].AllowedMentions.Users) != 0 {
+		t.Errorf("expected goodbyes not to ping, got %v", messages[0].AllowedMentions.Users)
+	}
+}
+
+// settingsOptions builds the options of a /settings subcommand
+func settingsOptions(subcommand string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: subcommand, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options}
+}
+
+// newManagedTestGuild adds the test guild and lets the test user manage it
+func newManagedTestGuild(t *testing.T, session *fakeSession) {
+	t.Helper()
+
+	addTestGuild(t, session, discordgo.PermissionSendMessages)
+	guild, _ := session.State.Guild(testGuildID)
+	guild.OwnerID = testUserID
+}
+
+func TestSettingsWelcomeSavesAndPreviews(t *testing.T) {
+	b, session := newTestBot()
+	newManagedTestGuild(t, session)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("welcome",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: testChannelID},
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "message", Type: discordgo.ApplicationCommandOptionString, Value: "Hi {user}!"},
+	)))
+
+	settings, _ := b.Welcomes.GetWelcomeSettings(testGuildID)
+	if settings.ChannelID != testChannelID || settings.WelcomeMessage != "Hi {user}!" {
+		t.Fatalf("unexpected settings: %+v", settings)
+	}
+
+	resp := session.Responses()[0]
+	if !strings.HasPrefix(resp.Data.Content, "New members are welcomed in <#300>.") || !strings.HasSuffix(resp.Data.Content, "Preview:\n\nHi <@400>!") {
+		t.Errorf("unexpected response: %q", resp.Data.Content)
+	}
+}
+
+func TestSettingsWelcomeOff(t *testing.T) {
+	b, session := newTestBot()
+	newManagedTestGuild(t, session)
+	b.Welcomes.SaveWelcomeSettings(&database.WelcomeSettings{GuildID: testGuildID, ChannelID: testChannelID, WelcomeMessage: "Hi {user}!"})
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("welcome",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "message", Type: discordgo.ApplicationCommandOptionString, Value: "off"},
+	)))
+
+	if settings, _ := b.Welcomes.GetWelcomeSettings(testGuildID); settings.WelcomeMessage != "" {
+		t.Errorf("expected welcome messages to be off, got %q", settings.WelcomeMessage)
+	}
+}
+
+func TestSettingsGoodbyeRequiresChannel(t *testing.T) {
+	b, session := newTestBot()
+	newManagedTestGuild(t, session)
+
+	b.Commands.HandleSlashCommand(session, newTestInteraction("settings", settingsOptions("goodbye",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "message", Type: discordgo.ApplicationCommandOptionString, Value: "Bye {username}"},
+	)))
+
+	if settings, _ := b.Welcomes.GetWelcomeSettings(testGuildID); settings.GoodbyeMessage != "" {
+		t.Errorf("expected the goodbye not to be saved, got %q", settings.GoodbyeMessage)
+	}
+	if got := session.Responses()[0].Data.Content; got != "Pick a channel for the messages with `/settings welcome channel:`." {
+		t.Errorf("unexpected response: %q", got)
+	}
+}
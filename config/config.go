@@ -2,8 +2,10 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -13,6 +15,26 @@ type Config struct {
 	CommandPrefix string
 	DevGuildID    string // For development/testing with guild-specific commands
 
+	// CommandCooldown is the per-user delay between uses of the same command
+	CommandCooldown time.Duration
+
+	// CooldownStore is "memory" or "postgres" to keep cooldowns across restarts
+	CooldownStore string
+
+	// DeferAfter is how long a slash command handler can take before its
+	// response is deferred automatically. Zero disables auto-defer.
+	DeferAfter time.Duration
+
+	// CommandSyncDryRun logs slash command changes instead of applying them
+	CommandSyncDryRun bool
+
+	// AudioDir is the directory /play can play local files from. Empty
+	// limits /play to URLs.
+	AudioDir string
+
+	// FFmpegPath is the ffmpeg binary used to encode PCM and WAV tracks
+	FFmpegPath string
+
 	// Database Configuration
 	DatabaseURL string
 
@@ -38,20 +60,64 @@ func Load() (*Config, error) {
 		commandPrefix = "!"
 	}
 
-	devMode := false
-	devModeStr := strings.ToLower(os.Getenv("BOT_DEV_MODE"))
-	if devModeStr == "true" || devModeStr == "1" || devModeStr == "yes" {
-		devMode = true
+	devMode := parseBool(os.Getenv("BOT_DEV_MODE"))
+
+	commandCooldown := time.Duration(0)
+	if value := os.Getenv("BOT_COMMAND_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BOT_COMMAND_COOLDOWN: %w", err)
+		}
+		commandCooldown = d
+	}
+
+	deferAfter := 2 * time.Second
+	if value := os.Getenv("BOT_DEFER_AFTER"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BOT_DEFER_AFTER: %w", err)
+		}
+		deferAfter = d
+	}
+
+	cooldownStore := strings.ToLower(os.Getenv("BOT_COOLDOWN_STORE"))
+	switch cooldownStore {
+	case "":
+		cooldownStore = "memory"
+	case "memory", "postgres":
+	default:
+		return nil, fmt.Errorf("invalid BOT_COOLDOWN_STORE %q: must be memory or postgres", cooldownStore)
+	}
+
+	commandSyncDryRun := parseBool(os.Getenv("BOT_COMMAND_SYNC_DRY_RUN"))
+
+	audioDir := os.Getenv("BOT_AUDIO_DIR")
+
+	ffmpegPath := os.Getenv("BOT_FFMPEG_PATH")
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
 	}
 
 	// Guild ID is optional and only used in development mode
 	devGuildID := os.Getenv("BOT_DEV_GUILD_ID")
 
 	return &Config{
-		BotToken:      botToken,
-		CommandPrefix: commandPrefix,
-		DevGuildID:    devGuildID,
-		DatabaseURL:   databaseURL,
-		DevMode:       devMode,
+		BotToken:          botToken,
+		CommandPrefix:     commandPrefix,
+		DevGuildID:        devGuildID,
+		CommandCooldown:   commandCooldown,
+		CooldownStore:     cooldownStore,
+		DeferAfter:        deferAfter,
+		CommandSyncDryRun: commandSyncDryRun,
+		AudioDir:          audioDir,
+		FFmpegPath:        ffmpegPath,
+		DatabaseURL:       databaseURL,
+		DevMode:           devMode,
 	}, nil
 }
+
+// parseBool interprets common truthy values of an environment variable
+func parseBool(value string) bool {
+	value = strings.ToLower(value)
+	return value == "true" || value == "1" || value == "yes"
+}
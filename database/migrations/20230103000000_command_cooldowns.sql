@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+CREATE TABLE IF NOT EXISTS command_cooldowns (
+    key TEXT PRIMARY KEY,
+    uses INTEGER NOT NULL DEFAULT 0,
+    reset_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_cooldowns_reset_at ON command_cooldowns(reset_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+DROP TABLE IF EXISTS command_cooldowns;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+CREATE TABLE IF NOT EXISTS command_errors (
+    id SERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    command_name TEXT NOT NULL,
+    command_type TEXT NOT NULL,
+    error TEXT NOT NULL,
+    stack TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_errors_command_name ON command_errors(command_name);
+CREATE INDEX IF NOT EXISTS idx_command_errors_created_at ON command_errors(created_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+DROP TABLE IF EXISTS command_errors;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+CREATE TABLE IF NOT EXISTS role_audit (
+    id SERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    role_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_audit_guild_id ON role_audit(guild_id);
+CREATE INDEX IF NOT EXISTS idx_role_audit_created_at ON role_audit(created_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+DROP TABLE IF EXISTS role_audit;
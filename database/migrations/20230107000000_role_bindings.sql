@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+CREATE TABLE IF NOT EXISTS role_bindings (
+    id SERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    emoji TEXT NOT NULL DEFAULT '',
+    label TEXT NOT NULL DEFAULT '',
+    role_id TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (message_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_bindings_guild_id ON role_bindings(guild_id);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+DROP TABLE IF EXISTS role_bindings;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+ALTER TABLE guild_settings
+    ADD COLUMN IF NOT EXISTS welcome_channel_id TEXT,
+    ADD COLUMN IF NOT EXISTS welcome_message TEXT,
+    ADD COLUMN IF NOT EXISTS goodbye_message TEXT,
+    ADD COLUMN IF NOT EXISTS welcome_embed BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS welcome_dm BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS joined_guilds (
+    guild_id TEXT PRIMARY KEY,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+DROP TABLE IF EXISTS joined_guilds;
+
+ALTER TABLE guild_settings
+    DROP COLUMN IF EXISTS welcome_channel_id,
+    DROP COLUMN IF EXISTS welcome_message,
+    DROP COLUMN IF EXISTS goodbye_message,
+    DROP COLUMN IF EXISTS welcome_embed,
+    DROP COLUMN IF EXISTS welcome_dm;
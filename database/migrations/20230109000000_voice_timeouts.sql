@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+ALTER TABLE guild_settings
+    ADD COLUMN IF NOT EXISTS voice_empty_timeout INTEGER NOT NULL DEFAULT 60,
+    ADD COLUMN IF NOT EXISTS voice_idle_timeout INTEGER NOT NULL DEFAULT 300;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+ALTER TABLE guild_settings
+    DROP COLUMN IF EXISTS voice_empty_timeout,
+    DROP COLUMN IF EXISTS voice_idle_timeout;
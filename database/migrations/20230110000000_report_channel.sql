@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied
+ALTER TABLE guild_settings
+    ADD COLUMN IF NOT EXISTS report_channel_id TEXT;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back
+ALTER TABLE guild_settings
+    DROP COLUMN IF EXISTS report_channel_id;
@@ -42,6 +42,66 @@ type InteractionEvent struct {
 	CreatedAt      time.Time
 }
 
+// CommandError represents a failed command or event handler
+type CommandError struct {
+	ID          int64
+	GuildID     string
+	ChannelID   string
+	UserID      string
+	CommandName string
+	CommandType string
+	Error       string
+	Stack       string
+	CreatedAt   time.Time
+}
+
+// TempRole is a role that is removed from a member once it expires
+type TempRole struct {
+	ID        int64
+	GuildID   string
+	UserID    string
+	RoleID    string
+	ExpiresAt time.Time
+}
+
+// RoleBinding grants a role when a member reacts to a message with an
+// emoji or clicks a button on it
+type RoleBinding struct {
+	ID        int64
+	GuildID   string
+	ChannelID string
+	MessageID string
+	Kind      string // "reaction" or "button"
+	Emoji     string // Reaction emoji, or the button's emoji if it has one
+	Label     string // Button label
+	RoleID    string
+	Mode      string // Shared by every binding on the message
+}
+
+// WelcomeSettings configures the messages posted when members join or leave a guild
+type WelcomeSettings struct {
+	GuildID        string
+	ChannelID      string
+	WelcomeMessage string // Empty when welcome messages are off
+	GoodbyeMessage string // Empty when goodbye messages are off
+	Embed          bool   // Post messages as embeds
+	DM             bool   // Send welcome messages to the new member instead of the channel
+}
+
+// Default voice auto-disconnect timeouts, matching the column defaults
+const (
+	DefaultVoiceEmptyTimeout = time.Minute
+	DefaultVoiceIdleTimeout  = 5 * time.Minute
+)
+
+// VoiceSettings configures when the bot leaves a voice channel on its own.
+// A zero timeout turns that kind of auto-disconnect off.
+type VoiceSettings struct {
+	GuildID      string
+	EmptyTimeout time.Duration // How long to stay once everyone else has left
+	IdleTimeout  time.Duration // How long to stay without playing anything
+}
+
 // BotStats represents bot statistics
 type BotStats struct {
 	ID             int64
@@ -77,6 +137,48 @@ func (r *Repository) LogCommand(guildID, channelID, userID, commandName, command
 	return nil
 }
 
+// LogCommandError records a command or event handler failure
+func (r *Repository) LogCommandError(guildID, channelID, userID, commandName, commandType, errorMessage, stack string) error {
+	_, err := r.db.Exec(
+		"INSERT INTO command_errors (guild_id, channel_id, user_id, command_name, command_type, error, stack) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))",
+		guildID, channelID, userID, commandName, commandType, errorMessage, stack,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to log command error: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetRecentCommandErrors retrieves recent command failures
+func (r *Repository) GetRecentCommandErrors(limit int) ([]CommandError, error) {
+	rows, err := r.db.Query(
+		"SELECT id, guild_id, channel_id, user_id, command_name, command_type, error, COALESCE(stack, ''), created_at FROM command_errors ORDER BY created_at DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commandErrors []CommandError
+	for rows.Next() {
+		var e CommandError
+		err := rows.Scan(&e.ID, &e.GuildID, &e.ChannelID, &e.UserID, &e.CommandName, &e.CommandType, &e.Error, &e.Stack, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		commandErrors = append(commandErrors, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return commandErrors, nil
+}
+
 // LogInteraction records an interaction event
 func (r *Repository) LogInteraction(guildID, channelID, userID, interactionType, componentID string, data map[string]interface{}) error {
 	dataJSON, err := json.Marshal(data)
@@ -221,4 +323,393 @@ func (r *Repository) GetRecentInteractions(limit int) ([]InteractionEvent, error
 	}
 
 	return events, nil
-}
\ No newline at end of file
+}
+
+// GetGuildPrefix retrieves the command prefix configured for a guild.
+// An empty string means the guild uses the default prefix.
+func (r *Repository) GetGuildPrefix(guildID string) (string, error) {
+	var prefix sql.NullString
+	err := r.db.QueryRow(
+		"SELECT command_prefix FROM guild_settings WHERE guild_id = $1",
+		guildID,
+	).Scan(&prefix)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return prefix.String, nil
+}
+
+// SetGuildPrefix stores the command prefix for a guild. An empty prefix
+// resets the guild to the default prefix.
+func (r *Repository) SetGuildPrefix(guildID, prefix string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO guild_settings (guild_id, command_prefix) VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (guild_id) DO UPDATE SET command_prefix = EXCLUDED.command_prefix, updated_at = NOW()`,
+		guildID, prefix,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to set guild prefix: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// TakeCooldown records a use of a rate-limited key. Up to burst uses are
+// allowed per period; once exceeded, the time until the window resets is
+// returned. A zero duration means the use is allowed.
+func (r *Repository) TakeCooldown(key string, period time.Duration, burst int) (time.Duration, error) {
+	var uses int
+	var resetAt time.Time
+	err := r.db.QueryRow(
+		`INSERT INTO command_cooldowns (key, uses, reset_at) VALUES ($1, 1, NOW() + $2 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET
+			uses = CASE WHEN command_cooldowns.reset_at <= NOW() THEN 1 ELSE command_cooldowns.uses + 1 END,
+			reset_at = CASE WHEN command_cooldowns.reset_at <= NOW() THEN EXCLUDED.reset_at ELSE command_cooldowns.reset_at END
+		RETURNING uses, reset_at`,
+		key, period.Milliseconds(),
+	).Scan(&uses, &resetAt)
+	if err != nil {
+		return 0, err
+	}
+
+	if uses > burst {
+		return time.Until(resetAt), nil
+	}
+
+	return 0, nil
+}
+
+// DeleteExpiredCooldowns removes cooldown windows that have already reset
+func (r *Repository) DeleteExpiredCooldowns() error {
+	_, err := r.db.Exec("DELETE FROM command_cooldowns WHERE reset_at <= NOW()")
+	return err
+}
+
+// LogRoleChange records a role being given to or taken from a member
+func (r *Repository) LogRoleChange(guildID, actorID, userID, roleID, action string) error {
+	_, err := r.db.Exec(
+		"INSERT INTO role_audit (guild_id, actor_id, user_id, role_id, action) VALUES ($1, $2, $3, $4, $5)",
+		guildID, actorID, userID, roleID, action,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to log role change: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// AddTempRole schedules a role to be removed from a member at expiresAt.
+// Scheduling the same role for the same member again replaces the expiry.
+func (r *Repository) AddTempRole(guildID, userID, roleID string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO temp_roles (guild_id, user_id, role_id, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (guild_id, user_id, role_id) DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+		guildID, userID, roleID, expiresAt,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to add temporary role: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTempRole cancels the scheduled removal of a role from a member
+func (r *Repository) DeleteTempRole(guildID, userID, roleID string) error {
+	_, err := r.db.Exec(
+		"DELETE FROM temp_roles WHERE guild_id = $1 AND user_id = $2 AND role_id = $3",
+		guildID, userID, roleID,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to delete temporary role: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetExpiredTempRoles retrieves temporary roles that expired at or before now
+func (r *Repository) GetExpiredTempRoles(now time.Time, limit int) ([]TempRole, error) {
+	rows, err := r.db.Query(
+		"SELECT id, guild_id, user_id, role_id, expires_at FROM temp_roles WHERE expires_at <= $1 ORDER BY expires_at LIMIT $2",
+		now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []TempRole
+	for rows.Next() {
+		var role TempRole
+		if err := rows.Scan(&role.ID, &role.GuildID, &role.UserID, &role.RoleID, &role.ExpiresAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// SaveRoleBinding stores a role binding, replacing any earlier binding of
+// the same role on the message. The binding's mode is applied to every
+// binding on the message.
+func (r *Repository) SaveRoleBinding(binding RoleBinding) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		logrus.Errorf("Failed to save role binding: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO role_bindings (guild_id, channel_id, message_id, kind, emoji, label, role_id, mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (message_id, role_id) DO UPDATE SET
+			kind = EXCLUDED.kind, emoji = EXCLUDED.emoji, label = EXCLUDED.label, mode = EXCLUDED.mode`,
+		binding.GuildID, binding.ChannelID, binding.MessageID, binding.Kind, binding.Emoji, binding.Label, binding.RoleID, binding.Mode,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to save role binding: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE role_bindings SET mode = $1 WHERE message_id = $2", binding.Mode, binding.MessageID)
+	if err != nil {
+		logrus.Errorf("Failed to update role binding mode: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRoleBinding removes the binding of a role on a message. It reports
+// whether there was one.
+func (r *Repository) DeleteRoleBinding(messageID, roleID string) (bool, error) {
+	result, err := r.db.Exec("DELETE FROM role_bindings WHERE message_id = $1 AND role_id = $2", messageID, roleID)
+	if err != nil {
+		logrus.Errorf("Failed to delete role binding: %v", err)
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
+
+// GetGuildRoleBindings retrieves every role binding in a guild
+func (r *Repository) GetGuildRoleBindings(guildID string) ([]RoleBinding, error) {
+	rows, err := r.db.Query(
+		"SELECT id, guild_id, channel_id, message_id, kind, emoji, label, role_id, mode FROM role_bindings WHERE guild_id = $1 ORDER BY id",
+		guildID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bindings []RoleBinding
+	for rows.Next() {
+		var b RoleBinding
+		if err := rows.Scan(&b.ID, &b.GuildID, &b.ChannelID, &b.MessageID, &b.Kind, &b.Emoji, &b.Label, &b.RoleID, &b.Mode); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
+
+// GetWelcomeSettings retrieves the welcome and goodbye settings for a guild.
+// Guilds without settings get empty settings, with both messages off.
+func (r *Repository) GetWelcomeSettings(guildID string) (*WelcomeSettings, error) {
+	settings := &WelcomeSettings{GuildID: guildID}
+	var channelID, welcomeMessage, goodbyeMessage sql.NullString
+	err := r.db.QueryRow(
+		"SELECT welcome_channel_id, welcome_message, goodbye_message, welcome_embed, welcome_dm FROM guild_settings WHERE guild_id = $1",
+		guildID,
+	).Scan(&channelID, &welcomeMessage, &goodbyeMessage, &settings.Embed, &settings.DM)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return settings, nil
+		}
+		return nil, err
+	}
+
+	settings.ChannelID = channelID.String
+	settings.WelcomeMessage = welcomeMessage.String
+	settings.GoodbyeMessage = goodbyeMessage.String
+
+	return settings, nil
+}
+
+// SaveWelcomeSettings stores the welcome and goodbye settings for a guild
+func (r *Repository) SaveWelcomeSettings(settings *WelcomeSettings) error {
+	_, err := r.db.Exec(
+		`INSERT INTO guild_settings (guild_id, welcome_channel_id, welcome_message, goodbye_message, welcome_embed, welcome_dm)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6)
+		ON CONFLICT (guild_id) DO UPDATE SET
+			welcome_channel_id = EXCLUDED.welcome_channel_id,
+			welcome_message = EXCLUDED.welcome_message,
+			goodbye_message = EXCLUDED.goodbye_message,
+			welcome_embed = EXCLUDED.welcome_embed,
+			welcome_dm = EXCLUDED.welcome_dm,
+			updated_at = NOW()`,
+		settings.GuildID, settings.ChannelID, settings.WelcomeMessage, settings.GoodbyeMessage, settings.Embed, settings.DM,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to save welcome settings: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetJoinedGuilds retrieves the IDs of every guild the bot has joined
+func (r *Repository) GetJoinedGuilds() ([]string, error) {
+	rows, err := r.db.Query("SELECT guild_id FROM joined_guilds")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guildIDs []string
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			return nil, err
+		}
+		guildIDs = append(guildIDs, guildID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return guildIDs, nil
+}
+
+// AddJoinedGuild records that the bot has joined a guild
+func (r *Repository) AddJoinedGuild(guildID string) error {
+	_, err := r.db.Exec("INSERT INTO joined_guilds (guild_id) VALUES ($1) ON CONFLICT (guild_id) DO NOTHING", guildID)
+	if err != nil {
+		logrus.Errorf("Failed to add joined guild: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveJoinedGuild records that the bot has left a guild
+func (r *Repository) RemoveJoinedGuild(guildID string) error {
+	_, err := r.db.Exec("DELETE FROM joined_guilds WHERE guild_id = $1", guildID)
+	if err != nil {
+		logrus.Errorf("Failed to remove joined guild: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetVoiceSettings retrieves the voice auto-disconnect settings for a guild.
+// Guilds without settings get the default timeouts.
+func (r *Repository) GetVoiceSettings(guildID string) (*VoiceSettings, error) {
+	settings := &VoiceSettings{
+		GuildID:      guildID,
+		EmptyTimeout: DefaultVoiceEmptyTimeout,
+		IdleTimeout:  DefaultVoiceIdleTimeout,
+	}
+	var emptySeconds, idleSeconds int64
+	err := r.db.QueryRow(
+		"SELECT voice_empty_timeout, voice_idle_timeout FROM guild_settings WHERE guild_id = $1",
+		guildID,
+	).Scan(&emptySeconds, &idleSeconds)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return settings, nil
+		}
+		return nil, err
+	}
+
+	settings.EmptyTimeout = time.Duration(emptySeconds) * time.Second
+	settings.IdleTimeout = time.Duration(idleSeconds) * time.Second
+
+	return settings, nil
+}
+
+// SaveVoiceSettings stores the voice auto-disconnect settings for a guild
+func (r *Repository) SaveVoiceSettings(settings *VoiceSettings) error {
+	_, err := r.db.Exec(
+		`INSERT INTO guild_settings (guild_id, voice_empty_timeout, voice_idle_timeout)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (guild_id) DO UPDATE SET
+			voice_empty_timeout = EXCLUDED.voice_empty_timeout,
+			voice_idle_timeout = EXCLUDED.voice_idle_timeout,
+			updated_at = NOW()`,
+		settings.GuildID, int64(settings.EmptyTimeout/time.Second), int64(settings.IdleTimeout/time.Second),
+	)
+	if err != nil {
+		logrus.Errorf("Failed to save voice settings: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetReportChannel retrieves the channel message reports are posted in for
+// a guild. It returns an empty string if the guild hasn't set one.
+func (r *Repository) GetReportChannel(guildID string) (string, error) {
+	var channelID sql.NullString
+	err := r.db.QueryRow(
+		"SELECT report_channel_id FROM guild_settings WHERE guild_id = $1",
+		guildID,
+	).Scan(&channelID)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return channelID.String, nil
+}
+
+// SaveReportChannel stores the channel message reports are posted in for a
+// guild. An empty channel ID turns reports off.
+func (r *Repository) SaveReportChannel(guildID, channelID string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO guild_settings (guild_id, report_channel_id)
+		VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (guild_id) DO UPDATE SET
+			report_channel_id = EXCLUDED.report_channel_id,
+			updated_at = NOW()`,
+		guildID, channelID,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to save report channel: %v", err)
+		return err
+	}
+
+	return nil
+}